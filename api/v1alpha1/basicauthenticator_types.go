@@ -17,14 +17,17 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // BasicAuthenticatorSpec defines the desired state of BasicAuthenticator
 type BasicAuthenticatorSpec struct {
 	// +kubebuilder:validation:Required
-	// +kubebuilder:validation:Enum=sidecar;deployment
-	// Type is used to determine that nginx should be sidercar or deployment
+	// +kubebuilder:validation:Enum=sidecar;deployment;forwardauth
+	// Type is used to determine that nginx should be sidercar or deployment, or a central forwardauth
+	// service answering the authentication subrequests of an ingress controller
 	Type string `json:"type"`
 
 	// +kubebuilder:validation:Optional
@@ -35,37 +38,780 @@ type BasicAuthenticatorSpec struct {
 	// +kubebuilder:validation:Optional
 	Selector metav1.LabelSelector `json:"selector,omitempty"`
 
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Workload;PodWebhook
+	// +kubebuilder:default=Workload
+	// InjectionMode decides how the sidecar reaches selected pods. Workload edits the pod template of
+	// selected Deployments, PodWebhook injects it into pods as they are created and leaves workloads untouched.
+	InjectionMode string `json:"injectionMode,omitempty"`
+
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default=ClusterIP
 	ServiceType string `json:"serviceType"`
 
-	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Optional
+	// AppPort is required by the sidecar and deployment types
 	AppPort int `json:"appPort"`
 
 	// +kubebuilder:validation:Optional
 	AppService string `json:"appService"`
 
+	// +kubebuilder:validation:Optional
+	// ServiceTakeover points AppService at the authenticator, so callers keep their address and are
+	// authenticated transparently. The original selector is kept in a backing service the authenticator
+	// proxies to, and AppService is rolled back once takeover is disabled or the BasicAuthenticator deleted.
+	// Only with the deployment type.
+	ServiceTakeover bool `json:"serviceTakeover,omitempty"`
+
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default=false
 	AdaptiveScale bool `json:"adaptiveScale"`
 
+	// +kubebuilder:validation:Optional
+	// AdaptiveScaling tunes how AdaptiveScale derives the authenticator replicas from the application's replicas
+	AdaptiveScaling *AdaptiveScalingConfig `json:"adaptiveScaling,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Autoscaling has the controller manage a HorizontalPodAutoscaler for the authenticator deployment,
+	// which then owns its replica count. Not used with the sidecar type.
+	Autoscaling *AutoscalingConfig `json:"autoscaling,omitempty"`
+
 	// +kubebuilder:validation:Required
-	// +kubebuilder:default=80
+	// +kubebuilder:default=8080
+	// AuthenticatorPort is where nginx listens. nginx runs unprivileged unless the operator is configured
+	// otherwise, so ports below 1024 are rejected.
 	AuthenticatorPort int `json:"authenticatorPort"`
 
 	// +kubebuilder:validation:Optional
 	CredentialsSecretRef string `json:"credentialsSecretRef"`
+
+	// +kubebuilder:validation:Optional
+	// CredentialsSecretRefs lists secrets, each holding one username and password, that are merged
+	// together with CredentialsSecretRef into a single htpasswd file. Removing a secret from the list
+	// revokes its user without touching the others.
+	CredentialsSecretRefs []string `json:"credentialsSecretRefs,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// LDAP checks credentials against a directory instead of htpasswd files built from secrets. The
+	// authenticator pods get an auth server container nginx asks for every request.
+	LDAP *LDAPConfig `json:"ldap,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// OIDC lets people log in through an identity provider, while requests with an Authorization header
+	// keep being checked as Basic auth. Sessions are kept in a signed cookie.
+	OIDC *OIDCConfig `json:"oidc,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// APIKeys accepts keys in a header alongside the Basic credentials, for clients sending
+	// Authorization: Bearer or X-API-Key instead. The auth server checks them against their hashes.
+	APIKeys *APIKeysConfig `json:"apiKeys,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// JWT accepts signed tokens in the Authorization header alongside the Basic credentials and passes
+	// selected claims upstream as headers
+	JWT *JWTConfig `json:"jwt,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// MTLS makes nginx verify client certificates against a CA bundle, on their own or together with the
+	// Basic credentials. Requires TLS.
+	MTLS *MTLSConfig `json:"mtls,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// CredentialsRotation rotates the generated credentials. It has no effect on user supplied credentials.
+	CredentialsRotation *CredentialsRotation `json:"credentialsRotation,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=apr1;bcrypt;sha256;sha512
+	// HashAlgorithm is used to hash passwords into the htpasswd file. Defaults to the operator's configuration, or apr1.
+	HashAlgorithm string `json:"hashAlgorithm,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=4
+	// +kubebuilder:validation:Maximum=14
+	// BcryptCost is the cost of bcrypt hashes. nginx pays it on every request, so keep it low.
+	BcryptCost int `json:"bcryptCost,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Proxy tunes how nginx proxies requests to the application
+	Proxy *ProxyConfig `json:"proxy,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// ServerSnippet is raw nginx configuration added to the server block. Directives that could bypass
+	// authentication or reach the authenticator's filesystem are rejected.
+	ServerSnippet string `json:"serverSnippet,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// LocationSnippet is raw nginx configuration added to the authenticated location block, with the
+	// same restrictions as ServerSnippet
+	LocationSnippet string `json:"locationSnippet,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Paths overrides authentication for specific paths, e.g. to let health checks and metrics
+	// scrapes through. Paths not matched by any rule require a valid user.
+	Paths []PathRule `json:"paths,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// ForwardAuth wires ingress controllers to the authenticator when Type is forwardauth
+	ForwardAuth *ForwardAuthConfig `json:"forwardAuth,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Expose publishes the authenticator service outside the cluster, only with the deployment type
+	Expose *ExposeConfig `json:"expose,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// TLS terminates TLS on the authenticator so credentials are never sent in the clear
+	TLS *TLSConfig `json:"tls,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// PodTemplate customizes the pods of the authenticator deployment. It is not used with the sidecar
+	// type, whose pods belong to the application.
+	PodTemplate *PodTemplateOverrides `json:"podTemplate,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Container customizes the nginx container, in the authenticator deployment as well as the sidecar.
+	// It takes precedence over the operator's defaults.
+	Container *ContainerOverrides `json:"container,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// AuthServer customizes the auth server container, run for LDAP, OIDC, API keys, JWTs and client
+	// certificates. It takes precedence over the operator's defaults.
+	AuthServer *ContainerOverrides `json:"authServer,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Availability tunes the PodDisruptionBudget and topology spread the authenticator deployment gets
+	// once it runs more than one replica. It is not used with the sidecar type.
+	Availability *AvailabilityConfig `json:"availability,omitempty"`
+}
+
+// AvailabilityConfig keeps authenticator replicas up through node drains and zone outages. By default the
+// deployment is spread over zones and nodes and a PodDisruptionBudget allows one replica to be disrupted.
+type AvailabilityConfig struct {
+	// +kubebuilder:validation:Optional
+	// MinAvailable of the PodDisruptionBudget, mutually exclusive with MaxUnavailable
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// MaxUnavailable of the PodDisruptionBudget, 1 unless MinAvailable is set
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// DisablePodDisruptionBudget skips the PodDisruptionBudget, e.g. when the namespace brings its own
+	DisablePodDisruptionBudget bool `json:"disablePodDisruptionBudget,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// TopologySpreadConstraints replace the default spread. Constraints without a labelSelector select
+	// the authenticator pods.
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// DisableTopologySpread leaves scheduling to the pod template's affinity alone
+	DisableTopologySpread bool `json:"disableTopologySpread,omitempty"`
+}
+
+// PodTemplateOverrides are merged into the pod template of the authenticator deployment
+type PodTemplateOverrides struct {
+	// +kubebuilder:validation:Optional
+	// Labels are added to the pods, except for the ones the deployment selects its pods by
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	SecurityContext *corev1.PodSecurityContext `json:"securityContext,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+}
+
+// ContainerOverrides are merged into the nginx container
+type ContainerOverrides struct {
+	// +kubebuilder:validation:Optional
+	// Resources are merged per resource name into the operator's default resources
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	LivenessProbe *corev1.Probe `json:"livenessProbe,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	ReadinessProbe *corev1.Probe `json:"readinessProbe,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Always;IfNotPresent;Never
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+}
+
+// ForwardAuthConfig selects the ingress controllers delegating authentication to a forwardauth authenticator
+type ForwardAuthConfig struct {
+	// +kubebuilder:validation:Optional
+	// Ingresses in the BasicAuthenticator's namespace that get ingress-nginx auth-url annotations
+	Ingresses []string `json:"ingresses,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// TraefikMiddleware creates a Traefik ForwardAuth Middleware named after the BasicAuthenticator
+	TraefikMiddleware bool `json:"traefikMiddleware,omitempty"`
+}
+
+const (
+	ExposeKindIngress   = "Ingress"
+	ExposeKindRoute     = "Route"
+	ExposeKindHTTPRoute = "HTTPRoute"
+)
+
+// AdaptiveScalingConfig derives the authenticator replicas from the replicas of the workload behind AppService
+type AdaptiveScalingConfig struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="0.5"
+	// +kubebuilder:validation:Pattern=`^[0-9]*\.?[0-9]+$`
+	// Ratio of authenticator replicas to application replicas, rounded up
+	Ratio string `json:"ratio,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// MinReplicas is the least number of authenticator replicas, even when the application has fewer
+	MinReplicas int32 `json:"minReplicas,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// MaxReplicas bounds the authenticator replicas, unbounded when unset
+	MaxReplicas int32 `json:"maxReplicas,omitempty"`
+}
+
+// AutoscalingConfig describes the HorizontalPodAutoscaler of the authenticator deployment. At least one
+// target is required.
+type AutoscalingConfig struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1
+	MinReplicas int32 `json:"minReplicas,omitempty"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// TargetCPUUtilization is the average CPU usage to keep, in percent of the authenticator's CPU request
+	TargetCPUUtilization *int32 `json:"targetCPUUtilization,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// TargetMemoryUtilization is the average memory usage to keep, in percent of the authenticator's memory request
+	TargetMemoryUtilization *int32 `json:"targetMemoryUtilization,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// RequestsPerSecond scales on the requests each replica serves, as reported by an nginx exporter
+	// added to the authenticator pods
+	RequestsPerSecond *RequestsPerSecondTarget `json:"requestsPerSecond,omitempty"`
+}
+
+const (
+	// NginxStatusPort serves stub_status to the metrics exporter on the loopback interface only
+	NginxStatusPort = 18080
+	// ExporterPort is where the metrics exporter serves prometheus metrics
+	ExporterPort = 9113
+	// AuthServerPort is where the auth server answers nginx's subrequests, on the loopback interface only
+	AuthServerPort = 18081
+	// OIDCClientSecretKey is the key of the client secret in the secret named by oidc.clientSecretRef
+	OIDCClientSecretKey = "clientSecret"
+	// APIKeySecretKey is the key of the API key in the secrets named by apiKeys.secretRefs
+	APIKeySecretKey = "apiKey"
+	// MTLSDefaultCAKey is the key of the CA bundle in the configmap or secret named by mtls
+	MTLSDefaultCAKey = "ca.crt"
+)
+
+// RequestsPerSecondTarget is a pods metric target served by a metrics adapter, such as prometheus-adapter,
+// from the exporter's nginx_http_requests_total counter
+type RequestsPerSecondTarget struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+	// AverageValue is the requests per second each replica should serve
+	AverageValue int32 `json:"averageValue"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=nginx_http_requests_per_second
+	// MetricName is the name the metrics adapter serves the request rate under
+	MetricName string `json:"metricName,omitempty"`
+}
+
+// ExposeConfig describes the Ingress, OpenShift Route or Gateway API HTTPRoute created for the authenticator service
+type ExposeConfig struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Enum=Ingress;Route;HTTPRoute
+	Kind string `json:"kind"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Host string `json:"host"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=/
+	// +kubebuilder:validation:Pattern=`^/`
+	// Path prefix routed to the authenticator
+	Path string `json:"path,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// IngressClassName of the Ingress
+	IngressClassName *string `json:"ingressClassName,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Annotations added to the created object, e.g. for the ingress controller
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// TLS serves Host over HTTPS. Routes use edge termination with the router's certificate, or passthrough
+	// when the authenticator terminates TLS itself. HTTPRoutes rely on the Gateway listener instead.
+	TLS *ExposeTLSConfig `json:"tls,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Gateway the HTTPRoute attaches to
+	Gateway *GatewayRef `json:"gateway,omitempty"`
 }
 
+// ExposeTLSConfig enables HTTPS on the exposed host
+type ExposeTLSConfig struct {
+	// +kubebuilder:validation:Optional
+	// SecretName is the Ingress's TLS secret, the ingress controller's default certificate is used without it
+	SecretName string `json:"secretName,omitempty"`
+}
+
+// GatewayRef references a Gateway API Gateway
+type GatewayRef struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Optional
+	// Namespace of the Gateway, defaults to the BasicAuthenticator's namespace
+	Namespace string `json:"namespace,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// SectionName selects a listener of the Gateway
+	SectionName string `json:"sectionName,omitempty"`
+}
+
+// TLSConfig selects the certificate the authenticator serves. Exactly one of SecretName and CertManager is set.
+type TLSConfig struct {
+	// +kubebuilder:validation:Optional
+	// SecretName is a kubernetes.io/tls secret in the BasicAuthenticator's namespace
+	SecretName string `json:"secretName,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// CertManager asks cert-manager for a certificate covering the authenticator service's DNS names
+	CertManager *CertManagerConfig `json:"certManager,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// HTTPRedirectPort is a plain HTTP port redirecting every request to AuthenticatorPort over HTTPS.
+	// No redirect is served when unset.
+	HTTPRedirectPort int `json:"httpRedirectPort,omitempty"`
+}
+
+// CertManagerConfig describes the cert-manager Certificate created for the authenticator
+type CertManagerConfig struct {
+	// +kubebuilder:validation:Required
+	IssuerRef CertManagerIssuerRef `json:"issuerRef"`
+
+	// +kubebuilder:validation:Optional
+	// DNSNames are added to the service's DNS names, e.g. for an ingress host
+	DNSNames []string `json:"dnsNames,omitempty"`
+}
+
+// CertManagerIssuerRef references a cert-manager Issuer or ClusterIssuer
+type CertManagerIssuerRef struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=Issuer
+	Kind string `json:"kind,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=cert-manager.io
+	Group string `json:"group,omitempty"`
+}
+
+const (
+	InjectionModeWorkload   = "Workload"
+	InjectionModePodWebhook = "PodWebhook"
+)
+
+const (
+	PathMatchPrefix = "Prefix"
+	PathMatchExact  = "Exact"
+	PathMatchRegex  = "Regex"
+
+	PathAuthRequired = "Required"
+	PathAuthBypass   = "Bypass"
+	PathAuthUsers    = "Users"
+
+	MTLSSatisfyCertificate = "Certificate"
+	MTLSSatisfyAny         = "Any"
+	MTLSSatisfyAll         = "All"
+)
+
+// PathRule selects requests by path and decides how they are authenticated
+type PathRule struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	// Path is a path prefix, an exact path or a regular expression depending on MatchType
+	Path string `json:"path"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Prefix;Exact;Regex
+	// +kubebuilder:default=Prefix
+	MatchType string `json:"matchType,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Required;Bypass;Users
+	// +kubebuilder:default=Required
+	// Auth is Required for any valid user, Bypass for no authentication or Users for only the listed users
+	Auth string `json:"auth,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Users allowed on the path when Auth is Users
+	Users []string `json:"users,omitempty"`
+}
+
+// ProxyConfig holds the nginx proxy settings exposed on the spec
+type ProxyConfig struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^[0-9]+(ms|s|m|h)?$`
+	// ConnectTimeout is nginx's proxy_connect_timeout, e.g. 5s
+	ConnectTimeout string `json:"connectTimeout,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^[0-9]+(ms|s|m|h)?$`
+	// ReadTimeout is nginx's proxy_read_timeout, e.g. 60s
+	ReadTimeout string `json:"readTimeout,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^[0-9]+(ms|s|m|h)?$`
+	// SendTimeout is nginx's proxy_send_timeout, e.g. 60s
+	SendTimeout string `json:"sendTimeout,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^[0-9]+[kKmMgG]?$`
+	// ClientMaxBodySize is nginx's client_max_body_size, e.g. 10m. 0 disables the check.
+	ClientMaxBodySize string `json:"clientMaxBodySize,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^[0-9]+[kKmM]?$`
+	// BufferSize is nginx's proxy_buffer_size, e.g. 8k
+	BufferSize string `json:"bufferSize,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Buffering toggles nginx's proxy_buffering
+	Buffering *bool `json:"buffering,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// RequestHeaders are set on requests proxied to the application. Values may use nginx variables.
+	RequestHeaders map[string]string `json:"requestHeaders,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// ResponseHeaders are added to responses sent back to clients
+	ResponseHeaders map[string]string `json:"responseHeaders,omitempty"`
+}
+
+// LDAPConfig describes the directory users are checked against. A user is authenticated if UserFilter,
+// combined with GroupFilter, finds exactly one entry under BaseDN and the directory accepts a bind to it
+// with the user's password.
+type LDAPConfig struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^ldaps?://`
+	// URL of the directory, e.g. ldaps://ldap.example.org:636
+	URL string `json:"url"`
+
+	// +kubebuilder:validation:Optional
+	// BindSecretRef names a secret with the username, a DN, and password the directory is searched with.
+	// The search is anonymous without it.
+	BindSecretRef string `json:"bindSecretRef,omitempty"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	BaseDN string `json:"baseDN"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="(uid={username})"
+	// UserFilter finds the entry of a user, {username} is replaced with the escaped username.
+	// Active Directory uses (sAMAccountName={username}).
+	UserFilter string `json:"userFilter,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// GroupFilter restricts the users, e.g. (memberOf=cn=admins,ou=groups,dc=example,dc=org)
+	GroupFilter string `json:"groupFilter,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// StartTLS upgrades ldap:// connections to TLS
+	StartTLS bool `json:"startTLS,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// InsecureSkipVerify skips verifying the directory's certificate
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="5m"
+	// CacheTTL is how long a successful authentication is remembered, so not every request reaches the
+	// directory. Zero disables the cache.
+	CacheTTL metav1.Duration `json:"cacheTTL,omitempty"`
+}
+
+// OIDCConfig describes the identity provider people log in with and who of them may pass. Users must
+// match both AllowedGroups and AllowedEmails if both are set, and may be anyone logging in otherwise.
+type OIDCConfig struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^https?://`
+	// IssuerURL of the identity provider, its configuration is discovered from /.well-known/openid-configuration
+	IssuerURL string `json:"issuerURL"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	ClientID string `json:"clientID"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	// ClientSecretRef names a secret with the client secret under the clientSecret key. It signs the
+	// session cookies as well, so rotating it logs everyone out.
+	ClientSecretRef string `json:"clientSecretRef"`
+
+	// +kubebuilder:validation:Optional
+	// RedirectURL registered at the identity provider, e.g. https://app.example.org/.basicauthenticator/login/callback.
+	// It is derived from the host of the request if empty.
+	RedirectURL string `json:"redirectURL,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default={"openid","email","profile"}
+	Scopes []string `json:"scopes,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="groups"
+	// GroupsClaim is the id token claim listing the groups of a user
+	GroupsClaim string `json:"groupsClaim,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// AllowedGroups lets users in that are a member of any of these groups
+	AllowedGroups []string `json:"allowedGroups,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// AllowedEmails lets users in with any of these verified emails. An entry starting with @ allows a domain.
+	AllowedEmails []string `json:"allowedEmails,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Cookie *OIDCCookieConfig `json:"cookie,omitempty"`
+}
+
+// OIDCCookieConfig describes the session cookie of logged in users
+type OIDCCookieConfig struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="_basicauthenticator"
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9_-]+$`
+	Name string `json:"name,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Domain of the cookie, the host of the request if empty
+	Domain string `json:"domain,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="8h"
+	// Expire is how long a session lasts before users log in again
+	Expire metav1.Duration `json:"expire,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Secure sends the cookie over https only
+	Secure bool `json:"secure,omitempty"`
+}
+
+// APIKeysConfig describes the header API keys are sent in and the secrets holding them
+type APIKeysConfig struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="Authorization"
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9-]+$`
+	// Header carrying the key. The Authorization header expects the Bearer scheme, any other header the bare key.
+	Header string `json:"header,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// SecretRefs lists secrets, each holding one key under apiKey. A key is generated into a new secret
+	// if empty. Removing a secret from the list revokes its key.
+	SecretRefs []string `json:"secretRefs,omitempty"`
+}
+
+// JWTConfig describes the keys tokens are signed with and the claims they must have. Exactly one of
+// JWKSURL and PublicKeySecretRef is required.
+type JWTConfig struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^https?://`
+	// JWKSURL serves the signing keys, e.g. https://auth.example.org/.well-known/jwks.json
+	JWKSURL string `json:"jwksURL,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// PublicKeySecretRef names a secret whose keys hold PEM encoded RSA or ECDSA public keys or certificates
+	PublicKeySecretRef string `json:"publicKeySecretRef,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Issuer the iss claim must match
+	Issuer string `json:"issuer,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Audiences lets tokens in whose aud claim contains any of them
+	Audiences []string `json:"audiences,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// RequiredClaims must be present with the given value, or contain it if the claim is a list
+	RequiredClaims map[string]string `json:"requiredClaims,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="30s"
+	// ClockSkew tolerated on the exp, nbf and iat claims
+	ClockSkew metav1.Duration `json:"clockSkew,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// ClaimHeaders maps claims to the headers they are passed upstream in, e.g. sub: X-User. Lists are
+	// joined with commas and other values JSON encoded. Clients can't set these headers themselves.
+	ClaimHeaders map[string]string `json:"claimHeaders,omitempty"`
+}
+
+// MTLSConfig describes the CA client certificates are verified against and how they combine with the Basic
+// credentials. Exactly one of CAConfigMapRef and CASecretRef is required.
+type MTLSConfig struct {
+	// +kubebuilder:validation:Optional
+	// CAConfigMapRef names a configmap holding the PEM encoded CA bundle
+	CAConfigMapRef string `json:"caConfigMapRef,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// CASecretRef names a secret holding the PEM encoded CA bundle
+	CASecretRef string `json:"caSecretRef,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="ca.crt"
+	// CAKey is the key of the bundle in the configmap or secret
+	CAKey string `json:"caKey,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// AllowedSubjects lets certificates in whose common name or subject alternative names, DNS names,
+	// emails, URIs such as SPIFFE IDs or IPs, contain any of them. Any verified certificate passes if empty.
+	AllowedSubjects []string `json:"allowedSubjects,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Certificate;Any;All
+	// +kubebuilder:default=Certificate
+	// Satisfy is Certificate for the certificate alone, Any for either the certificate or the Basic
+	// credentials and All for both
+	Satisfy string `json:"satisfy,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=10
+	// +kubebuilder:default=1
+	// VerifyDepth is how many intermediate CAs a client's chain may have
+	VerifyDepth int `json:"verifyDepth,omitempty"`
+}
+
+// CredentialsRotation defines how generated credentials are rotated
+type CredentialsRotation struct {
+	// +kubebuilder:validation:Optional
+	// Interval between two rotations, e.g. 2160h. Without it credentials are only rotated on demand
+	// through the basicauthenticator.snappcloud.io/rotate-credentials annotation.
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=true
+	// RotateUsername generates a new username along with the password, so the replaced credentials can stay
+	// valid for GracePeriod. nginx only checks the first htpasswd entry of a user, so with false only the
+	// password changes and the replaced one stops working right away.
+	RotateUsername *bool `json:"rotateUsername,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// GracePeriod keeps the replaced credentials valid after a rotation so clients can pick up the new ones.
+	// Defaults to 1h. It can't be set with RotateUsername false.
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+const (
+	// ConditionReady is true once every resource of the authenticator is provisioned and its replicas are ready
+	ConditionReady = "Ready"
+	// ConditionCredentialsValid is true once the htpasswd secret is provisioned from valid credentials
+	ConditionCredentialsValid = "CredentialsValid"
+	// ConditionConfigRendered is true once the nginx configuration is rendered into its configmap
+	ConditionConfigRendered = "ConfigRendered"
+	// ConditionWorkloadInjected is true once the authenticator deployment or sidecars are in place
+	ConditionWorkloadInjected = "WorkloadInjected"
+	// ConditionDegraded is true when the last reconciliation failed
+	ConditionDegraded = "Degraded"
+)
+
+const (
+	ReasonReconciling        = "Reconciling"
+	ReasonAvailable          = "Available"
+	ReasonWaitingForReplicas = "WaitingForReplicas"
+	ReasonDeleting           = "Deleting"
+	ReasonProvisioned        = "Provisioned"
+	ReasonProvisioningFailed = "ProvisioningFailed"
+)
+
 // BasicAuthenticatorStatus defines the observed state of BasicAuthenticator
 type BasicAuthenticatorStatus struct {
 	ReadyReplicas int    `json:"readyReplicas"`
 	Reason        string `json:"reason"`
 	State         string `json:"state"`
+
+	// +kubebuilder:validation:Optional
+	// ObservedGeneration is the most recent generation reconciled by the controller
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=type
+	// +patchStrategy=merge
+	// +patchMergeKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// +kubebuilder:validation:Optional
+	// ForwardAuthURL is where ingress controllers send authentication subrequests when Type is forwardauth
+	ForwardAuthURL string `json:"forwardAuthURL,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// LastRotationTime is when the generated credentials were last rotated
+	LastRotationTime *metav1.Time `json:"lastRotationTime,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// PreviousCredentialsExpirationTime is when the credentials replaced by the last rotation stop being accepted
+	PreviousCredentialsExpirationTime *metav1.Time `json:"previousCredentialsExpirationTime,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// ConfigHash is the hash of the configuration the authenticator pods run. The pod webhook stamps it on the
+	// pods it injects, so pods injected with an outdated configuration can be rolled.
+	ConfigHash string `json:"configHash,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Type",type=string,JSONPath=`.spec.type`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="State",type=string,JSONPath=`.status.state`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // BasicAuthenticator is the Schema for the basicauthenticators API
 type BasicAuthenticator struct {
@@ -18,15 +18,26 @@ package v1alpha1
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	htpasswd "github.com/snapp-incubator/simple-authenticator/pkg/htpasswd"
+	"github.com/snapp-incubator/simple-authenticator/pkg/jwt"
+	"github.com/snapp-incubator/simple-authenticator/pkg/ldap"
+	"github.com/snapp-incubator/simple-authenticator/pkg/nginx"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -34,11 +45,23 @@ import (
 var (
 	runtimeClient     client.Client
 	ValidationTimeout time.Duration
+	// PrivilegedWebserver mirrors the operator's webserver.privileged setting, nginx runs unprivileged without it
+	PrivilegedWebserver bool
+)
+
+var (
+	jwtHeaderPattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+	// reservedClaimHeaders are set by nginx or describe the connection, a claim must not replace them
+	reservedClaimHeaders = map[string]bool{
+		"Host": true, "Authorization": true, "Connection": true, "Content-Length": true, "Transfer-Encoding": true,
+		"X-Real-Ip": true, "X-Forwarded-For": true, "X-Forwarded-Proto": true,
+	}
 )
 
 const (
 	INVALID_OBJECT        = "invalid object passed"
 	INVALID_TYPE_MUTATION = "invalid operation on type"
+	privilegedPortLimit   = 1024
 )
 
 // log is for logging in this package.
@@ -68,10 +91,26 @@ var _ webhook.Validator = &BasicAuthenticator{}
 func (r *BasicAuthenticator) ValidateCreate() error {
 	basicauthenticatorlog.Info("validate create", "name", r.Name)
 
-	if err := r.validateCredentials(); err != nil {
+	if err := r.validateTypeSettings(nil); err != nil {
+		basicauthenticatorlog.Error(err, "Failed to validate type settings")
+		return err
+	}
+	if err := r.validateCredentials(nil); err != nil {
 		basicauthenticatorlog.Error(err, "Failed to validate credentials")
 		return err
 	}
+	if err := r.validateNginxConfig(); err != nil {
+		basicauthenticatorlog.Error(err, "Failed to validate nginx configuration")
+		return err
+	}
+	if err := r.validateTLS(); err != nil {
+		basicauthenticatorlog.Error(err, "Failed to validate tls")
+		return err
+	}
+	if err := r.validateExpose(nil); err != nil {
+		basicauthenticatorlog.Error(err, "Failed to validate expose")
+		return err
+	}
 	return nil
 }
 
@@ -79,11 +118,40 @@ func (r *BasicAuthenticator) ValidateCreate() error {
 func (r *BasicAuthenticator) ValidateUpdate(old runtime.Object) error {
 	basicauthenticatorlog.Info("validate update", "name", r.Name)
 
-	if err := r.validateCredentials(); err != nil {
+	oldBasicAuth, ok := old.(*BasicAuthenticator)
+	if !ok {
+		basicauthenticatorlog.Info("invalid object passed as previous basic authenticator", "type", old.GetObjectKind())
+		return errors.New(INVALID_OBJECT)
+	}
+	// a deleted authenticator only has its finalizers removed, which its spec must not block
+	if r.DeletionTimestamp != nil {
+		return nil
+	}
+	if err := r.validateTypeSettings(oldBasicAuth); err != nil {
+		basicauthenticatorlog.Error(err, "Failed to validate type settings")
+		return err
+	}
+	if err := r.validateCredentials(oldBasicAuth); err != nil {
 		basicauthenticatorlog.Error(err, "Failed to validate credentials")
 		return err
 	}
-	if err := r.validateTypeNotChanged(old); err != nil {
+	if err := r.validateNginxConfig(); err != nil {
+		basicauthenticatorlog.Error(err, "Failed to validate nginx configuration")
+		return err
+	}
+	if err := r.validateTLS(); err != nil {
+		basicauthenticatorlog.Error(err, "Failed to validate tls")
+		return err
+	}
+	if err := r.validateExpose(oldBasicAuth); err != nil {
+		basicauthenticatorlog.Error(err, "Failed to validate expose")
+		return err
+	}
+	if err := r.validateTypeNotChanged(oldBasicAuth); err != nil {
+		basicauthenticatorlog.Error(err, "failed update basic authenticator", "basic authenticator name", r.Name)
+		return err
+	}
+	if err := r.validateInjectionModeNotChanged(oldBasicAuth); err != nil {
 		basicauthenticatorlog.Error(err, "failed update basic authenticator", "basic authenticator name", r.Name)
 		return err
 	}
@@ -97,47 +165,669 @@ func (r *BasicAuthenticator) ValidateDelete() error {
 	return nil
 }
 
-func (r *BasicAuthenticator) validateCredentials() error {
-	secretName := r.Spec.CredentialsSecretRef
-	if secretName == "" {
+// validateTypeSettings checks the settings of r's type. old is the authenticator being updated, nil on create.
+func (r *BasicAuthenticator) validateTypeSettings(old *BasicAuthenticator) error {
+	if r.Spec.Type != "forwardauth" && r.Spec.AppPort == 0 {
+		return fmt.Errorf("appPort is required for type %s", r.Spec.Type)
+	}
+	if r.Spec.Type == "forwardauth" && len(r.Spec.Paths) > 0 {
+		return errors.New("paths are not supported for type forwardauth, route the paths through the ingress instead")
+	}
+	if r.Spec.Type != "forwardauth" && r.Spec.ForwardAuth != nil {
+		return errors.New("forwardAuth is only used with type forwardauth")
+	}
+	if err := r.validatePorts(old); err != nil {
+		return err
+	}
+	if r.Spec.Type == "sidecar" && r.Spec.PodTemplate != nil {
+		return errors.New("podTemplate is not supported for type sidecar, the pods belong to the application; use container instead")
+	}
+	if r.Spec.ServiceTakeover {
+		if r.Spec.Type != "deployment" {
+			return fmt.Errorf("serviceTakeover is not supported for type %s", r.Spec.Type)
+		}
+		if r.Spec.AppService == "" {
+			return errors.New("serviceTakeover needs appService")
+		}
+		if r.Spec.AppService == fmt.Sprintf("%s-svc", r.Name) {
+			return errors.New("serviceTakeover can not take over the authenticator's own service")
+		}
+	}
+	if err := r.validateAdaptiveScaling(old); err != nil {
+		return err
+	}
+	if err := r.validateAvailability(); err != nil {
+		return err
+	}
+	return r.validateAutoscaling()
+}
+
+// validatePorts rejects ports an unprivileged nginx can't bind. On update only changed ports are checked, so
+// authenticators created with a privileged port before nginx ran unprivileged can still be reconciled.
+func (r *BasicAuthenticator) validatePorts(old *BasicAuthenticator) error {
+	if !r.runsUnprivileged() {
+		return nil
+	}
+	authenticatorPortChanged := old == nil || old.Spec.AuthenticatorPort != r.Spec.AuthenticatorPort
+	if authenticatorPortChanged && r.Spec.AuthenticatorPort < privilegedPortLimit {
+		return fmt.Errorf("authenticatorPort %d is a privileged port, the unprivileged authenticator needs a port from %d", r.Spec.AuthenticatorPort, privilegedPortLimit)
+	}
+	redirectPort := r.getHTTPRedirectPort()
+	redirectPortChanged := old == nil || old.getHTTPRedirectPort() != redirectPort
+	if redirectPortChanged && redirectPort != 0 && redirectPort < privilegedPortLimit {
+		return fmt.Errorf("tls.httpRedirectPort %d is a privileged port, the unprivileged authenticator needs a port from %d", redirectPort, privilegedPortLimit)
+	}
+	return nil
+}
+
+// getHTTPRedirectPort returns the plain HTTP port redirecting to HTTPS, zero without one
+func (r *BasicAuthenticator) getHTTPRedirectPort() int {
+	if r.Spec.TLS == nil {
+		return 0
+	}
+	return r.Spec.TLS.HTTPRedirectPort
+}
+
+// runsUnprivileged reports whether nginx runs as non-root, either by the operator's default or unless
+// the container's security context asks for root
+func (r *BasicAuthenticator) runsUnprivileged() bool {
+	if PrivilegedWebserver {
+		return false
+	}
+	if r.Spec.Container == nil || r.Spec.Container.SecurityContext == nil {
+		return true
+	}
+	securityContext := r.Spec.Container.SecurityContext
+	if securityContext.RunAsUser != nil && *securityContext.RunAsUser == 0 {
+		return false
+	}
+	return true
+}
+
+// validateAdaptiveScaling checks the adaptive scaling settings. On update the type and appService are only
+// checked when they or adaptiveScale changed, so authenticators that set adaptiveScale before it was
+// validated can still be updated.
+func (r *BasicAuthenticator) validateAdaptiveScaling(old *BasicAuthenticator) error {
+	adaptiveScaling := r.Spec.AdaptiveScaling
+	if adaptiveScaling != nil && !r.Spec.AdaptiveScale {
+		return errors.New("adaptiveScaling is only used with adaptiveScale")
+	}
+	if !r.Spec.AdaptiveScale {
+		return nil
+	}
+	adaptiveScaleChanged := old == nil || !old.Spec.AdaptiveScale
+	if adaptiveScaleChanged && r.Spec.Type != "deployment" {
+		return fmt.Errorf("adaptiveScale is not supported for type %s", r.Spec.Type)
+	}
+	appServiceChanged := adaptiveScaleChanged || old.Spec.AppService != r.Spec.AppService
+	if appServiceChanged && r.Spec.AppService == "" {
+		return errors.New("adaptiveScale needs appService to find the application workload")
+	}
+	if adaptiveScaling == nil {
+		return nil
+	}
+	if adaptiveScaling.Ratio != "" {
+		ratio, err := strconv.ParseFloat(adaptiveScaling.Ratio, 64)
+		if err != nil || ratio <= 0 {
+			return fmt.Errorf("adaptiveScaling.ratio %q must be a positive number", adaptiveScaling.Ratio)
+		}
+	}
+	if adaptiveScaling.MaxReplicas != 0 && adaptiveScaling.MinReplicas > adaptiveScaling.MaxReplicas {
+		return errors.New("adaptiveScaling.minReplicas must not exceed maxReplicas")
+	}
+	return nil
+}
+
+// validateCredentials checks the credential sources. old is the authenticator being updated, nil on create.
+func (r *BasicAuthenticator) validateCredentials(old *BasicAuthenticator) error {
+	// a secret referenced before may be deleted to revoke a team, which must not block later updates
+	referencedBefore := make(map[string]bool)
+	if old != nil {
+		for _, secretName := range old.getCredentialsSecretNames() {
+			referencedBefore[secretName] = true
+		}
+	}
+	for _, secretName := range r.getCredentialsSecretNames() {
+		if secretName == "" {
+			return errors.New("credentialsSecretRefs must not contain empty secret names")
+		}
+		if referencedBefore[secretName] {
+			continue
+		}
+		if err := r.validateCredentialsSecret(secretName); err != nil {
+			return err
+		}
+	}
+	if err := r.validateCredentialsRotation(old); err != nil {
+		return err
+	}
+	if err := r.validateLDAP(); err != nil {
+		return err
+	}
+	if err := r.validateOIDC(old); err != nil {
+		return err
+	}
+	if err := r.validateAPIKeys(old); err != nil {
+		return err
+	}
+	if err := r.validateJWT(old); err != nil {
+		return err
+	}
+	if err := r.validateMTLS(old); err != nil {
+		return err
+	}
+	return r.validateAuthServerPort()
+}
+
+// validateCredentialsRotation rejects a grace period that can't apply because only the password is rotated.
+// On update it is only checked when the rotation changed.
+func (r *BasicAuthenticator) validateCredentialsRotation(old *BasicAuthenticator) error {
+	rotation := r.Spec.CredentialsRotation
+	if rotation == nil || (old != nil && reflect.DeepEqual(old.Spec.CredentialsRotation, rotation)) {
+		return nil
+	}
+	keepsUsername := rotation.RotateUsername != nil && !*rotation.RotateUsername
+	if keepsUsername && rotation.GracePeriod != nil && rotation.GracePeriod.Duration > 0 {
+		return errors.New("credentialsRotation.gracePeriod needs rotateUsername, nginx only checks the first htpasswd entry of a user")
+	}
+	return nil
+}
+
+// getCredentialsSecretNames returns the secrets of credentialsSecretRef and credentialsSecretRefs
+func (r *BasicAuthenticator) getCredentialsSecretNames() []string {
+	secretNames := make([]string, 0, len(r.Spec.CredentialsSecretRefs)+1)
+	if r.Spec.CredentialsSecretRef != "" {
+		secretNames = append(secretNames, r.Spec.CredentialsSecretRef)
+	}
+	return append(secretNames, r.Spec.CredentialsSecretRefs...)
+}
+
+// validateAuthServerPort rejects ports colliding with the auth server nginx sends auth subrequests to
+func (r *BasicAuthenticator) validateAuthServerPort() error {
+	if r.Spec.LDAP == nil && r.Spec.OIDC == nil && r.Spec.APIKeys == nil && r.Spec.JWT == nil && r.Spec.MTLS == nil {
+		return nil
+	}
+	if r.Spec.AuthenticatorPort == AuthServerPort || (r.Spec.TLS != nil && r.Spec.TLS.HTTPRedirectPort == AuthServerPort) {
+		return fmt.Errorf("port %d is used by the auth server", AuthServerPort)
+	}
+	return nil
+}
+
+// validateLDAP checks the ldap credential source, which replaces the htpasswd files nginx would check
+func (r *BasicAuthenticator) validateLDAP() error {
+	ldapConfig := r.Spec.LDAP
+	if ldapConfig == nil {
+		return nil
+	}
+	if len(r.Spec.CredentialsSecretRefs) > 0 {
+		return errors.New("credentialsSecretRefs can not be combined with ldap, users come from the directory")
+	}
+	for idx, rule := range r.Spec.Paths {
+		if rule.Auth == PathAuthUsers {
+			return fmt.Errorf("paths[%d]: auth %s is not supported with ldap, restrict the users with ldap.groupFilter instead", idx, PathAuthUsers)
+		}
+	}
+	if ldapConfig.UserFilter != "" {
+		if err := ldap.ValidateFilter(ldapConfig.UserFilter); err != nil {
+			return fmt.Errorf("invalid ldap.userFilter: %w", err)
+		}
+	}
+	if ldapConfig.GroupFilter != "" {
+		if err := ldap.ValidateFilter(ldapConfig.GroupFilter); err != nil {
+			return fmt.Errorf("invalid ldap.groupFilter: %w", err)
+		}
+	}
+	if ldapConfig.BindSecretRef == "" {
 		return nil
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), ValidationTimeout)
+	defer cancel()
+	var bindSecret v1.Secret
+	if err := runtimeClient.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: ldapConfig.BindSecretRef}, &bindSecret); err != nil {
+		basicauthenticatorlog.Error(err, "failed to fetch secret", "secret", ldapConfig.BindSecretRef)
+		return err
+	}
+	for _, field := range []string{"username", "password"} {
+		if _, exists := bindSecret.Data[field]; !exists {
+			return fmt.Errorf("illegal format. secret %s data missing %s field", ldapConfig.BindSecretRef, field)
+		}
+	}
+	return nil
+}
 
+// validateOIDC checks the OIDC login, which lets people in alongside the Basic auth of machines. old is the
+// authenticator being updated, nil on create.
+func (r *BasicAuthenticator) validateOIDC(old *BasicAuthenticator) error {
+	oidcConfig := r.Spec.OIDC
+	if oidcConfig == nil {
+		return nil
+	}
+	if r.Spec.Type == "forwardauth" {
+		return errors.New("oidc is not supported for type forwardauth, the login needs to redirect the user's browser")
+	}
+	for idx, rule := range r.Spec.Paths {
+		if rule.Auth == PathAuthUsers {
+			return fmt.Errorf("paths[%d]: auth %s is not supported with oidc, restrict the users with oidc.allowedGroups or oidc.allowedEmails instead", idx, PathAuthUsers)
+		}
+	}
+	if oidcConfig.RedirectURL != "" {
+		redirectURL, err := url.Parse(oidcConfig.RedirectURL)
+		if err != nil || (redirectURL.Scheme != "http" && redirectURL.Scheme != "https") || redirectURL.Host == "" {
+			return fmt.Errorf("oidc.redirectURL %q must be an absolute http or https URL", oidcConfig.RedirectURL)
+		}
+	}
+	for _, email := range oidcConfig.AllowedEmails {
+		if email == "" || email == "@" || strings.Contains(email, ",") {
+			return fmt.Errorf("oidc.allowedEmails contains the invalid entry %q", email)
+		}
+	}
+	for _, group := range oidcConfig.AllowedGroups {
+		if group == "" || strings.Contains(group, ",") {
+			return fmt.Errorf("oidc.allowedGroups contains the invalid entry %q", group)
+		}
+	}
+	// the client secret is only checked when it is referenced, so replacing it doesn't block later updates
+	if old != nil && old.Spec.OIDC != nil && old.Spec.OIDC.ClientSecretRef == oidcConfig.ClientSecretRef {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ValidationTimeout)
+	defer cancel()
+	var clientSecret v1.Secret
+	if err := runtimeClient.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: oidcConfig.ClientSecretRef}, &clientSecret); err != nil {
+		basicauthenticatorlog.Error(err, "failed to fetch secret", "secret", oidcConfig.ClientSecretRef)
+		return err
+	}
+	if len(clientSecret.Data[OIDCClientSecretKey]) == 0 {
+		return fmt.Errorf("illegal format. secret %s data missing %s field", oidcConfig.ClientSecretRef, OIDCClientSecretKey)
+	}
+	return nil
+}
+
+// validateAPIKeys checks the secrets of the API keys accepted alongside the Basic credentials. old is the
+// authenticator being updated, nil on create.
+func (r *BasicAuthenticator) validateAPIKeys(old *BasicAuthenticator) error {
+	apiKeys := r.Spec.APIKeys
+	if apiKeys == nil {
+		return nil
+	}
+	for idx, rule := range r.Spec.Paths {
+		if rule.Auth == PathAuthUsers {
+			return fmt.Errorf("paths[%d]: auth %s is not supported with apiKeys, any key would pass it", idx, PathAuthUsers)
+		}
+	}
+	// deleting a key secret revokes its key, which must not block later updates
+	referencedBefore := make(map[string]bool)
+	if old != nil && old.Spec.APIKeys != nil {
+		for _, secretName := range old.Spec.APIKeys.SecretRefs {
+			referencedBefore[secretName] = true
+		}
+	}
+	for _, secretName := range apiKeys.SecretRefs {
+		if secretName == "" {
+			return errors.New("apiKeys.secretRefs must not contain empty secret names")
+		}
+		if referencedBefore[secretName] {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), ValidationTimeout)
+		var keySecret v1.Secret
+		err := runtimeClient.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: secretName}, &keySecret)
+		cancel()
+		if err != nil {
+			basicauthenticatorlog.Error(err, "failed to fetch secret", "secret", secretName)
+			return err
+		}
+		if len(keySecret.Data[APIKeySecretKey]) == 0 {
+			return fmt.Errorf("illegal format. secret %s data missing %s field", secretName, APIKeySecretKey)
+		}
+	}
+	return nil
+}
+
+// validateJWT checks the keys and claim headers of JWT validation. old is the authenticator being updated,
+// nil on create.
+func (r *BasicAuthenticator) validateJWT(old *BasicAuthenticator) error {
+	jwtConfig := r.Spec.JWT
+	if jwtConfig == nil {
+		return nil
+	}
+	if (jwtConfig.JWKSURL == "") == (jwtConfig.PublicKeySecretRef == "") {
+		return errors.New("jwt needs exactly one of jwksURL and publicKeySecretRef")
+	}
+	for idx, rule := range r.Spec.Paths {
+		if rule.Auth == PathAuthUsers {
+			return fmt.Errorf("paths[%d]: auth %s is not supported with jwt, restrict the tokens with jwt.requiredClaims instead", idx, PathAuthUsers)
+		}
+	}
+	if len(jwtConfig.ClaimHeaders) > 0 && r.Spec.Type == "forwardauth" {
+		return errors.New("jwt.claimHeaders is not supported for type forwardauth, there is no upstream to pass them to")
+	}
+	seenHeaders := make(map[string]string)
+	for claim, header := range jwtConfig.ClaimHeaders {
+		if claim == "" || !jwtHeaderPattern.MatchString(header) {
+			return fmt.Errorf("jwt.claimHeaders: %q is not a valid header for claim %q", header, claim)
+		}
+		canonical := http.CanonicalHeaderKey(header)
+		if reservedClaimHeaders[canonical] {
+			return fmt.Errorf("jwt.claimHeaders: header %s can not be set from a claim", header)
+		}
+		if other, exists := seenHeaders[canonical]; exists {
+			return fmt.Errorf("jwt.claimHeaders: claims %s and %s both use header %s", other, claim, header)
+		}
+		seenHeaders[canonical] = claim
+	}
+	if jwtConfig.PublicKeySecretRef == "" {
+		return nil
+	}
+	// the keys are only checked when their secret is referenced, so rotating them doesn't block later updates
+	if old != nil && old.Spec.JWT != nil && old.Spec.JWT.PublicKeySecretRef == jwtConfig.PublicKeySecretRef {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ValidationTimeout)
+	defer cancel()
+	var keySecret v1.Secret
+	if err := runtimeClient.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: jwtConfig.PublicKeySecretRef}, &keySecret); err != nil {
+		basicauthenticatorlog.Error(err, "failed to fetch secret", "secret", jwtConfig.PublicKeySecretRef)
+		return err
+	}
+	if len(keySecret.Data) == 0 {
+		return fmt.Errorf("illegal format. secret %s has no public keys", jwtConfig.PublicKeySecretRef)
+	}
+	for field, content := range keySecret.Data {
+		if _, err := jwt.ParsePublicKeys(content); err != nil {
+			return fmt.Errorf("invalid public key %s in secret %s: %w", field, jwtConfig.PublicKeySecretRef, err)
+		}
+	}
+	return nil
+}
+
+// validateMTLS checks the CA bundle client certificates are verified against and the credentials they
+// are combined with. old is the authenticator being updated, nil on create.
+func (r *BasicAuthenticator) validateMTLS(old *BasicAuthenticator) error {
+	mtlsConfig := r.Spec.MTLS
+	if mtlsConfig == nil {
+		return nil
+	}
+	if r.Spec.TLS == nil {
+		return errors.New("mtls requires tls, client certificates are only presented over https")
+	}
+	if r.Spec.Type == "forwardauth" {
+		return errors.New("mtls is not supported for type forwardauth, clients present their certificates to the ingress")
+	}
+	if (mtlsConfig.CAConfigMapRef == "") == (mtlsConfig.CASecretRef == "") {
+		return errors.New("mtls needs exactly one of caConfigMapRef and caSecretRef")
+	}
+	satisfy := mtlsConfig.Satisfy
+	if satisfy == "" {
+		satisfy = MTLSSatisfyCertificate
+	}
+	if satisfy != MTLSSatisfyAny && (r.Spec.OIDC != nil || r.Spec.APIKeys != nil || r.Spec.JWT != nil) {
+		return fmt.Errorf("mtls.satisfy %s can not be combined with oidc, apiKeys or jwt, use %s", satisfy, MTLSSatisfyAny)
+	}
+	if satisfy == MTLSSatisfyCertificate && r.Spec.LDAP != nil {
+		return fmt.Errorf("mtls.satisfy %s would ignore the ldap credentials, use %s or %s", satisfy, MTLSSatisfyAll, MTLSSatisfyAny)
+	}
+	for idx, rule := range r.Spec.Paths {
+		if rule.Auth == PathAuthUsers && satisfy != MTLSSatisfyAll {
+			return fmt.Errorf("paths[%d]: auth %s is only supported with mtls.satisfy %s, any allowed certificate would pass it", idx, PathAuthUsers, MTLSSatisfyAll)
+		}
+	}
+	for _, subject := range mtlsConfig.AllowedSubjects {
+		if subject == "" || strings.Contains(subject, ",") {
+			return fmt.Errorf("mtls.allowedSubjects contains the invalid entry %q", subject)
+		}
+	}
+
+	// the bundle is only checked when it is referenced, so rotating it doesn't block later updates
+	if old != nil && old.Spec.MTLS != nil && old.Spec.MTLS.CASecretRef == mtlsConfig.CASecretRef &&
+		old.Spec.MTLS.CAConfigMapRef == mtlsConfig.CAConfigMapRef && old.Spec.MTLS.CAKey == mtlsConfig.CAKey {
+		return nil
+	}
+	caKey := mtlsConfig.CAKey
+	if caKey == "" {
+		caKey = MTLSDefaultCAKey
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ValidationTimeout)
+	defer cancel()
+	var bundle []byte
+	if mtlsConfig.CASecretRef != "" {
+		var caSecret v1.Secret
+		if err := runtimeClient.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: mtlsConfig.CASecretRef}, &caSecret); err != nil {
+			basicauthenticatorlog.Error(err, "failed to fetch secret", "secret", mtlsConfig.CASecretRef)
+			return err
+		}
+		if bundle = caSecret.Data[caKey]; len(bundle) == 0 {
+			return fmt.Errorf("illegal format. secret %s data missing %s field", mtlsConfig.CASecretRef, caKey)
+		}
+	} else {
+		var caConfigMap v1.ConfigMap
+		if err := runtimeClient.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: mtlsConfig.CAConfigMapRef}, &caConfigMap); err != nil {
+			basicauthenticatorlog.Error(err, "failed to fetch configmap", "configmap", mtlsConfig.CAConfigMapRef)
+			return err
+		}
+		if bundle = []byte(caConfigMap.Data[caKey]); len(bundle) == 0 {
+			return fmt.Errorf("illegal format. configmap %s data missing %s field", mtlsConfig.CAConfigMapRef, caKey)
+		}
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(bundle) {
+		return fmt.Errorf("%s of mtls holds no PEM encoded certificate", caKey)
+	}
+	return nil
+}
+
+func (r *BasicAuthenticator) validateCredentialsSecret(secretName string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), ValidationTimeout)
 	defer cancel()
 	var credentials v1.Secret
 
 	err := runtimeClient.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: secretName}, &credentials)
 	if err != nil {
-		basicauthenticatorlog.Error(err, "failed to fetch secret")
+		basicauthenticatorlog.Error(err, "failed to fetch secret", "secret", secretName)
 		return err
 	}
 	_, exists := credentials.Data["username"]
 	if !exists {
-		return errors.New("illegal format. data missing username field")
+		return fmt.Errorf("illegal format. secret %s data missing username field", secretName)
 	}
 	_, exists = credentials.Data["password"]
 	if !exists {
-		return errors.New("illegal format. data missing password field")
+		return fmt.Errorf("illegal format. secret %s data missing password field", secretName)
 	}
 	htpasswdByte, exists := credentials.Data["htpasswd"]
 	if exists {
-		htpasswdStr := string(htpasswdByte)
-		if !htpasswd.ValidateHtpasswdFormat(strings.TrimSpace(htpasswdStr)) {
-			return errors.New("failed to validate format of htpasswd. htpasswd should be like \"username:password\"")
+		if err := htpasswd.Validate(htpasswdByte); err != nil {
+			return fmt.Errorf("failed to validate format of htpasswd in secret %s: %w", secretName, err)
 		}
 	}
 	return nil
 }
 
-func (r *BasicAuthenticator) validateTypeNotChanged(old runtime.Object) error {
-	oldBasicAuth, ok := old.(*BasicAuthenticator)
-	if !ok {
-		basicauthenticatorlog.Info("invalid object passed as previous basic authenticator", "type", old.GetObjectKind())
-		return errors.New(INVALID_OBJECT)
+func (r *BasicAuthenticator) validateNginxConfig() error {
+	if err := nginx.ValidateSnippet(r.Spec.ServerSnippet); err != nil {
+		return fmt.Errorf("invalid serverSnippet: %w", err)
 	}
-	if r.Spec.Type != oldBasicAuth.Spec.Type {
+	if err := nginx.ValidateSnippet(r.Spec.LocationSnippet); err != nil {
+		return fmt.Errorf("invalid locationSnippet: %w", err)
+	}
+	if err := r.validatePaths(); err != nil {
+		return err
+	}
+	if r.Spec.Proxy == nil {
+		return nil
+	}
+	for name, value := range r.Spec.Proxy.RequestHeaders {
+		if err := nginx.ValidateHeader(name, value); err != nil {
+			return fmt.Errorf("invalid proxy.requestHeaders: %w", err)
+		}
+	}
+	for name, value := range r.Spec.Proxy.ResponseHeaders {
+		if err := nginx.ValidateHeader(name, value); err != nil {
+			return fmt.Errorf("invalid proxy.responseHeaders: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *BasicAuthenticator) validatePaths() error {
+	definedPaths := make(map[string]bool)
+	for idx, rule := range r.Spec.Paths {
+		modifier := nginx.PrefixModifier
+		switch rule.MatchType {
+		case PathMatchExact:
+			modifier = nginx.ExactModifier
+		case PathMatchRegex:
+			modifier = nginx.RegexModifier
+		}
+		if err := nginx.ValidateLocationPath(modifier, rule.Path); err != nil {
+			return fmt.Errorf("invalid paths[%d]: %w", idx, err)
+		}
+		if definedPaths[modifier+rule.Path] {
+			return fmt.Errorf("invalid paths[%d]: path %s is defined more than once", idx, rule.Path)
+		}
+		definedPaths[modifier+rule.Path] = true
+		if rule.Auth == PathAuthUsers && len(rule.Users) == 0 {
+			return fmt.Errorf("invalid paths[%d]: auth Users needs at least one user", idx)
+		}
+		if rule.Auth != PathAuthUsers && len(rule.Users) > 0 {
+			return fmt.Errorf("invalid paths[%d]: users are only used with auth Users", idx)
+		}
+	}
+	return nil
+}
+
+func (r *BasicAuthenticator) validateTLS() error {
+	tls := r.Spec.TLS
+	if tls == nil {
+		return nil
+	}
+	if (tls.SecretName == "") == (tls.CertManager == nil) {
+		return errors.New("tls needs exactly one of secretName and certManager")
+	}
+	if tls.HTTPRedirectPort != 0 && (tls.HTTPRedirectPort == r.Spec.AuthenticatorPort || tls.HTTPRedirectPort == r.Spec.AppPort) {
+		return fmt.Errorf("tls.httpRedirectPort %d is already used by the authenticator or the application", tls.HTTPRedirectPort)
+	}
+	if tls.SecretName == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ValidationTimeout)
+	defer cancel()
+	var certificate v1.Secret
+	err := runtimeClient.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: tls.SecretName}, &certificate)
+	if err != nil {
+		basicauthenticatorlog.Error(err, "failed to fetch secret", "secret", tls.SecretName)
+		return err
+	}
+	for _, key := range []string{v1.TLSCertKey, v1.TLSPrivateKeyKey} {
+		if _, exists := certificate.Data[key]; !exists {
+			return fmt.Errorf("illegal format. secret %s data missing %s field", tls.SecretName, key)
+		}
+	}
+	return nil
+}
+
+func (r *BasicAuthenticator) validateAutoscaling() error {
+	autoscaling := r.Spec.Autoscaling
+	if autoscaling == nil {
+		return nil
+	}
+	if r.Spec.Type == "sidecar" {
+		return errors.New("autoscaling is not supported for type sidecar, scale the application instead")
+	}
+	if r.Spec.AdaptiveScale {
+		return errors.New("autoscaling and adaptiveScale both manage replicas, use only one of them")
+	}
+	if autoscaling.TargetCPUUtilization == nil && autoscaling.TargetMemoryUtilization == nil && autoscaling.RequestsPerSecond == nil {
+		return errors.New("autoscaling needs at least one of targetCPUUtilization, targetMemoryUtilization and requestsPerSecond")
+	}
+	if autoscaling.MinReplicas > autoscaling.MaxReplicas {
+		return errors.New("autoscaling.minReplicas must not exceed maxReplicas")
+	}
+	if autoscaling.RequestsPerSecond != nil {
+		for _, port := range []int{NginxStatusPort, ExporterPort} {
+			if r.Spec.AuthenticatorPort == port || (r.Spec.TLS != nil && r.Spec.TLS.HTTPRedirectPort == port) {
+				return fmt.Errorf("port %d is used by the metrics exporter of autoscaling.requestsPerSecond", port)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *BasicAuthenticator) validateAvailability() error {
+	availability := r.Spec.Availability
+	if availability == nil {
+		return nil
+	}
+	if r.Spec.Type == "sidecar" {
+		return errors.New("availability is not supported for type sidecar, the pods belong to the application")
+	}
+	if availability.MinAvailable != nil && availability.MaxUnavailable != nil {
+		return errors.New("availability.minAvailable and availability.maxUnavailable are mutually exclusive")
+	}
+	for field, value := range map[string]*intstr.IntOrString{"minAvailable": availability.MinAvailable, "maxUnavailable": availability.MaxUnavailable} {
+		if value == nil {
+			continue
+		}
+		if scaled, err := intstr.GetScaledValueFromIntOrPercent(value, 100, true); err != nil || scaled < 0 {
+			return fmt.Errorf("availability.%s must be a non-negative number or percentage", field)
+		}
+	}
+	for _, constraint := range availability.TopologySpreadConstraints {
+		if constraint.MaxSkew < 1 {
+			return fmt.Errorf("maxSkew of the topology spread constraint on %s must be at least 1", constraint.TopologyKey)
+		}
+	}
+	return nil
+}
+
+// validateExpose checks the expose section. old is the authenticator being updated, nil on create.
+func (r *BasicAuthenticator) validateExpose(old *BasicAuthenticator) error {
+	expose := r.Spec.Expose
+	if expose == nil {
+		return nil
+	}
+	if r.Spec.Type != "deployment" {
+		return fmt.Errorf("expose is not supported for type %s", r.Spec.Type)
+	}
+	if expose.Kind == ExposeKindHTTPRoute && expose.Gateway == nil {
+		return errors.New("expose.gateway is required for kind HTTPRoute")
+	}
+	if expose.Kind != ExposeKindHTTPRoute && expose.Gateway != nil {
+		return fmt.Errorf("expose.gateway is only used with kind %s", ExposeKindHTTPRoute)
+	}
+	// authenticators exposed before TLS was checked here keep updating until expose or tls change
+	tlsChanged := old == nil || !reflect.DeepEqual(old.Spec.Expose, expose) || !reflect.DeepEqual(old.Spec.TLS, r.Spec.TLS)
+	if r.Spec.TLS != nil && tlsChanged {
+		// a BackendTLSPolicy would need the CA of the authenticator's certificate and is not part of the standard channel yet
+		if expose.Kind == ExposeKindHTTPRoute {
+			return fmt.Errorf("expose kind %s can't reach an authenticator terminating TLS, use %s or %s", ExposeKindHTTPRoute, ExposeKindIngress, ExposeKindRoute)
+		}
+		if expose.Kind == ExposeKindRoute && expose.Path != "" && expose.Path != "/" {
+			return errors.New("expose.path can't be used with a passthrough Route to an authenticator terminating TLS")
+		}
+	}
+	return nil
+}
+
+func (r *BasicAuthenticator) validateTypeNotChanged(old *BasicAuthenticator) error {
+	if r.Spec.Type != old.Spec.Type {
 		return errors.New(INVALID_TYPE_MUTATION)
 	}
 	return nil
 }
+
+// validateInjectionModeNotChanged rejects switching between Workload and PodWebhook injection. Neither mode
+// removes the sidecars of the other, so the switch would leave them behind with stale settings.
+func (r *BasicAuthenticator) validateInjectionModeNotChanged(old *BasicAuthenticator) error {
+	if r.Spec.Type == "sidecar" && old.Spec.Type == "sidecar" && getInjectionMode(r) != getInjectionMode(old) {
+		return errors.New("injectionMode can not be changed, recreate the BasicAuthenticator to switch it")
+	}
+	return nil
+}
+
+func getInjectionMode(basicAuthenticator *BasicAuthenticator) string {
+	if basicAuthenticator.Spec.InjectionMode == "" {
+		return InjectionModeWorkload
+	}
+	return basicAuthenticator.Spec.InjectionMode
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// auth-server answers the auth_request subrequests of the authenticator's nginx for credential sources
+// nginx can't check itself, such as an LDAP directory, an OIDC login, hashed API keys, JWTs or the
+// subjects of client certificates
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/snapp-incubator/simple-authenticator/pkg/apikey"
+	"github.com/snapp-incubator/simple-authenticator/pkg/authserver"
+	"github.com/snapp-incubator/simple-authenticator/pkg/jwt"
+	"github.com/snapp-incubator/simple-authenticator/pkg/ldap"
+	"github.com/snapp-incubator/simple-authenticator/pkg/mtls"
+	"github.com/snapp-incubator/simple-authenticator/pkg/oidc"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+var setupLog = ctrl.Log.WithName("setup")
+
+func main() {
+	var listenAddr string
+	var probe bool
+	var realm string
+	var ldapConfig ldap.Config
+	var ldapBindDir string
+	var oidcConfig oidc.Config
+	var oidcClientSecretFile string
+	var oidcScopes, oidcAllowedGroups, oidcAllowedEmails string
+	var apiKeyHeader, apiKeysFile string
+	var jwtConfig jwt.Config
+	var jwtAudiences string
+	jwtRequiredClaims, jwtClaimHeaders := keyValueFlag{}, keyValueFlag{}
+	var mtlsEnabled, mtlsRequired bool
+	var mtlsAllowedSubjects string
+	flag.StringVar(&listenAddr, "listen-address", "127.0.0.1:18081", "The address the auth endpoint binds to.")
+	flag.BoolVar(&probe, "probe", false, "Check the health of the auth server listening on --listen-address and exit, for exec probes.")
+	flag.StringVar(&realm, "realm", "basic authentication area", "The realm of the Basic authentication challenge.")
+	flag.StringVar(&ldapConfig.URL, "ldap-url", "", "The ldap:// or ldaps:// URL of the directory.")
+	flag.StringVar(&ldapConfig.BaseDN, "ldap-base-dn", "", "The DN users are searched under.")
+	flag.StringVar(&ldapConfig.UserFilter, "ldap-user-filter", "(uid={username})", "The filter finding a user's entry.")
+	flag.StringVar(&ldapConfig.GroupFilter, "ldap-group-filter", "", "A filter the user's entry must match as well, e.g. (memberOf=cn=admins,dc=example,dc=org).")
+	flag.BoolVar(&ldapConfig.StartTLS, "ldap-start-tls", false, "Upgrade ldap:// connections with StartTLS.")
+	flag.BoolVar(&ldapConfig.InsecureSkipVerify, "ldap-insecure-skip-verify", false, "Skip verifying the directory's certificate.")
+	flag.DurationVar(&ldapConfig.Timeout, "ldap-timeout", 5*time.Second, "The timeout of directory requests.")
+	flag.DurationVar(&ldapConfig.CacheTTL, "ldap-cache-ttl", 5*time.Minute, "How long a successful authentication is cached.")
+	flag.StringVar(&ldapBindDir, "ldap-bind-dir", "", "A directory with the username and password files to search the directory with.")
+	flag.StringVar(&oidcConfig.IssuerURL, "oidc-issuer-url", "", "The issuer URL of the OIDC provider users log in with.")
+	flag.StringVar(&oidcConfig.ClientID, "oidc-client-id", "", "The client ID registered at the OIDC provider.")
+	flag.StringVar(&oidcClientSecretFile, "oidc-client-secret-file", "", "A file with the client secret.")
+	flag.StringVar(&oidcConfig.RedirectURL, "oidc-redirect-url", "", "The callback URL registered at the provider, derived from the request if empty.")
+	flag.StringVar(&oidcScopes, "oidc-scopes", "openid,email,profile", "The comma separated scopes to request.")
+	flag.StringVar(&oidcConfig.GroupsClaim, "oidc-groups-claim", "groups", "The id token claim listing the user's groups.")
+	flag.StringVar(&oidcAllowedGroups, "oidc-allowed-groups", "", "Comma separated groups a user must be a member of one of.")
+	flag.StringVar(&oidcAllowedEmails, "oidc-allowed-emails", "", "Comma separated emails, or @domains, a user must have one of.")
+	flag.StringVar(&oidcConfig.CookieName, "oidc-cookie-name", "_basicauthenticator", "The name of the session cookie.")
+	flag.StringVar(&oidcConfig.CookieDomain, "oidc-cookie-domain", "", "The domain of the session cookie, the request's host if empty.")
+	flag.DurationVar(&oidcConfig.CookieExpire, "oidc-cookie-expire", 8*time.Hour, "How long a session lasts.")
+	flag.BoolVar(&oidcConfig.CookieSecure, "oidc-cookie-secure", false, "Send the session cookie over https only.")
+	flag.StringVar(&apiKeyHeader, "api-key-header", apikey.AuthorizationHeader, "The header carrying API keys, Authorization expects the Bearer scheme.")
+	flag.StringVar(&apiKeysFile, "api-keys-file", "", "A file with the name:sha256 entries of the accepted API keys.")
+	flag.StringVar(&jwtConfig.JWKSURL, "jwt-jwks-url", "", "The URL of the JWKS tokens are verified with.")
+	flag.StringVar(&jwtConfig.PublicKeysDir, "jwt-public-keys-dir", "", "A directory with PEM encoded public keys tokens are verified with.")
+	flag.StringVar(&jwtConfig.Issuer, "jwt-issuer", "", "The iss claim tokens must have.")
+	flag.StringVar(&jwtAudiences, "jwt-audiences", "", "Comma separated audiences, the aud claim of a token must contain one of.")
+	flag.Var(jwtRequiredClaims, "jwt-required-claim", "A claim=value tokens must have, repeatable.")
+	flag.DurationVar(&jwtConfig.ClockSkew, "jwt-clock-skew", 30*time.Second, "The clock skew tolerated on exp, nbf and iat.")
+	flag.Var(jwtClaimHeaders, "jwt-claim-header", "A claim=Header passed upstream, repeatable.")
+	flag.BoolVar(&mtlsEnabled, "mtls", false, "Accept the client certificates nginx verified and passes in the "+mtls.CertificateHeader+" header.")
+	flag.BoolVar(&mtlsRequired, "mtls-required", false, "Require a client certificate before checking any other credentials, implies --mtls.")
+	flag.StringVar(&mtlsAllowedSubjects, "mtls-allowed-subjects", "", "Comma separated common names or subject alternative names a client certificate must have one of.")
+	opts := zap.Options{}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+
+	if probe {
+		os.Exit(probeHealth(listenAddr))
+	}
+
+	hasJWT := jwtConfig.JWKSURL != "" || jwtConfig.PublicKeysDir != ""
+	hasMTLS := mtlsEnabled || mtlsRequired
+	if ldapConfig.URL == "" && oidcConfig.IssuerURL == "" && apiKeysFile == "" && !hasJWT && !hasMTLS {
+		setupLog.Error(errors.New("no credential source configured"), "--ldap-url, --oidc-issuer-url, --api-keys-file, --jwt-jwks-url, --jwt-public-keys-dir or --mtls is required")
+		os.Exit(1)
+	}
+	options := authserver.Options{Realm: realm}
+	if ldapConfig.URL != "" {
+		options.Credentials = newLDAPAuthenticator(ldapConfig, ldapBindDir)
+	}
+	if oidcConfig.IssuerURL != "" {
+		if oidcConfig.ClientID == "" || oidcClientSecretFile == "" {
+			setupLog.Error(errors.New("incomplete oidc client"), "--oidc-client-id and --oidc-client-secret-file are required")
+			os.Exit(1)
+		}
+		// read on every use, so the kubelet's updates of a mounted secret are picked up
+		oidcConfig.ClientSecret = func() (string, error) {
+			clientSecret, err := os.ReadFile(oidcClientSecretFile)
+			return strings.TrimSpace(string(clientSecret)), err
+		}
+		oidcConfig.Scopes = splitList(oidcScopes)
+		oidcConfig.AllowedGroups = splitList(oidcAllowedGroups)
+		oidcConfig.AllowedEmails = splitList(oidcAllowedEmails)
+		oidcConfig.Realm = realm
+		provider := oidc.NewProvider(oidcConfig, ctrl.Log.WithName("oidc"))
+		options.Requests = append(options.Requests, provider)
+		options.Login = provider
+	}
+	if apiKeysFile != "" {
+		options.Requests = append(options.Requests, apikey.NewValidator(apiKeyHeader, apiKeysFile))
+	}
+	if hasJWT {
+		jwtConfig.Audiences = splitList(jwtAudiences)
+		jwtConfig.RequiredClaims = jwtRequiredClaims
+		jwtConfig.ClaimHeaders = jwtClaimHeaders
+		validator, err := jwt.NewValidator(jwtConfig, ctrl.Log.WithName("jwt"))
+		if err != nil {
+			setupLog.Error(err, "invalid jwt configuration")
+			os.Exit(1)
+		}
+		options.Requests = append(options.Requests, validator)
+	}
+	if hasMTLS {
+		validator := mtls.NewValidator(splitList(mtlsAllowedSubjects))
+		if mtlsRequired {
+			options.Required = validator
+		} else {
+			options.Requests = append(options.Requests, validator)
+		}
+	}
+
+	handler := authserver.NewHandler(options, ctrl.Log.WithName("auth"))
+	setupLog.Info("starting auth server", "address", listenAddr, "ldap", ldapConfig.URL, "oidc", oidcConfig.IssuerURL, "apiKeyHeader", apiKeyHeader, "jwt", hasJWT, "mtls", hasMTLS, "mtlsRequired", mtlsRequired)
+	if err := http.ListenAndServe(listenAddr, handler); err != nil {
+		setupLog.Error(err, "problem running auth server")
+		os.Exit(1)
+	}
+}
+
+// newLDAPAuthenticator returns the authenticator of the ldap flags, exiting on invalid filters
+func newLDAPAuthenticator(ldapConfig ldap.Config, ldapBindDir string) *ldap.Authenticator {
+	if err := ldap.ValidateFilter(ldapConfig.UserFilter); err != nil {
+		setupLog.Error(err, "invalid --ldap-user-filter")
+		os.Exit(1)
+	}
+	if ldapConfig.GroupFilter != "" {
+		if err := ldap.ValidateFilter(ldapConfig.GroupFilter); err != nil {
+			setupLog.Error(err, "invalid --ldap-group-filter")
+			os.Exit(1)
+		}
+	}
+	if ldapBindDir != "" {
+		// read on every connection, so the kubelet's updates of a mounted secret are picked up
+		ldapConfig.BindCredentials = func() (string, string, error) {
+			username, err := os.ReadFile(filepath.Join(ldapBindDir, "username"))
+			if err != nil {
+				return "", "", err
+			}
+			password, err := os.ReadFile(filepath.Join(ldapBindDir, "password"))
+			if err != nil {
+				return "", "", err
+			}
+			return strings.TrimSpace(string(username)), strings.TrimRight(string(password), "\r\n"), nil
+		}
+	}
+	return ldap.NewAuthenticator(ldapConfig)
+}
+
+// probeHealth asks the health endpoint of the auth server at listenAddr, returning the exit code of the probe
+func probeHealth(listenAddr string) int {
+	client := http.Client{Timeout: time.Second}
+	response, err := client.Get(fmt.Sprintf("http://%s%s", listenAddr, authserver.HealthPath))
+	if err != nil {
+		setupLog.Error(err, "auth server is not healthy")
+		return 1
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		setupLog.Error(fmt.Errorf("status %d", response.StatusCode), "auth server is not healthy")
+		return 1
+	}
+	return 0
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// keyValueFlag collects repeated key=value flags
+type keyValueFlag map[string]string
+
+func (f keyValueFlag) String() string {
+	return fmt.Sprint(map[string]string(f))
+}
+
+func (f keyValueFlag) Set(value string) error {
+	key, val, found := strings.Cut(value, "=")
+	if !found || key == "" {
+		return fmt.Errorf("%q is not a key=value pair", value)
+	}
+	f[key] = val
+	return nil
+}
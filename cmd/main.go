@@ -23,6 +23,7 @@ import (
 
 	"github.com/snapp-incubator/simple-authenticator/internal/config"
 	"github.com/snapp-incubator/simple-authenticator/internal/controller/basic_authenticator"
+	"github.com/snapp-incubator/simple-authenticator/internal/webhook/sidecar_injector"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -34,6 +35,8 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	authenticatorv1alpha1 "github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
 	//+kubebuilder:scaffold:imports
@@ -79,6 +82,7 @@ func main() {
 		} else {
 			customConfig = tmpConf
 			authenticatorv1alpha1.ValidationTimeout = time.Second * time.Duration(customConfig.WebhookConf.ValidationTimeoutSecond)
+			authenticatorv1alpha1.PrivilegedWebserver = customConfig.WebserverConf.Privileged
 		}
 	}
 
@@ -118,6 +122,16 @@ func main() {
 		setupLog.Error(err, "unable to create webhook", "webhook", "BasicAuthenticator")
 		os.Exit(1)
 	}
+	podDecoder, err := admission.NewDecoder(mgr.GetScheme())
+	if err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "Pod")
+		os.Exit(1)
+	}
+	mgr.GetWebhookServer().Register(sidecar_injector.WebhookPath, &webhook.Admission{Handler: &sidecar_injector.PodSidecarInjector{
+		Client:       mgr.GetClient(),
+		CustomConfig: customConfig,
+		Decoder:      podDecoder,
+	}})
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -1,23 +1,60 @@
 package config
 
 import (
+	"fmt"
+	"github.com/snapp-incubator/simple-authenticator/pkg/htpasswd"
 	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 type CustomConfig struct {
 	WebserverConf WebserverConfig `mapstructure:"webserver"`
 	WebhookConf   WebhookConfig   `mapstructure:"webhook"`
+	HtpasswdConf  HtpasswdConfig  `mapstructure:"htpasswd"`
+	ExporterConf  ExporterConfig  `mapstructure:"exporter"`
+	// AuthServerConf is the auth server checking credentials nginx can't, such as LDAP
+	AuthServerConf AuthServerConfig `mapstructure:"auth_server"`
 }
 
 type WebserverConfig struct {
 	Image         string `mapstructure:"image"`
 	ContainerName string `mapstructure:"container_name"`
+	// Privileged turns off the non-root, read-only hardening of nginx, for images that need root
+	Privileged bool `mapstructure:"privileged"`
+	// Resources, Probes, NodeSelector and PriorityClassName are defaults a BasicAuthenticator's
+	// podTemplate and container sections take precedence over
+	Resources         ResourcesConfig   `mapstructure:"resources"`
+	Probes            bool              `mapstructure:"probes"`
+	NodeSelector      map[string]string `mapstructure:"node_selector"`
+	PriorityClassName string            `mapstructure:"priority_class_name"`
+}
+
+// ResourcesConfig maps resource names, such as cpu or memory, to quantities
+type ResourcesConfig struct {
+	Requests map[string]string `mapstructure:"requests"`
+	Limits   map[string]string `mapstructure:"limits"`
 }
 
 type WebhookConfig struct {
 	ValidationTimeoutSecond int `mapstructure:"validation_timeout_second"`
 }
 
+type HtpasswdConfig struct {
+	Algorithm  string `mapstructure:"algorithm"`
+	BcryptCost int    `mapstructure:"bcrypt_cost"`
+}
+
+type ExporterConfig struct {
+	Image string `mapstructure:"image"`
+}
+
+type AuthServerConfig struct {
+	Image string `mapstructure:"image"`
+	// Resources and Probes are defaults a BasicAuthenticator's authServer section takes precedence over
+	Resources ResourcesConfig `mapstructure:"resources"`
+	Probes    bool            `mapstructure:"probes"`
+}
+
 func InitConfig(configPath string) (*CustomConfig, error) {
 	viper.SetConfigFile(configPath)
 	viper.SetConfigType("yaml")
@@ -30,5 +67,33 @@ func InitConfig(configPath string) (*CustomConfig, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := validateResources("webserver", customConfig.WebserverConf.Resources); err != nil {
+		return nil, err
+	}
+	if err := validateResources("auth_server", customConfig.AuthServerConf.Resources); err != nil {
+		return nil, err
+	}
+	hashOptions := htpasswd.HashOptions{
+		Algorithm:  htpasswd.Algorithm(customConfig.HtpasswdConf.Algorithm),
+		BcryptCost: customConfig.HtpasswdConf.BcryptCost,
+	}
+	if err := hashOptions.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid htpasswd section: %w", err)
+	}
 	return &customConfig, nil
 }
+
+// validateResources checks the quantities of the resources section of container
+func validateResources(container string, resources ResourcesConfig) error {
+	for name, quantity := range resources.Requests {
+		if _, err := resource.ParseQuantity(quantity); err != nil {
+			return fmt.Errorf("invalid %s.resources.requests.%s: %w", container, name, err)
+		}
+	}
+	for name, quantity := range resources.Limits {
+		if _, err := resource.ParseQuantity(quantity); err != nil {
+			return fmt.Errorf("invalid %s.resources.limits.%s: %w", container, name, err)
+		}
+	}
+	return nil
+}
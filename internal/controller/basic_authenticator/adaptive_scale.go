@@ -0,0 +1,123 @@
+package basic_authenticator
+
+import (
+	"context"
+	"fmt"
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"math"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
+	"strings"
+)
+
+const defaultAdaptiveScalingRatio = 0.5
+
+// getScaleTargetServiceName is the service whose selector picks the workload adaptive scaling follows.
+// A taken over appService selects the authenticator, its backing service still selects the application.
+func getScaleTargetServiceName(basicAuthenticator *v1alpha1.BasicAuthenticator) string {
+	if basicAuthenticator.Spec.ServiceTakeover {
+		return getBackingServiceName(basicAuthenticator)
+	}
+	return basicAuthenticator.Spec.AppService
+}
+
+// acquireTargetReplica derives the authenticator replicas from the workload selected by appService
+func (r *BasicAuthenticatorReconciler) acquireTargetReplica(ctx context.Context, basicAuthenticator *v1alpha1.BasicAuthenticator) (int32, error) {
+	serviceName := getScaleTargetServiceName(basicAuthenticator)
+	var targetService corev1.Service
+	// service should be in same ns with basic auth
+	if err := r.Get(ctx, types.NamespacedName{Name: serviceName, Namespace: basicAuthenticator.Namespace}, &targetService); err != nil {
+		return -1, err
+	}
+	if len(targetService.Spec.Selector) == 0 {
+		return -1, fmt.Errorf("service %s has no selector to find the application workload by", serviceName)
+	}
+
+	workloads, err := findScaleTargets(ctx, r.Client, basicAuthenticator, targetService.Spec.Selector)
+	if err != nil {
+		return -1, err
+	}
+	switch len(workloads) {
+	case 0:
+		return -1, fmt.Errorf("no deployment, statefulset or replicaset is selected by service %s", serviceName)
+	case 1:
+	default:
+		names := make([]string, 0, len(workloads))
+		for _, workload := range workloads {
+			names = append(names, fmt.Sprintf("%s %s", reflect.TypeOf(workload).Elem().Name(), workload.GetName()))
+		}
+		return -1, fmt.Errorf("service %s selects %d workloads (%s), adaptive scaling needs exactly one", serviceName, len(workloads), strings.Join(names, ", "))
+	}
+	return getAdaptiveReplicas(basicAuthenticator.Spec.AdaptiveScaling, getWorkloadReplicas(workloads[0]))
+}
+
+// findScaleTargets returns the workloads of basicAuthenticator's namespace whose pods match selector,
+// leaving out the authenticator itself and workloads without a replica count
+func findScaleTargets(ctx context.Context, k8Client client.Client, basicAuthenticator *v1alpha1.BasicAuthenticator, selector map[string]string) ([]client.Object, error) {
+	workloads, err := listTargetWorkloads(ctx, k8Client, basicAuthenticator.Namespace, labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	podSelector := labels.SelectorFromSet(selector)
+	targets := make([]client.Object, 0)
+	for _, workload := range workloads {
+		if _, isDaemonSet := workload.(*appsv1.DaemonSet); isDaemonSet {
+			continue
+		}
+		if metav1.IsControlledBy(workload, basicAuthenticator) {
+			continue
+		}
+		if podSelector.Matches(labels.Set(getPodTemplate(workload).Labels)) {
+			targets = append(targets, workload)
+		}
+	}
+	return targets, nil
+}
+
+func getWorkloadReplicas(workload client.Object) int32 {
+	var replicas *int32
+	switch typedWorkload := workload.(type) {
+	case *appsv1.Deployment:
+		replicas = typedWorkload.Spec.Replicas
+	case *appsv1.StatefulSet:
+		replicas = typedWorkload.Spec.Replicas
+	case *appsv1.ReplicaSet:
+		replicas = typedWorkload.Spec.Replicas
+	}
+	if replicas == nil {
+		// the API server defaults unset replicas to one
+		return 1
+	}
+	return *replicas
+}
+
+// getAdaptiveReplicas applies the ratio and bounds of adaptiveScaling to the application's replicas
+func getAdaptiveReplicas(adaptiveScaling *v1alpha1.AdaptiveScalingConfig, targetReplicas int32) (int32, error) {
+	ratio := defaultAdaptiveScalingRatio
+	var minReplicas, maxReplicas int32
+	if adaptiveScaling != nil {
+		if adaptiveScaling.Ratio != "" {
+			parsedRatio, err := strconv.ParseFloat(adaptiveScaling.Ratio, 64)
+			if err != nil || parsedRatio <= 0 {
+				return -1, fmt.Errorf("invalid adaptiveScaling.ratio %q", adaptiveScaling.Ratio)
+			}
+			ratio = parsedRatio
+		}
+		minReplicas = adaptiveScaling.MinReplicas
+		maxReplicas = adaptiveScaling.MaxReplicas
+	}
+	replicas := int32(math.Ceil(float64(targetReplicas) * ratio))
+	if maxReplicas > 0 && replicas > maxReplicas {
+		replicas = maxReplicas
+	}
+	if replicas < minReplicas {
+		replicas = minReplicas
+	}
+	return replicas, nil
+}
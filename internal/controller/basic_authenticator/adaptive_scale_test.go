@@ -0,0 +1,51 @@
+package basic_authenticator
+
+import (
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	"testing"
+)
+
+func TestGetAdaptiveReplicas(t *testing.T) {
+	tests := []struct {
+		name            string
+		adaptiveScaling *v1alpha1.AdaptiveScalingConfig
+		targetReplicas  int32
+		want            int32
+		wantErr         bool
+	}{
+		{name: "default ratio", targetReplicas: 4, want: 2},
+		{name: "default ratio rounds up", targetReplicas: 3, want: 2},
+		{name: "default ratio single replica", targetReplicas: 1, want: 1},
+		{name: "no application replicas", targetReplicas: 0, want: 0},
+		{name: "empty config", adaptiveScaling: &v1alpha1.AdaptiveScalingConfig{}, targetReplicas: 5, want: 3},
+		{name: "ratio", adaptiveScaling: &v1alpha1.AdaptiveScalingConfig{Ratio: "0.25"}, targetReplicas: 8, want: 2},
+		{name: "ratio rounds up", adaptiveScaling: &v1alpha1.AdaptiveScalingConfig{Ratio: "0.3"}, targetReplicas: 4, want: 2},
+		{name: "ratio above one", adaptiveScaling: &v1alpha1.AdaptiveScalingConfig{Ratio: "1.5"}, targetReplicas: 3, want: 5},
+		{name: "ratio without leading digit", adaptiveScaling: &v1alpha1.AdaptiveScalingConfig{Ratio: ".5"}, targetReplicas: 6, want: 3},
+		{name: "max replicas", adaptiveScaling: &v1alpha1.AdaptiveScalingConfig{MaxReplicas: 3}, targetReplicas: 10, want: 3},
+		{name: "below max replicas", adaptiveScaling: &v1alpha1.AdaptiveScalingConfig{MaxReplicas: 3}, targetReplicas: 4, want: 2},
+		{name: "min replicas", adaptiveScaling: &v1alpha1.AdaptiveScalingConfig{MinReplicas: 2}, targetReplicas: 1, want: 2},
+		{name: "min replicas without application replicas", adaptiveScaling: &v1alpha1.AdaptiveScalingConfig{MinReplicas: 2}, targetReplicas: 0, want: 2},
+		{name: "min equals max", adaptiveScaling: &v1alpha1.AdaptiveScalingConfig{MinReplicas: 3, MaxReplicas: 3}, targetReplicas: 20, want: 3},
+		{name: "invalid ratio", adaptiveScaling: &v1alpha1.AdaptiveScalingConfig{Ratio: "half"}, targetReplicas: 4, wantErr: true},
+		{name: "zero ratio", adaptiveScaling: &v1alpha1.AdaptiveScalingConfig{Ratio: "0"}, targetReplicas: 4, wantErr: true},
+		{name: "negative ratio", adaptiveScaling: &v1alpha1.AdaptiveScalingConfig{Ratio: "-1"}, targetReplicas: 4, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := getAdaptiveReplicas(test.adaptiveScaling, test.targetReplicas)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("getAdaptiveReplicas() = %d, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getAdaptiveReplicas() returned error: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("getAdaptiveReplicas() = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
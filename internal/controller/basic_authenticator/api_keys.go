@@ -0,0 +1,217 @@
+package basic_authenticator
+
+import (
+	"context"
+	"fmt"
+	"github.com/opdev/subreconciler"
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	"github.com/snapp-incubator/simple-authenticator/pkg/apikey"
+	"github.com/snapp-incubator/simple-authenticator/pkg/random_generator"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SecretAPIKeysField holds the name:sha256 entries of the accepted keys
+	SecretAPIKeysField = "api-keys"
+	// APIKeysVolumeName mounts the hashed keys into the auth server
+	APIKeysVolumeName = "basicauthenticator-api-keys"
+	apiKeysMountDir   = "/etc/api-keys"
+	apiKeyLength      = 40
+	// GeneratedAPIKeyLabel marks a generated key secret with the generation of the BasicAuthenticator it was
+	// generated for, so a reconcile failing to reference it reuses it instead of generating another one
+	GeneratedAPIKeyLabel = "basicauthenticator.snappcloud.io/generated-api-key"
+)
+
+// getAPIKeysSecretName returns the name of the secret holding the hashes of basicAuthenticator's keys
+func getAPIKeysSecretName(basicAuthenticator *v1alpha1.BasicAuthenticator) string {
+	return random_generator.GenerateRandomName(basicAuthenticator.Name, "api-keys")
+}
+
+// createAPIKey returns a secret with a generated key, the way createCredentials generates credentials
+func createAPIKey(basicAuthenticator *v1alpha1.BasicAuthenticator) (*corev1.Secret, error) {
+	key, err := random_generator.GenerateRandomString(apiKeyLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate api key: %w", err)
+	}
+	salt, err := random_generator.GenerateRandomString(10)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      random_generator.GenerateRandomName(basicAuthenticator.Name, salt),
+			Namespace: basicAuthenticator.Namespace,
+			Labels: map[string]string{
+				basicAuthenticatorNameLabel: basicAuthenticator.Name,
+				GeneratedAPIKeyLabel:        strconv.FormatInt(basicAuthenticator.Generation, 10),
+			},
+		},
+		Data: map[string][]byte{
+			v1alpha1.APIKeySecretKey: []byte(key),
+		},
+	}, nil
+}
+
+// hashAPIKeys returns the key file of the auth server, naming each key after its secret
+func hashAPIKeys(keySecrets []*corev1.Secret) ([]byte, error) {
+	var entries strings.Builder
+	for _, secret := range keySecrets {
+		key, ok := secret.Data[v1alpha1.APIKeySecretKey]
+		if !ok || len(key) == 0 {
+			return nil, fmt.Errorf("%s not found in secret %s", v1alpha1.APIKeySecretKey, secret.Name)
+		}
+		entries.WriteString(apikey.FormatEntry(secret.Name, string(key)))
+	}
+	return []byte(entries.String()), nil
+}
+
+// ensureAPIKeys generates a key if none is referenced and keeps the hashes of the referenced keys in a
+// secret owned by basicAuthenticator, which the auth server reads
+func (r *BasicAuthenticatorReconciler) ensureAPIKeys(ctx context.Context, req ctrl.Request) (*ctrl.Result, error) {
+	basicAuthenticator := &v1alpha1.BasicAuthenticator{}
+
+	if r, err := r.getLatestBasicAuthenticator(ctx, req, basicAuthenticator); subreconciler.ShouldHaltOrRequeue(r, err) {
+		return subreconciler.RequeueWithError(err)
+	}
+	hashedSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getAPIKeysSecretName(basicAuthenticator),
+			Namespace: basicAuthenticator.Namespace,
+			Labels:    map[string]string{basicAuthenticatorNameLabel: basicAuthenticator.Name},
+		},
+	}
+	if basicAuthenticator.Spec.APIKeys == nil {
+		if err := r.deleteOwnedSecret(ctx, basicAuthenticator, hashedSecret.Name); err != nil {
+			r.logger.Error(err, "failed to delete api keys secret")
+			return subreconciler.RequeueWithError(err)
+		}
+		return subreconciler.ContinueReconciling()
+	}
+
+	if len(basicAuthenticator.Spec.APIKeys.SecretRefs) == 0 {
+		keySecret, err := r.getGeneratedAPIKey(ctx, basicAuthenticator)
+		if err != nil {
+			r.logger.Error(err, "failed to fetch generated api key secrets")
+			return subreconciler.RequeueWithError(err)
+		}
+		if keySecret == nil {
+			keySecret, err = createAPIKey(basicAuthenticator)
+			if err != nil {
+				r.logger.Error(err, "failed to create api key")
+				return subreconciler.RequeueWithError(err)
+			}
+			if err := ctrl.SetControllerReference(basicAuthenticator, keySecret, r.Scheme); err != nil {
+				r.logger.Error(err, "failed to set secret owner")
+				return subreconciler.RequeueWithError(err)
+			}
+			if err := r.Create(ctx, keySecret); err != nil {
+				r.logger.Error(err, "failed to create api key secret")
+				return subreconciler.RequeueWithError(err)
+			}
+		}
+		// saving the secret's name so the generated key is kept across reconciles
+		basicAuthenticator.Spec.APIKeys.SecretRefs = []string{keySecret.Name}
+		if err := r.Update(ctx, basicAuthenticator); err != nil {
+			r.logger.Error(err, "failed to updated basic authenticator")
+			return subreconciler.RequeueWithError(err)
+		}
+	}
+
+	keySecrets := make([]*corev1.Secret, 0, len(basicAuthenticator.Spec.APIKeys.SecretRefs))
+	for _, secretName := range basicAuthenticator.Spec.APIKeys.SecretRefs {
+		var keySecret corev1.Secret
+		err := r.Get(ctx, types.NamespacedName{Name: secretName, Namespace: basicAuthenticator.Namespace}, &keySecret)
+		if errors.IsNotFound(err) {
+			// a deleted secret revokes its key
+			r.logger.Info("api key secret not found, skipping", "secret", secretName)
+			continue
+		} else if err != nil {
+			r.logger.Error(err, "failed to fetch api key secret", "secret", secretName)
+			return subreconciler.RequeueWithError(err)
+		}
+		keySecrets = append(keySecrets, &keySecret)
+	}
+	hashedKeys, err := hashAPIKeys(keySecrets)
+	if err != nil {
+		r.logger.Error(err, "failed to hash api keys")
+		return subreconciler.RequeueWithError(err)
+	}
+	hashedSecret.Data = map[string][]byte{SecretAPIKeysField: hashedKeys}
+
+	var foundSecret corev1.Secret
+	err = r.Get(ctx, types.NamespacedName{Name: hashedSecret.Name, Namespace: hashedSecret.Namespace}, &foundSecret)
+	if errors.IsNotFound(err) {
+		if err := ctrl.SetControllerReference(basicAuthenticator, hashedSecret, r.Scheme); err != nil {
+			r.logger.Error(err, "failed to set secret owner")
+			return subreconciler.RequeueWithError(err)
+		}
+		if err := r.Create(ctx, hashedSecret); err != nil {
+			r.logger.Error(err, "failed to create api keys secret")
+			return subreconciler.RequeueWithError(err)
+		}
+	} else if err != nil {
+		r.logger.Error(err, "failed to fetch api keys secret")
+		return subreconciler.RequeueWithError(err)
+	} else if !reflect.DeepEqual(hashedSecret.Data, foundSecret.Data) {
+		r.logger.Info("updating api keys secret")
+		foundSecret.Data = hashedSecret.Data
+		if err := r.Update(ctx, &foundSecret); err != nil {
+			r.logger.Error(err, "failed to update api keys secret")
+			return subreconciler.RequeueWithError(err)
+		}
+	}
+	return subreconciler.ContinueReconciling()
+}
+
+// getGeneratedAPIKey returns the key secret generated for the current generation of basicAuthenticator by an
+// earlier reconcile that failed to reference it, nil if there is none. Keys generated for an earlier generation
+// are not reused, their secret may have been removed from the list to revoke them.
+func (r *BasicAuthenticatorReconciler) getGeneratedAPIKey(ctx context.Context, basicAuthenticator *v1alpha1.BasicAuthenticator) (*corev1.Secret, error) {
+	var keySecrets corev1.SecretList
+	if err := r.List(ctx, &keySecrets,
+		client.MatchingLabels{
+			basicAuthenticatorNameLabel: basicAuthenticator.Name,
+			GeneratedAPIKeyLabel:        strconv.FormatInt(basicAuthenticator.Generation, 10),
+		},
+		client.InNamespace(basicAuthenticator.Namespace)); err != nil {
+		return nil, err
+	}
+	for idx := range keySecrets.Items {
+		keySecret := &keySecrets.Items[idx]
+		if metav1.IsControlledBy(keySecret, basicAuthenticator) && len(keySecret.Data[v1alpha1.APIKeySecretKey]) > 0 {
+			return keySecret, nil
+		}
+	}
+	return nil, nil
+}
+
+// deleteOwnedSecret deletes the secret name if basicAuthenticator controls it
+func (r *BasicAuthenticatorReconciler) deleteOwnedSecret(ctx context.Context, basicAuthenticator *v1alpha1.BasicAuthenticator, name string) error {
+	var secret corev1.Secret
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: basicAuthenticator.Namespace}, &secret)
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(&secret, basicAuthenticator) {
+		return nil
+	}
+	return client.IgnoreNotFound(r.Delete(ctx, &secret))
+}
+
+// getAPIKeySecretRefs returns the secrets holding basicAuthenticator's keys
+func getAPIKeySecretRefs(basicAuthenticator *v1alpha1.BasicAuthenticator) []string {
+	if basicAuthenticator.Spec.APIKeys == nil {
+		return nil
+	}
+	return basicAuthenticator.Spec.APIKeys.SecretRefs
+}
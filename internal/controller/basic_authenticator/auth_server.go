@@ -0,0 +1,298 @@
+package basic_authenticator
+
+import (
+	"fmt"
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	"github.com/snapp-incubator/simple-authenticator/internal/config"
+	"github.com/snapp-incubator/simple-authenticator/pkg/apikey"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// authServerUnprivilegedUser is the nonroot user of the distroless auth server image
+	authServerUnprivilegedUser = 65532
+	// LDAPBindVolumeName mounts the LDAP bind secret into the auth server
+	LDAPBindVolumeName = "basicauthenticator-ldap-bind"
+	ldapBindMountDir   = "/etc/ldap-bind"
+	defaultUserFilter  = "(uid={username})"
+	// OIDCClientVolumeName mounts the OIDC client secret into the auth server
+	OIDCClientVolumeName    = "basicauthenticator-oidc-client"
+	oidcClientMountDir      = "/etc/oidc-client"
+	defaultOIDCCookieName   = "_basicauthenticator"
+	defaultOIDCCookieExpire = 8 * time.Hour
+	defaultOIDCGroupsClaim  = "groups"
+	// JWTKeysVolumeName mounts the public keys tokens are verified with into the auth server
+	JWTKeysVolumeName = "basicauthenticator-jwt-keys"
+	jwtKeysMountDir   = "/etc/jwt-keys"
+	// authServerBinary is where the auth server image keeps the binary its exec probes run
+	authServerBinary = "/auth-server"
+)
+
+// authServerDefaultRequests are requested unless configured otherwise, so the auth server is scheduled for
+// and utilization based autoscaling, which needs requests on every container, keeps working
+var authServerDefaultRequests = corev1.ResourceList{
+	corev1.ResourceCPU:    resource.MustParse("10m"),
+	corev1.ResourceMemory: resource.MustParse("32Mi"),
+}
+
+// hasAuthServer reports whether nginx hands authentication to the auth server, for LDAP credentials, OIDC
+// sessions, API keys, JWTs or client certificates
+func hasAuthServer(basicAuthenticator *v1alpha1.BasicAuthenticator) bool {
+	spec := basicAuthenticator.Spec
+	return spec.LDAP != nil || spec.OIDC != nil || spec.APIKeys != nil || spec.JWT != nil || spec.MTLS != nil
+}
+
+// getAuthServerPort returns the port nginx sends its auth subrequests to, zero without an auth server
+func getAuthServerPort(basicAuthenticator *v1alpha1.BasicAuthenticator) int {
+	if !hasAuthServer(basicAuthenticator) {
+		return 0
+	}
+	return v1alpha1.AuthServerPort
+}
+
+func getAuthServerContainerImage(customConfig *config.CustomConfig) string {
+	if customConfig != nil && customConfig.AuthServerConf.Image != "" {
+		return customConfig.AuthServerConf.Image
+	}
+	return authServerDefaultImageAddress
+}
+
+// newAuthServerContainer returns the container checking basicAuthenticator's directory, API keys, tokens and
+// client certificates and serving its OIDC login. It listens on the loopback interface, where only the nginx of its pod reaches it.
+func newAuthServerContainer(basicAuthenticator *v1alpha1.BasicAuthenticator, customConfig *config.CustomConfig) corev1.Container {
+	container := corev1.Container{
+		Name:  authServerContainerName,
+		Image: getAuthServerContainerImage(customConfig),
+		Args:  []string{fmt.Sprintf("--listen-address=127.0.0.1:%d", v1alpha1.AuthServerPort)},
+	}
+	if ldapConfig := basicAuthenticator.Spec.LDAP; ldapConfig != nil {
+		userFilter := ldapConfig.UserFilter
+		if userFilter == "" {
+			userFilter = defaultUserFilter
+		}
+		container.Args = append(container.Args,
+			fmt.Sprintf("--ldap-url=%s", ldapConfig.URL),
+			fmt.Sprintf("--ldap-base-dn=%s", ldapConfig.BaseDN),
+			fmt.Sprintf("--ldap-user-filter=%s", userFilter),
+			fmt.Sprintf("--ldap-cache-ttl=%s", ldapConfig.CacheTTL.Duration),
+		)
+		if ldapConfig.GroupFilter != "" {
+			container.Args = append(container.Args, fmt.Sprintf("--ldap-group-filter=%s", ldapConfig.GroupFilter))
+		}
+		if ldapConfig.StartTLS {
+			container.Args = append(container.Args, "--ldap-start-tls")
+		}
+		if ldapConfig.InsecureSkipVerify {
+			container.Args = append(container.Args, "--ldap-insecure-skip-verify")
+		}
+		if ldapConfig.BindSecretRef != "" {
+			container.Args = append(container.Args, fmt.Sprintf("--ldap-bind-dir=%s", ldapBindMountDir))
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      LDAPBindVolumeName,
+				MountPath: ldapBindMountDir,
+				ReadOnly:  true,
+			})
+		}
+	}
+	if oidcConfig := basicAuthenticator.Spec.OIDC; oidcConfig != nil {
+		container.Args = append(container.Args, getOIDCArgs(oidcConfig)...)
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      OIDCClientVolumeName,
+			MountPath: oidcClientMountDir,
+			ReadOnly:  true,
+		})
+	}
+	if apiKeys := basicAuthenticator.Spec.APIKeys; apiKeys != nil {
+		header := apiKeys.Header
+		if header == "" {
+			header = apikey.AuthorizationHeader
+		}
+		container.Args = append(container.Args,
+			fmt.Sprintf("--api-key-header=%s", header),
+			fmt.Sprintf("--api-keys-file=%s/%s", apiKeysMountDir, SecretAPIKeysField),
+		)
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      APIKeysVolumeName,
+			MountPath: apiKeysMountDir,
+			ReadOnly:  true,
+		})
+	}
+	if jwtConfig := basicAuthenticator.Spec.JWT; jwtConfig != nil {
+		container.Args = append(container.Args, getJWTArgs(jwtConfig)...)
+		if jwtConfig.PublicKeySecretRef != "" {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      JWTKeysVolumeName,
+				MountPath: jwtKeysMountDir,
+				ReadOnly:  true,
+			})
+		}
+	}
+	if basicAuthenticator.Spec.MTLS != nil {
+		container.Args = append(container.Args, getMTLSArgs(basicAuthenticator)...)
+	}
+	if isUnprivileged(customConfig) {
+		container.SecurityContext = newRestrictedSecurityContext(authServerUnprivilegedUser)
+	}
+	applyAuthServerOverrides(&container, basicAuthenticator, customConfig)
+	return container
+}
+
+// applyAuthServerOverrides merges the operator's defaults and then basicAuthenticator's authServer section
+// into the auth server container, requesting authServerDefaultRequests for the resources neither sets
+func applyAuthServerOverrides(container *corev1.Container, basicAuthenticator *v1alpha1.BasicAuthenticator, customConfig *config.CustomConfig) {
+	if customConfig != nil {
+		authServer := customConfig.AuthServerConf
+		mergeResourceList(&container.Resources.Requests, parseResourceList(authServer.Resources.Requests))
+		mergeResourceList(&container.Resources.Limits, parseResourceList(authServer.Resources.Limits))
+		if authServer.Probes {
+			container.LivenessProbe = newAuthServerProbe()
+			container.ReadinessProbe = newAuthServerProbe()
+		}
+	}
+	mergeContainerOverrides(container, basicAuthenticator.Spec.AuthServer)
+	if container.Resources.Requests == nil {
+		container.Resources.Requests = corev1.ResourceList{}
+	}
+	for name, quantity := range authServerDefaultRequests {
+		if _, exists := container.Resources.Requests[name]; !exists {
+			container.Resources.Requests[name] = quantity.DeepCopy()
+		}
+	}
+}
+
+// newAuthServerProbe checks the health endpoint of the auth server. It only listens on the loopback interface,
+// which the kubelet's HTTP probes can't reach, so the auth server binary probes itself.
+func newAuthServerProbe() *corev1.Probe {
+	return withProbeDefaults(&corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{
+				Command: []string{authServerBinary, "--probe", fmt.Sprintf("--listen-address=127.0.0.1:%d", v1alpha1.AuthServerPort)},
+			},
+		},
+	})
+}
+
+// getOIDCArgs returns the auth server flags of an OIDC login, filling in the defaults of an omitted cookie
+func getOIDCArgs(oidcConfig *v1alpha1.OIDCConfig) []string {
+	groupsClaim := oidcConfig.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = defaultOIDCGroupsClaim
+	}
+	cookieName, cookieExpire := defaultOIDCCookieName, defaultOIDCCookieExpire
+	var cookieDomain string
+	var cookieSecure bool
+	if cookie := oidcConfig.Cookie; cookie != nil {
+		if cookie.Name != "" {
+			cookieName = cookie.Name
+		}
+		if cookie.Expire.Duration != 0 {
+			cookieExpire = cookie.Expire.Duration
+		}
+		cookieDomain, cookieSecure = cookie.Domain, cookie.Secure
+	}
+	args := []string{
+		fmt.Sprintf("--oidc-issuer-url=%s", oidcConfig.IssuerURL),
+		fmt.Sprintf("--oidc-client-id=%s", oidcConfig.ClientID),
+		fmt.Sprintf("--oidc-client-secret-file=%s/%s", oidcClientMountDir, v1alpha1.OIDCClientSecretKey),
+		fmt.Sprintf("--oidc-groups-claim=%s", groupsClaim),
+		fmt.Sprintf("--oidc-cookie-name=%s", cookieName),
+		fmt.Sprintf("--oidc-cookie-expire=%s", cookieExpire),
+	}
+	if oidcConfig.RedirectURL != "" {
+		args = append(args, fmt.Sprintf("--oidc-redirect-url=%s", oidcConfig.RedirectURL))
+	}
+	if len(oidcConfig.Scopes) > 0 {
+		args = append(args, fmt.Sprintf("--oidc-scopes=%s", strings.Join(oidcConfig.Scopes, ",")))
+	}
+	if len(oidcConfig.AllowedGroups) > 0 {
+		args = append(args, fmt.Sprintf("--oidc-allowed-groups=%s", strings.Join(oidcConfig.AllowedGroups, ",")))
+	}
+	if len(oidcConfig.AllowedEmails) > 0 {
+		args = append(args, fmt.Sprintf("--oidc-allowed-emails=%s", strings.Join(oidcConfig.AllowedEmails, ",")))
+	}
+	if cookieDomain != "" {
+		args = append(args, fmt.Sprintf("--oidc-cookie-domain=%s", cookieDomain))
+	}
+	if cookieSecure {
+		args = append(args, "--oidc-cookie-secure")
+	}
+	return args
+}
+
+// getJWTArgs returns the auth server flags of JWT validation, ordered so the pod template stays stable
+func getJWTArgs(jwtConfig *v1alpha1.JWTConfig) []string {
+	var args []string
+	if jwtConfig.JWKSURL != "" {
+		args = append(args, fmt.Sprintf("--jwt-jwks-url=%s", jwtConfig.JWKSURL))
+	}
+	if jwtConfig.PublicKeySecretRef != "" {
+		args = append(args, fmt.Sprintf("--jwt-public-keys-dir=%s", jwtKeysMountDir))
+	}
+	if jwtConfig.Issuer != "" {
+		args = append(args, fmt.Sprintf("--jwt-issuer=%s", jwtConfig.Issuer))
+	}
+	if len(jwtConfig.Audiences) > 0 {
+		args = append(args, fmt.Sprintf("--jwt-audiences=%s", strings.Join(jwtConfig.Audiences, ",")))
+	}
+	args = append(args, fmt.Sprintf("--jwt-clock-skew=%s", jwtConfig.ClockSkew.Duration))
+	for _, claim := range sortedKeys(jwtConfig.RequiredClaims) {
+		args = append(args, fmt.Sprintf("--jwt-required-claim=%s=%s", claim, jwtConfig.RequiredClaims[claim]))
+	}
+	for _, claim := range sortedKeys(jwtConfig.ClaimHeaders) {
+		args = append(args, fmt.Sprintf("--jwt-claim-header=%s=%s", claim, jwtConfig.ClaimHeaders[claim]))
+	}
+	return args
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// getAuthServerVolumes returns the volumes of the auth server container
+func getAuthServerVolumes(basicAuthenticator *v1alpha1.BasicAuthenticator) []corev1.Volume {
+	var volumes []corev1.Volume
+	if ldapConfig := basicAuthenticator.Spec.LDAP; ldapConfig != nil && ldapConfig.BindSecretRef != "" {
+		volumes = append(volumes, newSecretVolume(LDAPBindVolumeName, ldapConfig.BindSecretRef))
+	}
+	if oidcConfig := basicAuthenticator.Spec.OIDC; oidcConfig != nil {
+		volumes = append(volumes, newSecretVolume(OIDCClientVolumeName, oidcConfig.ClientSecretRef))
+	}
+	if basicAuthenticator.Spec.APIKeys != nil {
+		volumes = append(volumes, newSecretVolume(APIKeysVolumeName, getAPIKeysSecretName(basicAuthenticator)))
+	}
+	if jwtConfig := basicAuthenticator.Spec.JWT; jwtConfig != nil && jwtConfig.PublicKeySecretRef != "" {
+		volumes = append(volumes, newSecretVolume(JWTKeysVolumeName, jwtConfig.PublicKeySecretRef))
+	}
+	return volumes
+}
+
+func newSecretVolume(name, secretName string) corev1.Volume {
+	defaultMode := corev1.SecretVolumeSourceDefaultMode
+	return corev1.Volume{
+		Name: name,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName:  secretName,
+				DefaultMode: &defaultMode,
+			},
+		},
+	}
+}
+
+// addAuthServer adds the auth server container and its volumes to the pods of the authenticator deployment
+func addAuthServer(basicAuthenticator *v1alpha1.BasicAuthenticator, podSpec *corev1.PodSpec, customConfig *config.CustomConfig) {
+	if !hasAuthServer(basicAuthenticator) {
+		return
+	}
+	podSpec.Containers = append(podSpec.Containers, newAuthServerContainer(basicAuthenticator, customConfig))
+	podSpec.Volumes = append(podSpec.Volumes, getAuthServerVolumes(basicAuthenticator)...)
+}
@@ -0,0 +1,215 @@
+package basic_authenticator
+
+import (
+	"context"
+	"fmt"
+	"github.com/opdev/subreconciler"
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	"github.com/snapp-incubator/simple-authenticator/internal/config"
+	"github.com/snapp-incubator/simple-authenticator/pkg/random_generator"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"strconv"
+)
+
+const defaultRequestsPerSecondMetric = "nginx_http_requests_per_second"
+
+var (
+	// utilization targets are relative to requests, so the authenticator gets some when scaled on them
+	defaultCPURequest    = resource.MustParse("50m")
+	defaultMemoryRequest = resource.MustParse("32Mi")
+	// the exporter shares the pod the utilization is averaged over, which needs requests on every container
+	exporterDefaultRequests = corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("10m"),
+		corev1.ResourceMemory: resource.MustParse("16Mi"),
+	}
+)
+
+// hasAutoscaler reports whether a HorizontalPodAutoscaler owns the replicas of the authenticator deployment
+func hasAutoscaler(basicAuthenticator *v1alpha1.BasicAuthenticator) bool {
+	return basicAuthenticator.Spec.Autoscaling != nil && basicAuthenticator.Spec.Type != "sidecar"
+}
+
+// getNginxStatusPort returns the port stub_status is served on, zero when no exporter reads it
+func getNginxStatusPort(basicAuthenticator *v1alpha1.BasicAuthenticator) int {
+	if !hasAutoscaler(basicAuthenticator) || basicAuthenticator.Spec.Autoscaling.RequestsPerSecond == nil {
+		return 0
+	}
+	return v1alpha1.NginxStatusPort
+}
+
+func getExporterContainerImage(customConfig *config.CustomConfig) string {
+	if customConfig != nil && customConfig.ExporterConf.Image != "" {
+		return customConfig.ExporterConf.Image
+	}
+	return exporterDefaultImageAddress
+}
+
+// addAutoscalingSupport prepares the authenticator pod for its autoscaling targets: resource requests for
+// utilization targets and an nginx exporter for the request rate
+func addAutoscalingSupport(basicAuthenticator *v1alpha1.BasicAuthenticator, podTemplate *corev1.PodTemplateSpec, container *corev1.Container, customConfig *config.CustomConfig) {
+	if !hasAutoscaler(basicAuthenticator) {
+		return
+	}
+	autoscaling := basicAuthenticator.Spec.Autoscaling
+	if autoscaling.TargetCPUUtilization != nil || autoscaling.TargetMemoryUtilization != nil {
+		if container.Resources.Requests == nil {
+			container.Resources.Requests = corev1.ResourceList{}
+		}
+		if _, exists := container.Resources.Requests[corev1.ResourceCPU]; !exists && autoscaling.TargetCPUUtilization != nil {
+			container.Resources.Requests[corev1.ResourceCPU] = defaultCPURequest.DeepCopy()
+		}
+		if _, exists := container.Resources.Requests[corev1.ResourceMemory]; !exists && autoscaling.TargetMemoryUtilization != nil {
+			container.Resources.Requests[corev1.ResourceMemory] = defaultMemoryRequest.DeepCopy()
+		}
+	}
+
+	statusPort := getNginxStatusPort(basicAuthenticator)
+	if statusPort == 0 {
+		return
+	}
+	exporter := corev1.Container{
+		Name:  exporterContainerName,
+		Image: getExporterContainerImage(customConfig),
+		Args: []string{
+			fmt.Sprintf("--nginx.scrape-uri=http://127.0.0.1:%d/stub_status", statusPort),
+			fmt.Sprintf("--web.listen-address=:%d", v1alpha1.ExporterPort),
+		},
+		Ports: []corev1.ContainerPort{
+			{
+				Name:          "metrics",
+				ContainerPort: v1alpha1.ExporterPort,
+				Protocol:      corev1.ProtocolTCP,
+			},
+		},
+		Resources: corev1.ResourceRequirements{
+			Requests: exporterDefaultRequests.DeepCopy(),
+		},
+	}
+	if isUnprivileged(customConfig) {
+		exporter.SecurityContext = newRestrictedSecurityContext(exporterUnprivilegedUser)
+	}
+	podTemplate.Spec.Containers = append(podTemplate.Spec.Containers, exporter)
+	if podTemplate.Annotations == nil {
+		podTemplate.Annotations = make(map[string]string)
+	}
+	podTemplate.Annotations["prometheus.io/scrape"] = "true"
+	podTemplate.Annotations["prometheus.io/port"] = strconv.Itoa(v1alpha1.ExporterPort)
+}
+
+func createHorizontalPodAutoscaler(basicAuthenticator *v1alpha1.BasicAuthenticator) *autoscalingv2.HorizontalPodAutoscaler {
+	autoscaling := basicAuthenticator.Spec.Autoscaling
+	minReplicas := autoscaling.MinReplicas
+	if minReplicas == 0 {
+		minReplicas = 1
+	}
+
+	metrics := make([]autoscalingv2.MetricSpec, 0)
+	if autoscaling.TargetCPUUtilization != nil {
+		metrics = append(metrics, newResourceMetric(corev1.ResourceCPU, *autoscaling.TargetCPUUtilization))
+	}
+	if autoscaling.TargetMemoryUtilization != nil {
+		metrics = append(metrics, newResourceMetric(corev1.ResourceMemory, *autoscaling.TargetMemoryUtilization))
+	}
+	if requestsPerSecond := autoscaling.RequestsPerSecond; requestsPerSecond != nil {
+		metricName := requestsPerSecond.MetricName
+		if metricName == "" {
+			metricName = defaultRequestsPerSecondMetric
+		}
+		metrics = append(metrics, autoscalingv2.MetricSpec{
+			Type: autoscalingv2.PodsMetricSourceType,
+			Pods: &autoscalingv2.PodsMetricSource{
+				Metric: autoscalingv2.MetricIdentifier{Name: metricName},
+				Target: autoscalingv2.MetricTarget{
+					Type:         autoscalingv2.AverageValueMetricType,
+					AverageValue: resource.NewQuantity(int64(requestsPerSecond.AverageValue), resource.DecimalSI),
+				},
+			},
+		})
+	}
+
+	return &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      basicAuthenticator.Name,
+			Namespace: basicAuthenticator.Namespace,
+			Labels:    map[string]string{basicAuthenticatorNameLabel: basicAuthenticator.Name},
+		},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       random_generator.GenerateRandomName(basicAuthenticator.Name, "deployment"),
+			},
+			MinReplicas: &minReplicas,
+			MaxReplicas: autoscaling.MaxReplicas,
+			Metrics:     metrics,
+		},
+	}
+}
+
+func newResourceMetric(name corev1.ResourceName, utilization int32) autoscalingv2.MetricSpec {
+	return autoscalingv2.MetricSpec{
+		Type: autoscalingv2.ResourceMetricSourceType,
+		Resource: &autoscalingv2.ResourceMetricSource{
+			Name: name,
+			Target: autoscalingv2.MetricTarget{
+				Type:               autoscalingv2.UtilizationMetricType,
+				AverageUtilization: &utilization,
+			},
+		},
+	}
+}
+
+// ensureHorizontalPodAutoscaler creates the authenticator deployment's HorizontalPodAutoscaler, and deletes it once
+// autoscaling is disabled
+func (r *BasicAuthenticatorReconciler) ensureHorizontalPodAutoscaler(ctx context.Context, req ctrl.Request) (*ctrl.Result, error) {
+	basicAuthenticator := &v1alpha1.BasicAuthenticator{}
+
+	if r, err := r.getLatestBasicAuthenticator(ctx, req, basicAuthenticator); subreconciler.ShouldHaltOrRequeue(r, err) {
+		return subreconciler.RequeueWithError(err)
+	}
+	if !hasAutoscaler(basicAuthenticator) {
+		if err := r.deleteOwned(ctx, basicAuthenticator, &autoscalingv2.HorizontalPodAutoscaler{}); err != nil {
+			r.logger.Error(err, "failed to delete horizontal pod autoscaler")
+			return subreconciler.RequeueWithError(err)
+		}
+		return subreconciler.ContinueReconciling()
+	}
+
+	newAutoscaler := createHorizontalPodAutoscaler(basicAuthenticator)
+	foundAutoscaler := &autoscalingv2.HorizontalPodAutoscaler{}
+	err := r.Get(ctx, types.NamespacedName{Name: newAutoscaler.Name, Namespace: newAutoscaler.Namespace}, foundAutoscaler)
+	if errors.IsNotFound(err) {
+		if err := ctrl.SetControllerReference(basicAuthenticator, newAutoscaler, r.Scheme); err != nil {
+			r.logger.Error(err, "failed to set horizontal pod autoscaler owner")
+			return subreconciler.RequeueWithError(err)
+		}
+		if err := r.Create(ctx, newAutoscaler); err != nil {
+			r.logger.Error(err, "failed to create horizontal pod autoscaler")
+			return subreconciler.RequeueWithError(err)
+		}
+	} else if err != nil {
+		r.logger.Error(err, "failed to fetch horizontal pod autoscaler")
+		return subreconciler.RequeueWithError(err)
+	} else if !metav1.IsControlledBy(foundAutoscaler, basicAuthenticator) {
+		return subreconciler.RequeueWithError(fmt.Errorf("horizontal pod autoscaler %s exists and is not managed by %s", foundAutoscaler.Name, basicAuthenticator.Name))
+	} else {
+		// the API server defaults the scaling behavior, which is left alone
+		newAutoscaler.Spec.Behavior = foundAutoscaler.Spec.Behavior
+		if !equality.Semantic.DeepEqual(newAutoscaler.Spec, foundAutoscaler.Spec) {
+			r.logger.Info("updating horizontal pod autoscaler")
+			foundAutoscaler.Spec = newAutoscaler.Spec
+			if err := r.Update(ctx, foundAutoscaler); err != nil {
+				r.logger.Error(err, "failed to update horizontal pod autoscaler")
+				return subreconciler.RequeueWithError(err)
+			}
+		}
+	}
+	return subreconciler.ContinueReconciling()
+}
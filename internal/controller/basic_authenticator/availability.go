@@ -0,0 +1,158 @@
+package basic_authenticator
+
+import (
+	"context"
+	"fmt"
+	"github.com/opdev/subreconciler"
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	"github.com/snapp-incubator/simple-authenticator/pkg/random_generator"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// defaultTopologyKeys spread the authenticator over zones first and then over nodes
+var defaultTopologyKeys = []string{"topology.kubernetes.io/zone", "kubernetes.io/hostname"}
+
+// mayRunMultipleReplicas reports whether the authenticator deployment can have more than one replica,
+// decided on the spec alone so the pod template doesn't change while it is scaled
+func mayRunMultipleReplicas(basicAuthenticator *v1alpha1.BasicAuthenticator) bool {
+	switch {
+	case hasAutoscaler(basicAuthenticator):
+		return basicAuthenticator.Spec.Autoscaling.MaxReplicas > 1
+	case basicAuthenticator.Spec.AdaptiveScale:
+		adaptiveScaling := basicAuthenticator.Spec.AdaptiveScaling
+		return adaptiveScaling == nil || adaptiveScaling.MaxReplicas != 1
+	default:
+		return basicAuthenticator.Spec.Replicas > 1
+	}
+}
+
+// addTopologySpread spreads the pods of the authenticator deployment, selected by podLabels, over zones and nodes
+func addTopologySpread(basicAuthenticator *v1alpha1.BasicAuthenticator, podSpec *corev1.PodSpec, podLabels map[string]string) {
+	availability := basicAuthenticator.Spec.Availability
+	if !mayRunMultipleReplicas(basicAuthenticator) || (availability != nil && availability.DisableTopologySpread) {
+		return
+	}
+	if availability != nil && len(availability.TopologySpreadConstraints) > 0 {
+		for _, constraint := range availability.TopologySpreadConstraints {
+			constraint := *constraint.DeepCopy()
+			if constraint.LabelSelector == nil {
+				constraint.LabelSelector = &metav1.LabelSelector{MatchLabels: podLabels}
+			}
+			podSpec.TopologySpreadConstraints = append(podSpec.TopologySpreadConstraints, constraint)
+		}
+		return
+	}
+	// soft constraints, so a single zone or node never keeps replicas from being scheduled
+	for _, topologyKey := range defaultTopologyKeys {
+		podSpec.TopologySpreadConstraints = append(podSpec.TopologySpreadConstraints, corev1.TopologySpreadConstraint{
+			MaxSkew:           1,
+			TopologyKey:       topologyKey,
+			WhenUnsatisfiable: corev1.ScheduleAnyway,
+			LabelSelector:     &metav1.LabelSelector{MatchLabels: podLabels},
+		})
+	}
+}
+
+func createPodDisruptionBudget(basicAuthenticator *v1alpha1.BasicAuthenticator, selector *metav1.LabelSelector) *policyv1.PodDisruptionBudget {
+	podDisruptionBudget := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      basicAuthenticator.Name,
+			Namespace: basicAuthenticator.Namespace,
+			Labels:    map[string]string{basicAuthenticatorNameLabel: basicAuthenticator.Name},
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector: selector.DeepCopy(),
+		},
+	}
+	availability := basicAuthenticator.Spec.Availability
+	switch {
+	case availability != nil && availability.MinAvailable != nil:
+		minAvailable := *availability.MinAvailable
+		podDisruptionBudget.Spec.MinAvailable = &minAvailable
+	case availability != nil && availability.MaxUnavailable != nil:
+		maxUnavailable := *availability.MaxUnavailable
+		podDisruptionBudget.Spec.MaxUnavailable = &maxUnavailable
+	default:
+		maxUnavailable := intstr.FromInt(1)
+		podDisruptionBudget.Spec.MaxUnavailable = &maxUnavailable
+	}
+	return podDisruptionBudget
+}
+
+// needsPodDisruptionBudget reports whether the authenticator deployment, currently at replicas, gets a
+// PodDisruptionBudget. A single replica doesn't, as its budget would block node drains.
+func needsPodDisruptionBudget(basicAuthenticator *v1alpha1.BasicAuthenticator, replicas *int32) bool {
+	if basicAuthenticator.Spec.Type == "sidecar" {
+		return false
+	}
+	if availability := basicAuthenticator.Spec.Availability; availability != nil && availability.DisablePodDisruptionBudget {
+		return false
+	}
+	return replicas != nil && *replicas > 1
+}
+
+// ensurePodDisruptionBudget creates the PodDisruptionBudget of the authenticator deployment while it runs more
+// than one replica, and deletes it otherwise
+func (r *BasicAuthenticatorReconciler) ensurePodDisruptionBudget(ctx context.Context, req ctrl.Request) (*ctrl.Result, error) {
+	basicAuthenticator := &v1alpha1.BasicAuthenticator{}
+
+	if r, err := r.getLatestBasicAuthenticator(ctx, req, basicAuthenticator); subreconciler.ShouldHaltOrRequeue(r, err) {
+		return subreconciler.RequeueWithError(err)
+	}
+
+	foundDeployment := &appsv1.Deployment{}
+	if basicAuthenticator.Spec.Type != "sidecar" {
+		deploymentName := random_generator.GenerateRandomName(basicAuthenticator.Name, "deployment")
+		err := r.Get(ctx, types.NamespacedName{Name: deploymentName, Namespace: basicAuthenticator.Namespace}, foundDeployment)
+		if err != nil && !errors.IsNotFound(err) {
+			r.logger.Error(err, "failed to fetch deployment")
+			return subreconciler.RequeueWithError(err)
+		}
+	}
+	if !needsPodDisruptionBudget(basicAuthenticator, foundDeployment.Spec.Replicas) || foundDeployment.Spec.Selector == nil {
+		if err := r.deleteOwned(ctx, basicAuthenticator, &policyv1.PodDisruptionBudget{}); err != nil {
+			r.logger.Error(err, "failed to delete pod disruption budget")
+			return subreconciler.RequeueWithError(err)
+		}
+		return subreconciler.ContinueReconciling()
+	}
+
+	newBudget := createPodDisruptionBudget(basicAuthenticator, foundDeployment.Spec.Selector)
+	foundBudget := &policyv1.PodDisruptionBudget{}
+	err := r.Get(ctx, types.NamespacedName{Name: newBudget.Name, Namespace: newBudget.Namespace}, foundBudget)
+	if errors.IsNotFound(err) {
+		if err := ctrl.SetControllerReference(basicAuthenticator, newBudget, r.Scheme); err != nil {
+			r.logger.Error(err, "failed to set pod disruption budget owner")
+			return subreconciler.RequeueWithError(err)
+		}
+		if err := r.Create(ctx, newBudget); err != nil {
+			r.logger.Error(err, "failed to create pod disruption budget")
+			return subreconciler.RequeueWithError(err)
+		}
+	} else if err != nil {
+		r.logger.Error(err, "failed to fetch pod disruption budget")
+		return subreconciler.RequeueWithError(err)
+	} else if !metav1.IsControlledBy(foundBudget, basicAuthenticator) {
+		return subreconciler.RequeueWithError(fmt.Errorf("pod disruption budget %s exists and is not managed by %s", foundBudget.Name, basicAuthenticator.Name))
+	} else {
+		// the eviction policy for unhealthy pods is left to whoever set it
+		newBudget.Spec.UnhealthyPodEvictionPolicy = foundBudget.Spec.UnhealthyPodEvictionPolicy
+		if !equality.Semantic.DeepEqual(newBudget.Spec, foundBudget.Spec) {
+			r.logger.Info("updating pod disruption budget")
+			foundBudget.Spec = newBudget.Spec
+			if err := r.Update(ctx, foundBudget); err != nil {
+				r.logger.Error(err, "failed to update pod disruption budget")
+				return subreconciler.RequeueWithError(err)
+			}
+		}
+	}
+	return subreconciler.ContinueReconciling()
+}
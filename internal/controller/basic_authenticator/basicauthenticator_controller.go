@@ -23,17 +23,24 @@ import (
 	authenticatorv1alpha1 "github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
 	"github.com/snapp-incubator/simple-authenticator/internal/config"
 	appv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
+	"time"
 )
 
 // BasicAuthenticatorReconciler reconciles a BasicAuthenticator object
@@ -43,8 +50,11 @@ type BasicAuthenticatorReconciler struct {
 	CustomConfig                *config.CustomConfig
 	configMapName               string
 	credentialName              string
+	configHash                  string
 	basicAuthenticatorNamespace string
 	deploymentLabel             *v1.LabelSelector
+	workloadReady               bool
+	requeueDelay                time.Duration
 	logger                      logr.Logger
 }
 
@@ -52,9 +62,19 @@ type BasicAuthenticatorReconciler struct {
 //+kubebuilder:rbac:groups=authenticator.snappcloud.io,resources=basicauthenticators/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=authenticator.snappcloud.io,resources=basicauthenticators/finalizers,verbs=update
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=statefulsets;daemonsets;replicasets,verbs=get;list;watch;update;patch
 //+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods/eviction,verbs=create
 //+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=route.openshift.io,resources=routes;routes/custom-host,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=traefik.io,resources=middlewares,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
 
 func (r *BasicAuthenticatorReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	r.logger = log.FromContext(ctx)
@@ -79,40 +99,204 @@ func (r *BasicAuthenticatorReconciler) Reconcile(ctx context.Context, req ctrl.R
 
 func (r *BasicAuthenticatorReconciler) initVars(request ctrl.Request) {
 	r.basicAuthenticatorNamespace = request.Namespace
+	r.requeueDelay = 0
 	//configmap name and credential name's value would be set in reconcile loop
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *BasicAuthenticatorReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&authenticatorv1alpha1.BasicAuthenticator{}).
+		// status writes don't bump the generation, reconciling them would undo the rate limiter's backoff. The
+		// rotation annotation is the one metadata change acted upon.
+		For(&authenticatorv1alpha1.BasicAuthenticator{}, builder.WithPredicates(predicate.Or(
+			predicate.GenerationChangedPredicate{},
+			predicate.AnnotationChangedPredicate{},
+		))).
 		Owns(&appv1.Deployment{}).
 		Owns(&corev1.ConfigMap{}).
 		Owns(&corev1.Secret{}).
 		Owns(&corev1.Service{}).
+		Owns(&networkingv1.Ingress{}).
+		Owns(&autoscalingv2.HorizontalPodAutoscaler{}).
+		Owns(&policyv1.PodDisruptionBudget{}).
 		Watches(
 			&source.Kind{Type: &appv1.Deployment{}},
-			handler.EnqueueRequestsFromMapFunc(r.findExternallyManagedDeployments),
+			handler.EnqueueRequestsFromMapFunc(r.findInjectingBasicAuthenticators),
+		).
+		Watches(
+			&source.Kind{Type: &appv1.Deployment{}},
+			handler.EnqueueRequestsFromMapFunc(r.findAdaptiveScalingBasicAuthenticators),
+		).
+		Watches(
+			&source.Kind{Type: &appv1.StatefulSet{}},
+			handler.EnqueueRequestsFromMapFunc(r.findInjectingBasicAuthenticators),
+		).
+		Watches(
+			&source.Kind{Type: &appv1.StatefulSet{}},
+			handler.EnqueueRequestsFromMapFunc(r.findAdaptiveScalingBasicAuthenticators),
+		).
+		Watches(
+			&source.Kind{Type: &appv1.DaemonSet{}},
+			handler.EnqueueRequestsFromMapFunc(r.findInjectingBasicAuthenticators),
+		).
+		Watches(
+			&source.Kind{Type: &appv1.ReplicaSet{}},
+			handler.EnqueueRequestsFromMapFunc(r.findInjectingBasicAuthenticators),
+		).
+		Watches(
+			&source.Kind{Type: &appv1.ReplicaSet{}},
+			handler.EnqueueRequestsFromMapFunc(r.findAdaptiveScalingBasicAuthenticators),
+		).
+		Watches(
+			&source.Kind{Type: &networkingv1.Ingress{}},
+			handler.EnqueueRequestsFromMapFunc(r.findForwardAuthBasicAuthenticators),
+		).
+		Watches(
+			&source.Kind{Type: &corev1.Service{}},
+			handler.EnqueueRequestsFromMapFunc(r.findAppServiceBasicAuthenticators),
+		).
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(r.findReferencingBasicAuthenticators),
+		).
+		Watches(
+			&source.Kind{Type: &corev1.ConfigMap{}},
+			handler.EnqueueRequestsFromMapFunc(r.findConfigMapReferencingBasicAuthenticators),
 		).
 		Complete(r)
 }
 
-func (r *BasicAuthenticatorReconciler) findExternallyManagedDeployments(deployment client.Object) []reconcile.Request {
-	deploy, ok := deployment.(*appv1.Deployment)
-	if !ok {
+// findAdaptiveScalingBasicAuthenticators maps a workload to the BasicAuthenticators scaling after it
+func (r *BasicAuthenticatorReconciler) findAdaptiveScalingBasicAuthenticators(workload client.Object) []reconcile.Request {
+	podTemplate := getPodTemplate(workload)
+	if podTemplate == nil {
+		return nil
+	}
+	var basicAuthenticators authenticatorv1alpha1.BasicAuthenticatorList
+	if err := r.List(context.Background(), &basicAuthenticators, client.InNamespace(workload.GetNamespace())); err != nil {
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0)
+	for idx := range basicAuthenticators.Items {
+		basicAuthenticator := &basicAuthenticators.Items[idx]
+		if !basicAuthenticator.Spec.AdaptiveScale || basicAuthenticator.Spec.AppService == "" {
+			continue
+		}
+		var targetService corev1.Service
+		if err := r.Get(context.Background(), types.NamespacedName{Name: getScaleTargetServiceName(basicAuthenticator), Namespace: basicAuthenticator.Namespace}, &targetService); err != nil {
+			continue
+		}
+		if len(targetService.Spec.Selector) == 0 {
+			continue
+		}
+		if labels.SelectorFromSet(targetService.Spec.Selector).Matches(labels.Set(podTemplate.Labels)) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: basicAuthenticator.Name, Namespace: basicAuthenticator.Namespace},
+			})
+		}
+	}
+	return requests
+}
+
+func (r *BasicAuthenticatorReconciler) findReferencingBasicAuthenticators(secret client.Object) []reconcile.Request {
+	var basicAuthenticators authenticatorv1alpha1.BasicAuthenticatorList
+	if err := r.List(context.Background(), &basicAuthenticators, client.InNamespace(secret.GetNamespace())); err != nil {
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0)
+	for _, basicAuthenticator := range basicAuthenticators.Items {
+		if existsInList(getCredentialsSecretRefs(&basicAuthenticator), secret.GetName()) ||
+			existsInList(getAPIKeySecretRefs(&basicAuthenticator), secret.GetName()) ||
+			existsInList(getMountedSecretNames(&basicAuthenticator), secret.GetName()) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: basicAuthenticator.Name, Namespace: basicAuthenticator.Namespace},
+			})
+		}
+	}
+	return requests
+}
+
+// findConfigMapReferencingBasicAuthenticators maps a configmap to the BasicAuthenticators mounting it, such as
+// the CA bundle client certificates are verified against
+func (r *BasicAuthenticatorReconciler) findConfigMapReferencingBasicAuthenticators(configMap client.Object) []reconcile.Request {
+	var basicAuthenticators authenticatorv1alpha1.BasicAuthenticatorList
+	if err := r.List(context.Background(), &basicAuthenticators, client.InNamespace(configMap.GetNamespace())); err != nil {
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0)
+	for _, basicAuthenticator := range basicAuthenticators.Items {
+		if existsInList(getMountedConfigMapNames(&basicAuthenticator), configMap.GetName()) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: basicAuthenticator.Name, Namespace: basicAuthenticator.Namespace},
+			})
+		}
+	}
+	return requests
+}
+
+// findInjectingBasicAuthenticators maps a workload to the sidecar BasicAuthenticators injecting it
+func (r *BasicAuthenticatorReconciler) findInjectingBasicAuthenticators(workload client.Object) []reconcile.Request {
+	var basicAuthenticators authenticatorv1alpha1.BasicAuthenticatorList
+	if err := r.List(context.Background(), &basicAuthenticators, client.InNamespace(workload.GetNamespace())); err != nil {
 		return nil
 	}
-	if deploy.ObjectMeta.Annotations == nil {
+
+	requests := make([]reconcile.Request, 0)
+	for _, basicAuthenticator := range basicAuthenticators.Items {
+		if basicAuthenticator.Spec.Type != "sidecar" || basicAuthenticator.Spec.InjectionMode == authenticatorv1alpha1.InjectionModePodWebhook {
+			continue
+		}
+		selector := labels.SelectorFromSet(basicAuthenticator.Spec.Selector.MatchLabels)
+		if selector.Matches(labels.Set(workload.GetLabels())) || workload.GetLabels()[basicAuthenticatorNameLabel] == basicAuthenticator.Name {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: basicAuthenticator.Name, Namespace: basicAuthenticator.Namespace},
+			})
+		}
+	}
+	return requests
+}
+
+// findForwardAuthBasicAuthenticators maps an ingress to the forwardauth BasicAuthenticators wired into it
+func (r *BasicAuthenticatorReconciler) findForwardAuthBasicAuthenticators(ingress client.Object) []reconcile.Request {
+	var basicAuthenticators authenticatorv1alpha1.BasicAuthenticatorList
+	if err := r.List(context.Background(), &basicAuthenticators, client.InNamespace(ingress.GetNamespace())); err != nil {
 		return nil
 	}
-	basicAuthName, exists := deploy.ObjectMeta.Annotations[ExternallyManaged]
-	if !exists {
+
+	requests := make([]reconcile.Request, 0)
+	for _, basicAuthenticator := range basicAuthenticators.Items {
+		if basicAuthenticator.Spec.Type != "forwardauth" {
+			continue
+		}
+		listed := basicAuthenticator.Spec.ForwardAuth != nil && existsInList(basicAuthenticator.Spec.ForwardAuth.Ingresses, ingress.GetName())
+		if listed || ingress.GetLabels()[basicAuthenticatorNameLabel] == basicAuthenticator.Name {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: basicAuthenticator.Name, Namespace: basicAuthenticator.Namespace},
+			})
+		}
+	}
+	return requests
+}
+
+// findAppServiceBasicAuthenticators maps a service to the BasicAuthenticators taking it over or scaling after
+// the workload it selects
+func (r *BasicAuthenticatorReconciler) findAppServiceBasicAuthenticators(service client.Object) []reconcile.Request {
+	var basicAuthenticators authenticatorv1alpha1.BasicAuthenticatorList
+	if err := r.List(context.Background(), &basicAuthenticators, client.InNamespace(service.GetNamespace())); err != nil {
 		return nil
 	}
 
-	return []reconcile.Request{
-		{
-			NamespacedName: types.NamespacedName{Name: basicAuthName, Namespace: deploy.Namespace},
-		},
+	requests := make([]reconcile.Request, 0)
+	for _, basicAuthenticator := range basicAuthenticators.Items {
+		referenced := (basicAuthenticator.Spec.ServiceTakeover || basicAuthenticator.Spec.AdaptiveScale) && basicAuthenticator.Spec.AppService == service.GetName()
+		if referenced || service.GetLabels()[ServiceTakeoverLabel] == basicAuthenticator.Name {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: basicAuthenticator.Name, Namespace: basicAuthenticator.Namespace},
+			})
+		}
 	}
+	return requests
 }
@@ -5,12 +5,13 @@ import (
 	"errors"
 	"github.com/opdev/subreconciler"
 	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
-	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"strings"
 )
 
 func (r *BasicAuthenticatorReconciler) Cleanup(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -18,6 +19,8 @@ func (r *BasicAuthenticatorReconciler) Cleanup(ctx context.Context, req ctrl.Req
 	subRecs := []subreconciler.FnWithRequest{
 		r.setDeletionStatus,
 		r.removeInjectedContainers,
+		r.removeForwardAuthAnnotations,
+		r.rollbackServiceTakeover,
 		r.removeCleanupFinalizer,
 	}
 	for _, rec := range subRecs {
@@ -34,9 +37,11 @@ func (r *BasicAuthenticatorReconciler) setDeletionStatus(ctx context.Context, re
 	if r, err := r.getLatestBasicAuthenticator(ctx, req, basicAuthenticator); subreconciler.ShouldHaltOrRequeue(r, err) {
 		return subreconciler.RequeueWithError(err)
 	}
-	basicAuthenticator.Status.State = StatusDeleting
-
-	if err := r.Update(ctx, basicAuthenticator); err != nil {
+	err := r.updateStatus(ctx, req, func(status *v1alpha1.BasicAuthenticatorStatus, generation int64) {
+		status.State = StatusDeleting
+		setCondition(status, v1alpha1.ConditionReady, metav1.ConditionFalse, v1alpha1.ReasonDeleting, "basic authenticator is being deleted", generation)
+	})
+	if err != nil {
 		r.logger.Error(err, "Failed to update status while cleaning")
 		return subreconciler.RequeueWithError(err)
 	}
@@ -55,9 +60,9 @@ func (r *BasicAuthenticatorReconciler) removeInjectedContainers(ctx context.Cont
 	basicAuthLabel := map[string]string{
 		basicAuthenticatorNameLabel: basicAuthenticator.Name,
 	}
-	deployments, err := getTargetDeployment(ctx, basicAuthenticator, r.Client, basicAuthLabel)
+	workloads, err := listTargetWorkloads(ctx, r.Client, basicAuthenticator.Namespace, labels.SelectorFromSet(basicAuthLabel))
 	if err != nil {
-		r.logger.Error(err, "failed to get target deployments to clean up")
+		r.logger.Error(err, "failed to get target workloads to clean up")
 		return subreconciler.RequeueWithError(err)
 	}
 	configmaps, err := getTargetConfigmapNames(ctx, basicAuthenticator, r.Client, basicAuthLabel)
@@ -72,10 +77,10 @@ func (r *BasicAuthenticatorReconciler) removeInjectedContainers(ctx context.Cont
 	}
 	r.logger.Info("debug", "configmap", configmaps, "secret", secrets)
 
-	cleanupDeployments := removeInjectedResources(deployments, secrets, configmaps)
-	for _, deploy := range cleanupDeployments {
-		if err := r.Update(ctx, deploy); err != nil {
-			r.logger.Error(err, "failed to add update cleaned up deployments")
+	cleanupWorkloads := removeInjectedResources(workloads, secrets, configmaps)
+	for _, workload := range cleanupWorkloads {
+		if err := r.Update(ctx, workload); err != nil {
+			r.logger.Error(err, "failed to update cleaned up workloads", "workload", workload.GetName())
 			return subreconciler.RequeueWithError(err)
 		}
 	}
@@ -100,22 +105,6 @@ func (r *BasicAuthenticatorReconciler) removeCleanupFinalizer(ctx context.Contex
 	return subreconciler.ContinueReconciling()
 }
 
-func getTargetDeployment(ctx context.Context, basicAuthenticator *v1alpha1.BasicAuthenticator, k8Client client.Client, basicAuthLabels map[string]string) ([]*appsv1.Deployment, error) {
-	var deploymentList appsv1.DeploymentList
-	if err := k8Client.List(
-		ctx,
-		&deploymentList,
-		client.MatchingLabelsSelector{Selector: labels.SelectorFromSet(basicAuthLabels)},
-		client.InNamespace(basicAuthenticator.Namespace)); err != nil {
-		return nil, err
-	}
-
-	resultDeployments := make([]*appsv1.Deployment, 0)
-	for _, deploy := range deploymentList.Items {
-		resultDeployments = append(resultDeployments, &deploy)
-	}
-	return resultDeployments, nil
-}
 func getTargetConfigmapNames(ctx context.Context, basicAuthenticator *v1alpha1.BasicAuthenticator, k8Client client.Client, basicAuthLabels map[string]string) ([]string, error) {
 	var configMapList v1.ConfigMapList
 	if err := k8Client.List(
@@ -147,30 +136,44 @@ func getTargetSecretName(ctx context.Context, basicAuthenticator *v1alpha1.Basic
 	}
 	return resultSecrets, nil
 }
-func removeInjectedResources(deployments []*appsv1.Deployment, secrets []string, configmap []string) []*appsv1.Deployment {
-	for _, deploy := range deployments {
+func removeInjectedResources(workloads []client.Object, secrets []string, configmap []string) []client.Object {
+	for _, workload := range workloads {
+		podTemplate := getPodTemplate(workload)
+		podSpec := &podTemplate.Spec
+		sidecarContainers := []string{nginxDefaultContainerName}
+		if injectedContainers := podTemplate.Annotations[SidecarContainerAnnotation]; injectedContainers != "" {
+			sidecarContainers = strings.Split(injectedContainers, ",")
+		}
+		injectedVolumes := strings.Split(podTemplate.Annotations[SidecarVolumesAnnotation], ",")
 		containers := make([]v1.Container, 0)
-		for _, container := range deploy.Spec.Template.Spec.Containers {
-			if container.Name != nginxDefaultContainerName {
+		for _, container := range podSpec.Containers {
+			if !existsInList(sidecarContainers, container.Name) {
 				containers = append(containers, container)
 			}
 		}
-		deploy.Spec.Template.Spec.Containers = containers
+		podSpec.Containers = containers
 		volumes := make([]v1.Volume, 0)
-		for _, vol := range deploy.Spec.Template.Spec.Volumes {
-			if !existsInList(secrets, vol.Name) && !existsInList(configmap, vol.Name) {
+		for _, vol := range podSpec.Volumes {
+			if !existsInList(secrets, vol.Name) && !existsInList(configmap, vol.Name) && !existsInList(injectedVolumes, vol.Name) && vol.Name != TLSVolumeName {
 				volumes = append(volumes, vol)
 			}
 		}
-		deploy.Spec.Template.Spec.Volumes = volumes
-		if deploy.Annotations != nil {
-			delete(deploy.Annotations, ExternallyManaged)
+		podSpec.Volumes = volumes
+		delete(podTemplate.Annotations, SidecarContainerAnnotation)
+		delete(podTemplate.Annotations, SidecarVolumesAnnotation)
+		delete(podTemplate.Annotations, ConfigHashAnnotation)
+		workloadAnnotations := workload.GetAnnotations()
+		if workloadAnnotations != nil {
+			delete(workloadAnnotations, ExternallyManaged)
+			workload.SetAnnotations(workloadAnnotations)
 		}
-		if deploy.Labels != nil {
-			delete(deploy.Labels, basicAuthenticatorNameLabel)
+		workloadLabels := workload.GetLabels()
+		if workloadLabels != nil {
+			delete(workloadLabels, basicAuthenticatorNameLabel)
+			workload.SetLabels(workloadLabels)
 		}
 	}
-	return deployments
+	return workloads
 }
 
 func existsInList(strList []string, targetStr string) bool {
@@ -1,28 +1,194 @@
 package basic_authenticator
 
 const (
-	nginxDefaultImageAddress    = "nginx:1.25.3"
-	nginxDefaultContainerName   = "nginx"
-	basicAuthenticatorNameLabel = "basicauthenticator.snappcloud.io/name"
-	basicAuthenticatorFinalizer = "basicauthenticator.snappcloud.io/finalizer"
-	ExternallyManaged           = "basicauthenticator.snappcloud.io/externally.managed"
-	ConfigMountPath             = "/etc/nginx/conf.d"
-	SecretMountDir              = "/etc/secret"
-	SecretMountPath             = "/etc/secret/htpasswd"
-	SecretHtpasswdField         = "htpasswd"
-	//TODO: maybe using better templating?
-	template = `server {
-	listen AUTHENTICATOR_PORT;
-	location / {
+	nginxDefaultImageAddress      = "nginxinc/nginx-unprivileged:1.25.3"
+	nginxDefaultContainerName     = "nginx"
+	exporterDefaultImageAddress   = "nginx/nginx-prometheus-exporter:1.1.0"
+	exporterContainerName         = "nginx-exporter"
+	authServerDefaultImageAddress = "ghcr.io/snapp-incubator/simple-authenticator/auth-server:v0.1.0"
+	authServerContainerName       = "auth-server"
+	basicAuthenticatorNameLabel   = "basicauthenticator.snappcloud.io/name"
+	basicAuthenticatorFinalizer   = "basicauthenticator.snappcloud.io/finalizer"
+	ExternallyManaged             = "basicauthenticator.snappcloud.io/externally.managed"
+	ConfigMountPath               = "/etc/nginx/conf.d"
+	SecretMountDir                = "/etc/secret"
+	SecretMountPath               = "/etc/secret/htpasswd"
+	SecretHtpasswdField           = "htpasswd"
+	SecretPreviousHtpasswdField   = "previous-htpasswd"
+	// SecretPathHtpasswdFieldPrefix prefixes the keys holding the users of path rules restricted to some users
+	SecretPathHtpasswdFieldPrefix = "htpasswd-path-"
+	// AuthServerLocation is the internal location nginx sends auth subrequests to
+	AuthServerLocation = "/.basicauthenticator/auth"
+	// LoginLocation serves the endpoints of the OIDC login, such as the callback registered at the identity provider
+	LoginLocation               = "/.basicauthenticator/login/"
+	TLSVolumeName               = "basicauthenticator-tls"
+	TLSMountDir                 = "/etc/nginx/tls"
+	RotateCredentialsAnnotation = "basicauthenticator.snappcloud.io/rotate-credentials"
+	// LastRotationAnnotation and PreviousCredentialsExpirationAnnotation record a rotation on the credentials secret,
+	// written along with the rotated credentials so a rotation happens once even if recording it in the status fails
+	LastRotationAnnotation                  = "basicauthenticator.snappcloud.io/last-rotation-time"
+	PreviousCredentialsExpirationAnnotation = "basicauthenticator.snappcloud.io/previous-credentials-expiration-time"
+	// HandledRotationAnnotation keeps the RotateCredentialsAnnotation value the credentials secret was rotated for,
+	// until the request is removed from the BasicAuthenticator
+	HandledRotationAnnotation = "basicauthenticator.snappcloud.io/handled-rotation-request"
+	// SidecarContainerAnnotation and SidecarVolumesAnnotation record what was injected into a pod template, as
+	// comma separated names
+	SidecarContainerAnnotation = "basicauthenticator.snappcloud.io/sidecar-container"
+	SidecarVolumesAnnotation   = "basicauthenticator.snappcloud.io/sidecar-volumes"
+	// ConfigHashAnnotation is stamped on authenticator pod templates so they roll when config or credentials change
+	ConfigHashAnnotation = "basicauthenticator.snappcloud.io/config-hash"
+	// InjectedAnnotation records which BasicAuthenticator the pod webhook injected its sidecar into a pod for
+	InjectedAnnotation = "basicauthenticator.snappcloud.io/injected"
+	// ServiceTakeoverLabel marks the application service taken over by a BasicAuthenticator and its backing service
+	ServiceTakeoverLabel = "basicauthenticator.snappcloud.io/taken-over-by"
+	// OriginalServiceAnnotation keeps the selector and ports of a taken over service for the rollback
+	OriginalServiceAnnotation = "basicauthenticator.snappcloud.io/original-service"
+	// IngressBackendProtocolAnnotation makes ingress-nginx connect to an authenticator terminating TLS over HTTPS
+	IngressBackendProtocolAnnotation = "nginx.ingress.kubernetes.io/backend-protocol"
+	// nginxConfigTemplate is rendered with nginxConfig
+	nginxConfigTemplate = `
+{{- define "location" }}
+	location {{ with .Location.Modifier }}{{ . }} {{ end }}"{{ .Location.Path }}" {
+{{- if .Location.HtpasswdPath }}
+{{- if and .Config.AuthServerPort (not .Config.CheckCredentials) (not .Config.RequireCertificate) }}
+		satisfy any;
+{{- end }}
+{{- if not (or .Config.CheckCredentials .Config.CertificateOnly) }}
 		auth_basic	"basic authentication area";
-		auth_basic_user_file "FILE_PATH";
-		proxy_pass http://APP_SERVICE:APP_PORT;
+		auth_basic_user_file "{{ .Location.HtpasswdPath }}";
+{{- end }}
+{{- if .Config.AuthServerPort }}
+		auth_request "{{ authServerPath }}";
+{{- end }}
+{{- range .Config.ClaimHeaders }}
+		auth_request_set ${{ .Variable }} $upstream_http_{{ .UpstreamVariable }};
+{{- end }}
+{{- if .Config.Login }}
+		error_page 401 = @basicauthenticator_login;
+{{- end }}
+{{- else }}
+		auth_basic off;
+{{- end }}
+{{- with .Config }}
+{{- if .ForwardAuth }}
+		try_files /.forwardauth @authenticated;
+{{- else }}
+		proxy_pass http://{{ .Upstream }};
 		proxy_set_header Host $host;
 		proxy_set_header X-Real-IP $remote_addr;
 		proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
 		proxy_set_header X-Forwarded-Proto $scheme;
+{{- range .ClaimHeaders }}
+		proxy_set_header {{ .Name }} {{ if $.Location.HtpasswdPath }}${{ .Variable }}{{ else }}""{{ end }};
+{{- end }}
+{{- with .Proxy }}
+{{- with .ConnectTimeout }}
+		proxy_connect_timeout {{ . }};
+{{- end }}
+{{- with .ReadTimeout }}
+		proxy_read_timeout {{ . }};
+{{- end }}
+{{- with .SendTimeout }}
+		proxy_send_timeout {{ . }};
+{{- end }}
+{{- with .BufferSize }}
+		proxy_buffer_size {{ . }};
+{{- end }}
+{{- with .Buffering }}
+		proxy_buffering {{ . }};
+{{- end }}
+{{- range .RequestHeaders }}
+		proxy_set_header {{ .Name }} "{{ .Value }}";
+{{- end }}
+{{- range .ResponseHeaders }}
+		add_header {{ .Name }} "{{ .Value }}" always;
+{{- end }}
+{{- end }}
+{{- end }}
+{{- with .LocationSnippet }}
+		{{ . }}
+{{- end }}
+{{- end }}
 	}
-}`
+{{- end -}}
+{{- if .MTLS -}}
+map $ssl_client_verify $basicauthenticator_client_certificate {
+	SUCCESS $ssl_client_escaped_cert;
+	default "";
+}
+{{ end -}}
+{{- with .StatusPort -}}
+server {
+	listen 127.0.0.1:{{ . }};
+	location = /stub_status {
+		stub_status;
+	}
+}
+{{ end -}}
+{{- with .TLS }}{{ if .RedirectPort -}}
+server {
+	listen {{ .RedirectPort }};
+	return 301 https://$host{{ .RedirectHost }}$request_uri;
+}
+{{ end }}{{ end -}}
+server {
+{{- with .TLS }}
+	listen {{ $.ListenPort }} ssl;
+	ssl_certificate "{{ .CertificatePath }}";
+	ssl_certificate_key "{{ .KeyPath }}";
+{{- with $.MTLS }}
+	ssl_client_certificate "{{ .CAPath }}";
+	ssl_verify_client {{ .VerifyClient }};
+	ssl_verify_depth {{ .VerifyDepth }};
+{{- end }}
+{{- else }}
+	listen {{ .ListenPort }};
+{{- end }}
+{{- with .Proxy.ClientMaxBodySize }}
+	client_max_body_size {{ . }};
+{{- end }}
+{{- with .ServerSnippet }}
+	{{ . }}
+{{- end }}
+{{- range .Locations }}
+{{- template "location" (location $ .) }}
+{{- end }}
+{{- with .AuthServerPort }}
+	location = "{{ authServerPath }}" {
+		internal;
+		proxy_pass http://127.0.0.1:{{ . }}/auth;
+		proxy_pass_request_body off;
+		proxy_set_header Content-Length "";
+		proxy_set_header X-Original-URI $request_uri;
+{{- with $.MTLS }}
+		proxy_set_header {{ .CertificateHeader }} $basicauthenticator_client_certificate;
+{{- end }}
+	}
+{{- if $.Login }}
+	location @basicauthenticator_login {
+		rewrite ^ {{ loginPath }}start break;
+		proxy_pass http://127.0.0.1:{{ . }};
+		proxy_set_header X-Original-URI $request_uri;
+		proxy_set_header X-Forwarded-Host $http_host;
+		proxy_set_header X-Forwarded-Proto $scheme;
+		proxy_set_header X-Login-Prefix "{{ loginLocation }}";
+	}
+	location ^~ "{{ loginLocation }}" {
+		proxy_pass http://127.0.0.1:{{ . }}{{ loginPath }};
+		proxy_set_header X-Forwarded-Host $http_host;
+		proxy_set_header X-Forwarded-Proto $scheme;
+		proxy_set_header X-Login-Prefix "{{ loginLocation }}";
+	}
+{{- end }}
+{{- end }}
+{{- if .ForwardAuth }}
+	location @authenticated {
+		add_header {{ .ForwardAuthUserHeader }} $remote_user always;
+		return 200;
+	}
+{{- end }}
+}
+`
 	StatusAvailable   = "Available"
 	StatusReconciling = "Reconciling"
 	StatusDeleting    = "Deleting"
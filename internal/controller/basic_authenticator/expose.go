@@ -0,0 +1,304 @@
+package basic_authenticator
+
+import (
+	"context"
+	"fmt"
+	"github.com/opdev/subreconciler"
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// routeGVK and httpRouteGVK are handled as unstructured so OpenShift and Gateway API stay optional dependencies
+	routeGVK     = schema.GroupVersionKind{Group: "route.openshift.io", Version: "v1", Kind: "Route"}
+	httpRouteGVK = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "HTTPRoute"}
+	// routeOptionalFields are only set for some settings, so they are removed once the settings change
+	routeOptionalFields = []string{"host", "path", "tls", "hostnames"}
+)
+
+// ensureExposure creates the Ingress, Route or HTTPRoute selected by the expose section and deletes the
+// ones of the other kinds the BasicAuthenticator owned before
+func (r *BasicAuthenticatorReconciler) ensureExposure(ctx context.Context, req ctrl.Request) (*ctrl.Result, error) {
+	basicAuthenticator := &v1alpha1.BasicAuthenticator{}
+
+	if r, err := r.getLatestBasicAuthenticator(ctx, req, basicAuthenticator); subreconciler.ShouldHaltOrRequeue(r, err) {
+		return subreconciler.RequeueWithError(err)
+	}
+	exposeKind := ""
+	if basicAuthenticator.Spec.Expose != nil {
+		exposeKind = basicAuthenticator.Spec.Expose.Kind
+	}
+
+	var err error
+	if exposeKind == v1alpha1.ExposeKindIngress {
+		err = r.ensureIngress(ctx, basicAuthenticator)
+	} else {
+		err = r.deleteOwned(ctx, basicAuthenticator, &networkingv1.Ingress{})
+	}
+	if err != nil {
+		r.logger.Error(err, "failed to reconcile ingress")
+		return subreconciler.RequeueWithError(err)
+	}
+
+	for _, gvk := range []schema.GroupVersionKind{routeGVK, httpRouteGVK} {
+		if exposeKind == gvk.Kind {
+			err = r.ensureUnstructuredRoute(ctx, basicAuthenticator, gvk)
+		} else {
+			route := &unstructured.Unstructured{}
+			route.SetGroupVersionKind(gvk)
+			err = r.deleteOwned(ctx, basicAuthenticator, route)
+		}
+		if err != nil {
+			r.logger.Error(err, "failed to reconcile route", "kind", gvk.Kind)
+			return subreconciler.RequeueWithError(err)
+		}
+	}
+	return subreconciler.ContinueReconciling()
+}
+
+// deleteOwned deletes the object named after basicAuthenticator if basicAuthenticator controls it.
+// A kind unknown to the cluster has nothing to delete.
+func (r *BasicAuthenticatorReconciler) deleteOwned(ctx context.Context, basicAuthenticator *v1alpha1.BasicAuthenticator, obj client.Object) error {
+	err := r.Get(ctx, types.NamespacedName{Name: basicAuthenticator.Name, Namespace: basicAuthenticator.Namespace}, obj)
+	if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(obj, basicAuthenticator) {
+		return nil
+	}
+	r.logger.Info("deleting exposure", "name", obj.GetName())
+	return client.IgnoreNotFound(r.Delete(ctx, obj))
+}
+
+func createIngress(basicAuthenticator *v1alpha1.BasicAuthenticator) *networkingv1.Ingress {
+	expose := basicAuthenticator.Spec.Expose
+	pathType := networkingv1.PathTypePrefix
+	annotations := make(map[string]string, len(expose.Annotations)+1)
+	if basicAuthenticator.Spec.TLS != nil {
+		// the authenticator only speaks HTTPS, other ingress controllers take their own annotation
+		annotations[IngressBackendProtocolAnnotation] = "HTTPS"
+	}
+	for key, value := range expose.Annotations {
+		annotations[key] = value
+	}
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        basicAuthenticator.Name,
+			Namespace:   basicAuthenticator.Namespace,
+			Labels:      map[string]string{basicAuthenticatorNameLabel: basicAuthenticator.Name},
+			Annotations: annotations,
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: expose.IngressClassName,
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: expose.Host,
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path:     getExposePath(expose),
+									PathType: &pathType,
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: fmt.Sprintf("%s-svc", basicAuthenticator.Name),
+											Port: networkingv1.ServiceBackendPort{
+												Number: int32(basicAuthenticator.Spec.AuthenticatorPort),
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if expose.TLS != nil {
+		ingress.Spec.TLS = []networkingv1.IngressTLS{
+			{
+				Hosts:      []string{expose.Host},
+				SecretName: expose.TLS.SecretName,
+			},
+		}
+	}
+	return ingress
+}
+
+func (r *BasicAuthenticatorReconciler) ensureIngress(ctx context.Context, basicAuthenticator *v1alpha1.BasicAuthenticator) error {
+	newIngress := createIngress(basicAuthenticator)
+	foundIngress := &networkingv1.Ingress{}
+	err := r.Get(ctx, types.NamespacedName{Name: newIngress.Name, Namespace: newIngress.Namespace}, foundIngress)
+	if errors.IsNotFound(err) {
+		if err := ctrl.SetControllerReference(basicAuthenticator, newIngress, r.Scheme); err != nil {
+			return err
+		}
+		return r.Create(ctx, newIngress)
+	} else if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(foundIngress, basicAuthenticator) {
+		return fmt.Errorf("ingress %s exists and is not managed by %s", foundIngress.Name, basicAuthenticator.Name)
+	}
+	annotationsChanged := false
+	for key, value := range newIngress.Annotations {
+		if foundIngress.Annotations[key] != value {
+			annotationsChanged = true
+		}
+	}
+	// the backend protocol set for TLS would break plain HTTP once TLS is turned off
+	if _, ok := newIngress.Annotations[IngressBackendProtocolAnnotation]; !ok && foundIngress.Annotations[IngressBackendProtocolAnnotation] == "HTTPS" {
+		delete(foundIngress.Annotations, IngressBackendProtocolAnnotation)
+		annotationsChanged = true
+	}
+	if !reflect.DeepEqual(newIngress.Spec, foundIngress.Spec) || annotationsChanged {
+		r.logger.Info("updating ingress")
+		foundIngress.Spec = newIngress.Spec
+		if foundIngress.Annotations == nil {
+			foundIngress.Annotations = make(map[string]string)
+		}
+		for key, value := range newIngress.Annotations {
+			foundIngress.Annotations[key] = value
+		}
+		return r.Update(ctx, foundIngress)
+	}
+	return nil
+}
+
+// createUnstructuredRoute builds an OpenShift Route or a Gateway API HTTPRoute, spelling out the fields
+// the API server would default so reconciling an unchanged route is a no-op
+func createUnstructuredRoute(basicAuthenticator *v1alpha1.BasicAuthenticator, gvk schema.GroupVersionKind) *unstructured.Unstructured {
+	expose := basicAuthenticator.Spec.Expose
+	serviceName := fmt.Sprintf("%s-svc", basicAuthenticator.Name)
+
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(gvk)
+	route.SetName(basicAuthenticator.Name)
+	route.SetNamespace(basicAuthenticator.Namespace)
+	route.SetLabels(map[string]string{basicAuthenticatorNameLabel: basicAuthenticator.Name})
+	if len(expose.Annotations) > 0 {
+		route.SetAnnotations(expose.Annotations)
+	}
+
+	if gvk == routeGVK {
+		spec := map[string]interface{}{
+			"to": map[string]interface{}{
+				"kind":   "Service",
+				"name":   serviceName,
+				"weight": int64(100),
+			},
+			"port": map[string]interface{}{
+				"targetPort": int64(basicAuthenticator.Spec.AuthenticatorPort),
+			},
+			"wildcardPolicy": "None",
+		}
+		if expose.Host != "" {
+			spec["host"] = expose.Host
+		}
+		// the router can't see the path of passthrough connections, so those Routes take none
+		if basicAuthenticator.Spec.TLS == nil {
+			spec["path"] = getExposePath(expose)
+		}
+		switch {
+		case basicAuthenticator.Spec.TLS != nil:
+			// the authenticator terminates TLS itself
+			spec["tls"] = map[string]interface{}{
+				"termination":                   "passthrough",
+				"insecureEdgeTerminationPolicy": "Redirect",
+			}
+		case expose.TLS != nil:
+			spec["tls"] = map[string]interface{}{
+				"termination":                   "edge",
+				"insecureEdgeTerminationPolicy": "Redirect",
+			}
+		}
+		route.Object["spec"] = spec
+		return route
+	}
+
+	parentRef := map[string]interface{}{
+		"group": gvk.Group,
+		"kind":  "Gateway",
+	}
+	if gateway := expose.Gateway; gateway != nil {
+		parentRef["name"] = gateway.Name
+		if gateway.Namespace != "" {
+			parentRef["namespace"] = gateway.Namespace
+		}
+		if gateway.SectionName != "" {
+			parentRef["sectionName"] = gateway.SectionName
+		}
+	}
+	spec := map[string]interface{}{
+		"parentRefs": []interface{}{parentRef},
+		"rules": []interface{}{
+			map[string]interface{}{
+				"matches": []interface{}{
+					map[string]interface{}{
+						"path": map[string]interface{}{
+							"type":  "PathPrefix",
+							"value": getExposePath(expose),
+						},
+					},
+				},
+				"backendRefs": []interface{}{
+					map[string]interface{}{
+						"group":  "",
+						"kind":   "Service",
+						"name":   serviceName,
+						"port":   int64(basicAuthenticator.Spec.AuthenticatorPort),
+						"weight": int64(1),
+					},
+				},
+			},
+		},
+	}
+	// a route without hostnames matches every host of the Gateway listener
+	if expose.Host != "" {
+		spec["hostnames"] = []interface{}{expose.Host}
+	}
+	route.Object["spec"] = spec
+	return route
+}
+
+func (r *BasicAuthenticatorReconciler) ensureUnstructuredRoute(ctx context.Context, basicAuthenticator *v1alpha1.BasicAuthenticator, gvk schema.GroupVersionKind) error {
+	newRoute := createUnstructuredRoute(basicAuthenticator, gvk)
+	foundRoute := &unstructured.Unstructured{}
+	foundRoute.SetGroupVersionKind(gvk)
+	err := r.Get(ctx, types.NamespacedName{Name: newRoute.GetName(), Namespace: newRoute.GetNamespace()}, foundRoute)
+	if errors.IsNotFound(err) {
+		if err := ctrl.SetControllerReference(basicAuthenticator, newRoute, r.Scheme); err != nil {
+			return err
+		}
+		return r.Create(ctx, newRoute)
+	} else if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(foundRoute, basicAuthenticator) {
+		return fmt.Errorf("%s %s exists and is not managed by %s", gvk.Kind, foundRoute.GetName(), basicAuthenticator.Name)
+	}
+	if mergeUnstructuredSpec(foundRoute, newRoute, routeOptionalFields...) {
+		r.logger.Info("updating route", "kind", gvk.Kind)
+		return r.Update(ctx, foundRoute)
+	}
+	return nil
+}
+
+func getExposePath(expose *v1alpha1.ExposeConfig) string {
+	if expose.Path == "" {
+		return "/"
+	}
+	return expose.Path
+}
@@ -0,0 +1,193 @@
+package basic_authenticator
+
+import (
+	"context"
+	"fmt"
+	"github.com/opdev/subreconciler"
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	ingressNginxAuthURLAnnotation             = "nginx.ingress.kubernetes.io/auth-url"
+	ingressNginxAuthResponseHeadersAnnotation = "nginx.ingress.kubernetes.io/auth-response-headers"
+	// ForwardAuthUserHeader carries the authenticated username back to the ingress controller
+	ForwardAuthUserHeader = "X-Auth-User"
+)
+
+// middlewareGVK is Traefik's Middleware, handled as unstructured so Traefik stays an optional dependency
+var middlewareGVK = schema.GroupVersionKind{Group: "traefik.io", Version: "v1alpha1", Kind: "Middleware"}
+
+// getForwardAuthURL is the in-cluster address of the authenticator service answering authentication subrequests
+func getForwardAuthURL(basicAuthenticator *v1alpha1.BasicAuthenticator) string {
+	scheme := "http"
+	if basicAuthenticator.Spec.TLS != nil {
+		scheme = "https"
+	}
+	serviceName := fmt.Sprintf("%s-svc", basicAuthenticator.Name)
+	return fmt.Sprintf("%s://%s.%s.svc.cluster.local:%d/", scheme, serviceName, basicAuthenticator.Namespace, basicAuthenticator.Spec.AuthenticatorPort)
+}
+
+// ensureForwardAuth points the listed ingresses and, if requested, a Traefik Middleware at a forwardauth authenticator
+func (r *BasicAuthenticatorReconciler) ensureForwardAuth(ctx context.Context, req ctrl.Request) (*ctrl.Result, error) {
+	basicAuthenticator := &v1alpha1.BasicAuthenticator{}
+
+	if r, err := r.getLatestBasicAuthenticator(ctx, req, basicAuthenticator); subreconciler.ShouldHaltOrRequeue(r, err) {
+		return subreconciler.RequeueWithError(err)
+	}
+	if basicAuthenticator.Spec.Type != "forwardauth" {
+		return subreconciler.ContinueReconciling()
+	}
+	forwardAuth := basicAuthenticator.Spec.ForwardAuth
+	if forwardAuth == nil {
+		forwardAuth = &v1alpha1.ForwardAuthConfig{}
+	}
+
+	if err := r.ensureIngressAnnotations(ctx, basicAuthenticator, forwardAuth.Ingresses); err != nil {
+		r.logger.Error(err, "failed to annotate ingresses")
+		return subreconciler.RequeueWithError(err)
+	}
+	if err := r.ensureTraefikMiddleware(ctx, basicAuthenticator, forwardAuth.TraefikMiddleware); err != nil {
+		r.logger.Error(err, "failed to ensure traefik middleware")
+		return subreconciler.RequeueWithError(err)
+	}
+
+	forwardAuthURL := getForwardAuthURL(basicAuthenticator)
+	err := r.updateStatus(ctx, req, func(status *v1alpha1.BasicAuthenticatorStatus, generation int64) {
+		status.ForwardAuthURL = forwardAuthURL
+	})
+	if err != nil {
+		r.logger.Error(err, "failed to record forward auth url")
+		return subreconciler.RequeueWithError(err)
+	}
+	return subreconciler.ContinueReconciling()
+}
+
+// ensureIngressAnnotations annotates ingressNames for ingress-nginx and removes the annotations from ingresses
+// that are no longer listed. Annotated ingresses are labeled so they can be found again.
+func (r *BasicAuthenticatorReconciler) ensureIngressAnnotations(ctx context.Context, basicAuthenticator *v1alpha1.BasicAuthenticator, ingressNames []string) error {
+	var labeledIngresses networkingv1.IngressList
+	if err := r.List(ctx, &labeledIngresses,
+		client.MatchingLabels{basicAuthenticatorNameLabel: basicAuthenticator.Name},
+		client.InNamespace(basicAuthenticator.Namespace)); err != nil {
+		return err
+	}
+	for idx := range labeledIngresses.Items {
+		ingress := &labeledIngresses.Items[idx]
+		if existsInList(ingressNames, ingress.Name) {
+			continue
+		}
+		removeIngressAnnotations(ingress)
+		if err := r.Update(ctx, ingress); err != nil {
+			return err
+		}
+	}
+
+	forwardAuthURL := getForwardAuthURL(basicAuthenticator)
+	for _, ingressName := range ingressNames {
+		var ingress networkingv1.Ingress
+		err := r.Get(ctx, types.NamespacedName{Name: ingressName, Namespace: basicAuthenticator.Namespace}, &ingress)
+		if errors.IsNotFound(err) {
+			r.logger.Info("ingress not found, skipping", "ingress", ingressName)
+			continue
+		} else if err != nil {
+			return err
+		}
+		if ingress.Annotations[ingressNginxAuthURLAnnotation] == forwardAuthURL &&
+			ingress.Annotations[ingressNginxAuthResponseHeadersAnnotation] == ForwardAuthUserHeader &&
+			ingress.Labels[basicAuthenticatorNameLabel] == basicAuthenticator.Name {
+			continue
+		}
+		if ingress.Annotations == nil {
+			ingress.Annotations = make(map[string]string)
+		}
+		if ingress.Labels == nil {
+			ingress.Labels = make(map[string]string)
+		}
+		ingress.Annotations[ingressNginxAuthURLAnnotation] = forwardAuthURL
+		ingress.Annotations[ingressNginxAuthResponseHeadersAnnotation] = ForwardAuthUserHeader
+		ingress.Labels[basicAuthenticatorNameLabel] = basicAuthenticator.Name
+		if err := r.Update(ctx, &ingress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func removeIngressAnnotations(ingress *networkingv1.Ingress) {
+	delete(ingress.Annotations, ingressNginxAuthURLAnnotation)
+	delete(ingress.Annotations, ingressNginxAuthResponseHeadersAnnotation)
+	delete(ingress.Labels, basicAuthenticatorNameLabel)
+}
+
+func createTraefikMiddleware(basicAuthenticator *v1alpha1.BasicAuthenticator) *unstructured.Unstructured {
+	middleware := &unstructured.Unstructured{}
+	middleware.SetGroupVersionKind(middlewareGVK)
+	middleware.SetName(basicAuthenticator.Name)
+	middleware.SetNamespace(basicAuthenticator.Namespace)
+	middleware.SetLabels(map[string]string{basicAuthenticatorNameLabel: basicAuthenticator.Name})
+	middleware.Object["spec"] = map[string]interface{}{
+		"forwardAuth": map[string]interface{}{
+			"address":             getForwardAuthURL(basicAuthenticator),
+			"authResponseHeaders": []interface{}{ForwardAuthUserHeader},
+		},
+	}
+	return middleware
+}
+
+// ensureTraefikMiddleware creates the Middleware when enabled, and deletes the one it created once disabled
+func (r *BasicAuthenticatorReconciler) ensureTraefikMiddleware(ctx context.Context, basicAuthenticator *v1alpha1.BasicAuthenticator, enabled bool) error {
+	newMiddleware := createTraefikMiddleware(basicAuthenticator)
+	foundMiddleware := &unstructured.Unstructured{}
+	foundMiddleware.SetGroupVersionKind(middlewareGVK)
+	err := r.Get(ctx, types.NamespacedName{Name: newMiddleware.GetName(), Namespace: newMiddleware.GetNamespace()}, foundMiddleware)
+	if !enabled {
+		if err == nil && metav1.IsControlledBy(foundMiddleware, basicAuthenticator) {
+			return r.Delete(ctx, foundMiddleware)
+		}
+		// without Traefik installed the kind is unknown, which is fine when no middleware is wanted
+		return nil
+	}
+	if errors.IsNotFound(err) {
+		if err := ctrl.SetControllerReference(basicAuthenticator, newMiddleware, r.Scheme); err != nil {
+			return err
+		}
+		return r.Create(ctx, newMiddleware)
+	} else if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(foundMiddleware, basicAuthenticator) {
+		return fmt.Errorf("middleware %s exists and is not managed by %s", foundMiddleware.GetName(), basicAuthenticator.Name)
+	}
+	if !equality.Semantic.DeepEqual(foundMiddleware.Object["spec"], newMiddleware.Object["spec"]) {
+		r.logger.Info("updating traefik middleware")
+		foundMiddleware.Object["spec"] = newMiddleware.Object["spec"]
+		return r.Update(ctx, foundMiddleware)
+	}
+	return nil
+}
+
+// removeForwardAuthAnnotations takes a deleted forwardauth authenticator out of the ingresses it was wired into
+func (r *BasicAuthenticatorReconciler) removeForwardAuthAnnotations(ctx context.Context, req ctrl.Request) (*ctrl.Result, error) {
+	basicAuthenticator := &v1alpha1.BasicAuthenticator{}
+
+	if r, err := r.getLatestBasicAuthenticator(ctx, req, basicAuthenticator); subreconciler.ShouldHaltOrRequeue(r, err) {
+		return subreconciler.RequeueWithError(err)
+	}
+	if basicAuthenticator.Spec.Type != "forwardauth" {
+		return subreconciler.ContinueReconciling()
+	}
+	if err := r.ensureIngressAnnotations(ctx, basicAuthenticator, nil); err != nil {
+		r.logger.Error(err, "failed to remove ingress annotations")
+		return subreconciler.RequeueWithError(err)
+	}
+	return subreconciler.ContinueReconciling()
+}
@@ -0,0 +1,127 @@
+package basic_authenticator
+
+import (
+	"fmt"
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	"github.com/snapp-incubator/simple-authenticator/pkg/mtls"
+	corev1 "k8s.io/api/core/v1"
+	"strings"
+)
+
+const (
+	// MTLSVolumeName mounts the CA bundle client certificates are verified against into nginx
+	MTLSVolumeName = "basicauthenticator-mtls-ca"
+	mtlsMountDir   = "/etc/nginx/mtls"
+	mtlsCAFile     = "ca.crt"
+)
+
+// nginxMTLSConfig is the client certificate part of nginxConfig
+type nginxMTLSConfig struct {
+	CAPath string
+	// VerifyClient is on when every location needs a certificate and optional when some are public or
+	// Basic credentials do as well
+	VerifyClient string
+	VerifyDepth  int
+	// CertificateHeader passes the verified certificate to the auth server
+	CertificateHeader string
+}
+
+// getMTLSSatisfy returns how client certificates combine with the Basic credentials, empty without mtls
+func getMTLSSatisfy(basicAuthenticator *v1alpha1.BasicAuthenticator) string {
+	mtlsConfig := basicAuthenticator.Spec.MTLS
+	if mtlsConfig == nil {
+		return ""
+	}
+	if mtlsConfig.Satisfy == "" {
+		return v1alpha1.MTLSSatisfyCertificate
+	}
+	return mtlsConfig.Satisfy
+}
+
+// requiresCertificate reports whether a request needs a client certificate whatever other credentials it carries
+func requiresCertificate(basicAuthenticator *v1alpha1.BasicAuthenticator) bool {
+	satisfy := getMTLSSatisfy(basicAuthenticator)
+	return satisfy == v1alpha1.MTLSSatisfyCertificate || satisfy == v1alpha1.MTLSSatisfyAll
+}
+
+func newNginxMTLSConfig(basicAuthenticator *v1alpha1.BasicAuthenticator, locations []nginxLocation) *nginxMTLSConfig {
+	mtlsConfig := basicAuthenticator.Spec.MTLS
+	if mtlsConfig == nil {
+		return nil
+	}
+	verifyClient := "on"
+	for _, location := range locations {
+		if location.HtpasswdPath == "" {
+			verifyClient = "optional"
+		}
+	}
+	if !requiresCertificate(basicAuthenticator) {
+		verifyClient = "optional"
+	}
+	verifyDepth := mtlsConfig.VerifyDepth
+	if verifyDepth == 0 {
+		verifyDepth = 1
+	}
+	return &nginxMTLSConfig{
+		CAPath:            fmt.Sprintf("%s/%s", mtlsMountDir, mtlsCAFile),
+		VerifyClient:      verifyClient,
+		VerifyDepth:       verifyDepth,
+		CertificateHeader: mtls.CertificateHeader,
+	}
+}
+
+// getMTLSArgs returns the auth server flags checking the certificates nginx verified
+func getMTLSArgs(basicAuthenticator *v1alpha1.BasicAuthenticator) []string {
+	args := []string{"--mtls"}
+	if requiresCertificate(basicAuthenticator) {
+		args = append(args, "--mtls-required")
+	}
+	if allowedSubjects := basicAuthenticator.Spec.MTLS.AllowedSubjects; len(allowedSubjects) > 0 {
+		args = append(args, fmt.Sprintf("--mtls-allowed-subjects=%s", strings.Join(allowedSubjects, ",")))
+	}
+	return args
+}
+
+// getMTLSVolume returns the volume and mount exposing the CA bundle to nginx, from a configmap or a secret
+func getMTLSVolume(basicAuthenticator *v1alpha1.BasicAuthenticator) (*corev1.Volume, *corev1.VolumeMount) {
+	mtlsConfig := basicAuthenticator.Spec.MTLS
+	if mtlsConfig == nil {
+		return nil, nil
+	}
+	caKey := mtlsConfig.CAKey
+	if caKey == "" {
+		caKey = v1alpha1.MTLSDefaultCAKey
+	}
+	items := []corev1.KeyToPath{{Key: caKey, Path: mtlsCAFile}}
+	defaultMode := corev1.ConfigMapVolumeSourceDefaultMode
+	volume := &corev1.Volume{Name: MTLSVolumeName}
+	if mtlsConfig.CASecretRef != "" {
+		volume.Secret = &corev1.SecretVolumeSource{
+			SecretName:  mtlsConfig.CASecretRef,
+			Items:       items,
+			DefaultMode: &defaultMode,
+		}
+	} else {
+		volume.ConfigMap = &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: mtlsConfig.CAConfigMapRef},
+			Items:                items,
+			DefaultMode:          &defaultMode,
+		}
+	}
+	mount := &corev1.VolumeMount{
+		Name:      MTLSVolumeName,
+		MountPath: mtlsMountDir,
+		ReadOnly:  true,
+	}
+	return volume, mount
+}
+
+// addMTLSVolume mounts the CA bundle client certificates are verified against into container
+func addMTLSVolume(basicAuthenticator *v1alpha1.BasicAuthenticator, podSpec *corev1.PodSpec, container *corev1.Container) {
+	volume, mount := getMTLSVolume(basicAuthenticator)
+	if volume == nil {
+		return
+	}
+	podSpec.Volumes = append(podSpec.Volumes, *volume)
+	container.VolumeMounts = append(container.VolumeMounts, *mount)
+}
@@ -0,0 +1,204 @@
+package basic_authenticator
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	"github.com/snapp-incubator/simple-authenticator/pkg/authserver"
+	"github.com/snapp-incubator/simple-authenticator/pkg/nginx"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+var nginxTemplate = template.Must(template.New("nginx.conf").Funcs(template.FuncMap{
+	"location": func(config *nginxConfig, location nginxLocation) nginxLocationContext {
+		return nginxLocationContext{Config: config, Location: location}
+	},
+	"authServerPath": func() string {
+		return AuthServerLocation
+	},
+	"loginLocation": func() string {
+		return LoginLocation
+	},
+	"loginPath": func() string {
+		return authserver.LoginPath
+	},
+}).Parse(nginxConfigTemplate))
+
+// nginxConfig is the data model nginxConfigTemplate is rendered with
+type nginxConfig struct {
+	ListenPort      int
+	Upstream        string
+	Locations       []nginxLocation
+	TLS             *nginxTLSConfig
+	Proxy           nginxProxyConfig
+	ServerSnippet   string
+	LocationSnippet string
+
+	// ForwardAuth answers authentication subrequests with 200 or 401 instead of proxying requests
+	ForwardAuth           bool
+	ForwardAuthUserHeader string
+
+	// StatusPort serves stub_status for the metrics exporter, zero without one
+	StatusPort int
+	// AuthServerPort is where auth subrequests are sent to, zero without an auth server. Either the
+	// subrequest or the htpasswd file authenticates a request unless CheckCredentials is set.
+	AuthServerPort int
+	// CheckCredentials replaces the htpasswd files with the auth server's credential source
+	CheckCredentials bool
+	// Login sends users without valid credentials to the auth server's OIDC login
+	Login bool
+	// MTLS verifies client certificates, whose subjects the auth server checks
+	MTLS *nginxMTLSConfig
+	// RequireCertificate makes the auth server's certificate check a condition on top of the htpasswd files
+	// rather than an alternative
+	RequireCertificate bool
+	// CertificateOnly drops the htpasswd files, a client certificate alone authenticates a request
+	CertificateOnly bool
+	// ClaimHeaders pass the claims of a token the auth server accepted upstream, replacing any the client sent
+	ClaimHeaders []nginxClaimHeader
+}
+
+// nginxClaimHeader is a header the auth server answers with and nginx passes upstream
+type nginxClaimHeader struct {
+	Name string
+	// Variable holds the header's value between the auth subrequest and the proxied request
+	Variable string
+	// UpstreamVariable is the suffix of the $upstream_http_ variable nginx exposes the header as
+	UpstreamVariable string
+}
+
+// nginxLocationContext is what the "location" template is executed with
+type nginxLocationContext struct {
+	Config   *nginxConfig
+	Location nginxLocation
+}
+
+type nginxProxyConfig struct {
+	ConnectTimeout    string
+	ReadTimeout       string
+	SendTimeout       string
+	ClientMaxBodySize string
+	BufferSize        string
+	Buffering         string
+	RequestHeaders    []nginxHeader
+	ResponseHeaders   []nginxHeader
+}
+
+type nginxHeader struct {
+	Name  string
+	Value string
+}
+
+// renderNginxConfig renders the nginx configuration of basicAuthenticator
+func renderNginxConfig(basicAuthenticator *v1alpha1.BasicAuthenticator) (string, error) {
+	config, err := newNginxConfig(basicAuthenticator)
+	if err != nil {
+		return "", err
+	}
+	var rendered bytes.Buffer
+	if err := nginxTemplate.Execute(&rendered, config); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
+func newNginxConfig(basicAuthenticator *v1alpha1.BasicAuthenticator) (*nginxConfig, error) {
+	// snippets are validated by the webhook already, checking them again keeps a bypassed webhook from mattering
+	if err := nginx.ValidateSnippet(basicAuthenticator.Spec.ServerSnippet); err != nil {
+		return nil, fmt.Errorf("invalid serverSnippet: %w", err)
+	}
+	if err := nginx.ValidateSnippet(basicAuthenticator.Spec.LocationSnippet); err != nil {
+		return nil, fmt.Errorf("invalid locationSnippet: %w", err)
+	}
+	proxy, err := newNginxProxyConfig(basicAuthenticator.Spec.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	locations, err := newNginxLocations(basicAuthenticator)
+	if err != nil {
+		return nil, err
+	}
+	return &nginxConfig{
+		ListenPort:            basicAuthenticator.Spec.AuthenticatorPort,
+		Upstream:              fmt.Sprintf("%s:%d", getUpstreamService(basicAuthenticator), basicAuthenticator.Spec.AppPort),
+		Locations:             locations,
+		TLS:                   newNginxTLSConfig(basicAuthenticator),
+		ForwardAuth:           basicAuthenticator.Spec.Type == "forwardauth",
+		ForwardAuthUserHeader: ForwardAuthUserHeader,
+		Proxy:                 proxy,
+		ServerSnippet:         basicAuthenticator.Spec.ServerSnippet,
+		LocationSnippet:       basicAuthenticator.Spec.LocationSnippet,
+		StatusPort:            getNginxStatusPort(basicAuthenticator),
+		AuthServerPort:        getAuthServerPort(basicAuthenticator),
+		CheckCredentials:      basicAuthenticator.Spec.LDAP != nil,
+		Login:                 basicAuthenticator.Spec.OIDC != nil,
+		MTLS:                  newNginxMTLSConfig(basicAuthenticator, locations),
+		RequireCertificate:    requiresCertificate(basicAuthenticator),
+		CertificateOnly:       getMTLSSatisfy(basicAuthenticator) == v1alpha1.MTLSSatisfyCertificate,
+		ClaimHeaders:          newNginxClaimHeaders(basicAuthenticator.Spec.JWT),
+	}, nil
+}
+
+func newNginxProxyConfig(proxy *v1alpha1.ProxyConfig) (nginxProxyConfig, error) {
+	if proxy == nil {
+		return nginxProxyConfig{}, nil
+	}
+	requestHeaders, err := sortedHeaders(proxy.RequestHeaders)
+	if err != nil {
+		return nginxProxyConfig{}, err
+	}
+	responseHeaders, err := sortedHeaders(proxy.ResponseHeaders)
+	if err != nil {
+		return nginxProxyConfig{}, err
+	}
+	buffering := ""
+	if proxy.Buffering != nil {
+		buffering = "off"
+		if *proxy.Buffering {
+			buffering = "on"
+		}
+	}
+	return nginxProxyConfig{
+		ConnectTimeout:    proxy.ConnectTimeout,
+		ReadTimeout:       proxy.ReadTimeout,
+		SendTimeout:       proxy.SendTimeout,
+		ClientMaxBodySize: proxy.ClientMaxBodySize,
+		BufferSize:        proxy.BufferSize,
+		Buffering:         buffering,
+		RequestHeaders:    requestHeaders,
+		ResponseHeaders:   responseHeaders,
+	}, nil
+}
+
+func newNginxClaimHeaders(jwtConfig *v1alpha1.JWTConfig) []nginxClaimHeader {
+	if jwtConfig == nil {
+		return nil
+	}
+	headers := make([]nginxClaimHeader, 0, len(jwtConfig.ClaimHeaders))
+	for idx, claim := range sortedKeys(jwtConfig.ClaimHeaders) {
+		name := jwtConfig.ClaimHeaders[claim]
+		headers = append(headers, nginxClaimHeader{
+			Name:             name,
+			Variable:         fmt.Sprintf("basicauthenticator_claim_%d", idx),
+			UpstreamVariable: strings.ToLower(strings.ReplaceAll(name, "-", "_")),
+		})
+	}
+	return headers
+}
+
+// sortedHeaders orders headers by name so the rendered configuration is stable across reconciles
+func sortedHeaders(headers map[string]string) ([]nginxHeader, error) {
+	result := make([]nginxHeader, 0, len(headers))
+	for name, value := range headers {
+		if err := nginx.ValidateHeader(name, value); err != nil {
+			return nil, err
+		}
+		result = append(result, nginxHeader{Name: name, Value: value})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
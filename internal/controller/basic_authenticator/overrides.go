@@ -0,0 +1,159 @@
+package basic_authenticator
+
+import (
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	"github.com/snapp-incubator/simple-authenticator/internal/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// applyContainerOverrides merges the operator's defaults and then basicAuthenticator's container section
+// into the nginx container
+func applyContainerOverrides(container *corev1.Container, basicAuthenticator *v1alpha1.BasicAuthenticator, customConfig *config.CustomConfig) {
+	if customConfig != nil {
+		webserver := customConfig.WebserverConf
+		mergeResourceList(&container.Resources.Requests, parseResourceList(webserver.Resources.Requests))
+		mergeResourceList(&container.Resources.Limits, parseResourceList(webserver.Resources.Limits))
+		if webserver.Probes {
+			container.LivenessProbe = newTCPProbe(basicAuthenticator.Spec.AuthenticatorPort)
+			container.ReadinessProbe = newTCPProbe(basicAuthenticator.Spec.AuthenticatorPort)
+		}
+	}
+
+	mergeContainerOverrides(container, basicAuthenticator.Spec.Container)
+}
+
+// mergeContainerOverrides merges a container section of a BasicAuthenticator into container
+func mergeContainerOverrides(container *corev1.Container, overrides *v1alpha1.ContainerOverrides) {
+	if overrides == nil {
+		return
+	}
+	if overrides.Resources != nil {
+		mergeResourceList(&container.Resources.Requests, overrides.Resources.Requests)
+		mergeResourceList(&container.Resources.Limits, overrides.Resources.Limits)
+	}
+	if overrides.LivenessProbe != nil {
+		container.LivenessProbe = withProbeDefaults(overrides.LivenessProbe)
+	}
+	if overrides.ReadinessProbe != nil {
+		container.ReadinessProbe = withProbeDefaults(overrides.ReadinessProbe)
+	}
+	if overrides.SecurityContext != nil {
+		container.SecurityContext = overrides.SecurityContext.DeepCopy()
+	}
+	if overrides.ImagePullPolicy != "" {
+		container.ImagePullPolicy = overrides.ImagePullPolicy
+	}
+	for _, env := range overrides.Env {
+		container.Env = append(container.Env, *env.DeepCopy())
+	}
+}
+
+// applyPodTemplateOverrides merges the operator's defaults and then basicAuthenticator's podTemplate section
+// into the pod template of the authenticator deployment
+func applyPodTemplateOverrides(podTemplate *corev1.PodTemplateSpec, basicAuthenticator *v1alpha1.BasicAuthenticator, customConfig *config.CustomConfig) {
+	podSpec := &podTemplate.Spec
+	if customConfig != nil {
+		mergeStringMap(&podSpec.NodeSelector, customConfig.WebserverConf.NodeSelector, false)
+		if customConfig.WebserverConf.PriorityClassName != "" {
+			podSpec.PriorityClassName = customConfig.WebserverConf.PriorityClassName
+		}
+	}
+
+	overrides := basicAuthenticator.Spec.PodTemplate
+	if overrides == nil {
+		return
+	}
+	// the labels the deployment selects its pods by are kept
+	mergeStringMap(&podTemplate.Labels, overrides.Labels, true)
+	mergeStringMap(&podTemplate.Annotations, overrides.Annotations, false)
+	mergeStringMap(&podSpec.NodeSelector, overrides.NodeSelector, false)
+	if overrides.Affinity != nil {
+		podSpec.Affinity = overrides.Affinity.DeepCopy()
+	}
+	for _, toleration := range overrides.Tolerations {
+		podSpec.Tolerations = append(podSpec.Tolerations, *toleration.DeepCopy())
+	}
+	if overrides.SecurityContext != nil {
+		podSpec.SecurityContext = overrides.SecurityContext.DeepCopy()
+	}
+	if overrides.PriorityClassName != "" {
+		podSpec.PriorityClassName = overrides.PriorityClassName
+	}
+	if overrides.ServiceAccountName != "" {
+		podSpec.ServiceAccountName = overrides.ServiceAccountName
+	}
+	podSpec.ImagePullSecrets = append(podSpec.ImagePullSecrets, overrides.ImagePullSecrets...)
+}
+
+// mergeStringMap copies source into target, leaving target's existing keys alone if keepExisting is set
+func mergeStringMap(target *map[string]string, source map[string]string, keepExisting bool) {
+	if len(source) == 0 {
+		return
+	}
+	if *target == nil {
+		*target = make(map[string]string)
+	}
+	for key, value := range source {
+		if _, exists := (*target)[key]; exists && keepExisting {
+			continue
+		}
+		(*target)[key] = value
+	}
+}
+
+func mergeResourceList(target *corev1.ResourceList, source corev1.ResourceList) {
+	if len(source) == 0 {
+		return
+	}
+	if *target == nil {
+		*target = corev1.ResourceList{}
+	}
+	for name, quantity := range source {
+		(*target)[name] = quantity.DeepCopy()
+	}
+}
+
+// parseResourceList converts configured quantities, which InitConfig already validated
+func parseResourceList(quantities map[string]string) corev1.ResourceList {
+	resources := corev1.ResourceList{}
+	for name, quantity := range quantities {
+		if parsed, err := resource.ParseQuantity(quantity); err == nil {
+			resources[corev1.ResourceName(name)] = parsed
+		}
+	}
+	return resources
+}
+
+func newTCPProbe(port int) *corev1.Probe {
+	return withProbeDefaults(&corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			TCPSocket: &corev1.TCPSocketAction{
+				Port: intstr.FromInt(port),
+			},
+		},
+	})
+}
+
+// withProbeDefaults returns a copy of probe with the API server's defaults filled in, so injected sidecars
+// compare equal to their desired state
+func withProbeDefaults(probe *corev1.Probe) *corev1.Probe {
+	defaulted := probe.DeepCopy()
+	if defaulted.TimeoutSeconds == 0 {
+		defaulted.TimeoutSeconds = 1
+	}
+	if defaulted.PeriodSeconds == 0 {
+		defaulted.PeriodSeconds = 10
+	}
+	if defaulted.SuccessThreshold == 0 {
+		defaulted.SuccessThreshold = 1
+	}
+	if defaulted.FailureThreshold == 0 {
+		defaulted.FailureThreshold = 3
+	}
+	if defaulted.HTTPGet != nil && defaulted.HTTPGet.Scheme == "" {
+		defaulted.HTTPGet.Scheme = corev1.URISchemeHTTP
+	}
+	return defaulted
+}
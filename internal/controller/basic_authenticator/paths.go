@@ -0,0 +1,117 @@
+package basic_authenticator
+
+import (
+	"fmt"
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	"github.com/snapp-incubator/simple-authenticator/pkg/htpasswd"
+	"github.com/snapp-incubator/simple-authenticator/pkg/nginx"
+	corev1 "k8s.io/api/core/v1"
+	"strings"
+)
+
+// nginxLocation is a location block of the rendered configuration
+type nginxLocation struct {
+	Modifier string
+	Path     string
+	// HtpasswdPath is the user file checked for the location, empty disables authentication
+	HtpasswdPath string
+}
+
+// newNginxLocations returns the default location requiring any valid user followed by a location per path rule.
+// A Prefix rule on "/" replaces the default location since nginx rejects duplicate locations.
+func newNginxLocations(basicAuthenticator *v1alpha1.BasicAuthenticator) ([]nginxLocation, error) {
+	locations := []nginxLocation{{Modifier: nginx.PrefixModifier, Path: "/", HtpasswdPath: SecretMountPath}}
+	definedPaths := make(map[string]bool)
+	for idx, rule := range basicAuthenticator.Spec.Paths {
+		modifier, err := getPathModifier(rule.MatchType)
+		if err != nil {
+			return nil, err
+		}
+		if err := nginx.ValidateLocationPath(modifier, rule.Path); err != nil {
+			return nil, fmt.Errorf("invalid paths[%d]: %w", idx, err)
+		}
+		if definedPaths[modifier+rule.Path] {
+			return nil, fmt.Errorf("invalid paths[%d]: path %s is defined more than once", idx, rule.Path)
+		}
+		definedPaths[modifier+rule.Path] = true
+
+		location := nginxLocation{Modifier: modifier, Path: rule.Path}
+		switch rule.Auth {
+		case v1alpha1.PathAuthBypass:
+		case v1alpha1.PathAuthUsers:
+			location.HtpasswdPath = fmt.Sprintf("%s/%s", SecretMountDir, getPathHtpasswdField(idx))
+		default:
+			location.HtpasswdPath = SecretMountPath
+		}
+		if location.Modifier == locations[0].Modifier && location.Path == locations[0].Path {
+			locations[0] = location
+			continue
+		}
+		locations = append(locations, location)
+	}
+	return locations, nil
+}
+
+func getPathModifier(matchType string) (string, error) {
+	switch matchType {
+	case "", v1alpha1.PathMatchPrefix:
+		return nginx.PrefixModifier, nil
+	case v1alpha1.PathMatchExact:
+		return nginx.ExactModifier, nil
+	case v1alpha1.PathMatchRegex:
+		return nginx.RegexModifier, nil
+	default:
+		return "", fmt.Errorf("unsupported path match type %q", matchType)
+	}
+}
+
+// getPathHtpasswdField is the secret key holding the users allowed on the path rule at idx
+func getPathHtpasswdField(idx int) string {
+	return fmt.Sprintf("%s%d", SecretPathHtpasswdFieldPrefix, idx)
+}
+
+// updatePathHtpasswdFields writes a restricted copy of the htpasswd field for every path rule with
+// Auth Users, and drops the ones left over by rules that no longer exist
+func updatePathHtpasswdFields(secret *corev1.Secret, basicAuthenticator *v1alpha1.BasicAuthenticator) error {
+	for key := range secret.Data {
+		if strings.HasPrefix(key, SecretPathHtpasswdFieldPrefix) {
+			delete(secret.Data, key)
+		}
+	}
+	htpasswdFile := parseHtpasswd(secret.Data[SecretHtpasswdField])
+	for idx, rule := range basicAuthenticator.Spec.Paths {
+		if rule.Auth != v1alpha1.PathAuthUsers {
+			continue
+		}
+		pathFile := htpasswd.NewFile()
+		for _, username := range rule.Users {
+			entry, ok := htpasswdFile.Get(username)
+			if !ok {
+				continue
+			}
+			if err := pathFile.Set(entry.Username, entry.Hash); err != nil {
+				return err
+			}
+		}
+		// an empty file denies every request, which is what a rule without known users should do
+		secret.Data[getPathHtpasswdField(idx)] = append([]byte{}, pathFile.Bytes()...)
+	}
+	return nil
+}
+
+// getSecretVolumeItems lists the secret keys the authenticator needs, leaving plain text credentials unmounted
+func getSecretVolumeItems(basicAuthenticator *v1alpha1.BasicAuthenticator) []corev1.KeyToPath {
+	items := []corev1.KeyToPath{
+		{
+			Key:  SecretHtpasswdField,
+			Path: SecretHtpasswdField,
+		},
+	}
+	for idx, rule := range basicAuthenticator.Spec.Paths {
+		if rule.Auth == v1alpha1.PathAuthUsers {
+			field := getPathHtpasswdField(idx)
+			items = append(items, corev1.KeyToPath{Key: field, Path: field})
+		}
+	}
+	return items
+}
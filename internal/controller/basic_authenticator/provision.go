@@ -8,11 +8,10 @@ import (
 	appv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
-	"math"
 	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
@@ -22,10 +21,18 @@ func (r *BasicAuthenticatorReconciler) Provision(ctx context.Context, req ctrl.R
 	subProvisioner := []subreconciler.FnWithRequest{
 		r.setReconcilingStatus,
 		r.addCleanupFinalizer,
-		r.ensureSecret,
-		r.ensureConfigmap,
-		r.ensureDeployment,
-		r.ensureService,
+		r.withCondition(v1alpha1.ConditionCredentialsValid, r.ensureSecret, r.ensureAPIKeys),
+		r.withCondition(v1alpha1.ConditionConfigRendered, r.ensureCertificate, r.ensureConfigmap),
+		r.withCondition(v1alpha1.ConditionWorkloadInjected,
+			r.ensureBackingService,
+			r.ensureDeployment,
+			r.ensureHorizontalPodAutoscaler,
+			r.ensurePodDisruptionBudget,
+			r.ensureService,
+			r.takeOverAppService,
+			r.ensureExposure,
+			r.ensureForwardAuth,
+		),
 		r.setAvailableStatus,
 	}
 	for _, provisioner := range subProvisioner {
@@ -35,6 +42,9 @@ func (r *BasicAuthenticatorReconciler) Provision(ctx context.Context, req ctrl.R
 		}
 	}
 
+	if r.requeueDelay > 0 {
+		return subreconciler.Evaluate(subreconciler.RequeueWithDelay(r.requeueDelay))
+	}
 	return subreconciler.Evaluate(subreconciler.DoNotRequeue())
 }
 func (r *BasicAuthenticatorReconciler) setReconcilingStatus(ctx context.Context, req ctrl.Request) (*ctrl.Result, error) {
@@ -43,9 +53,17 @@ func (r *BasicAuthenticatorReconciler) setReconcilingStatus(ctx context.Context,
 	if r, err := r.getLatestBasicAuthenticator(ctx, req, basicAuthenticator); subreconciler.ShouldHaltOrRequeue(r, err) {
 		return subreconciler.RequeueWithError(err)
 	}
+	r.workloadReady = false
 
-	basicAuthenticator.Status.State = StatusReconciling
-	if err := r.Update(ctx, basicAuthenticator); err != nil {
+	// only a new generation is worth flipping readiness for, otherwise every reconcile would toggle it
+	if basicAuthenticator.Status.ObservedGeneration == basicAuthenticator.Generation {
+		return subreconciler.ContinueReconciling()
+	}
+	err := r.updateStatus(ctx, req, func(status *v1alpha1.BasicAuthenticatorStatus, generation int64) {
+		status.State = StatusReconciling
+		setCondition(status, v1alpha1.ConditionReady, metav1.ConditionFalse, v1alpha1.ReasonReconciling, "reconciling new generation", generation)
+	})
+	if err != nil {
 		r.logger.Error(err, "failed to update status")
 		return subreconciler.Requeue()
 	}
@@ -88,6 +106,9 @@ func (r *BasicAuthenticatorReconciler) ensureSecret(ctx context.Context, req ctr
 	if r, err := r.getLatestBasicAuthenticator(ctx, req, basicAuthenticator); subreconciler.ShouldHaltOrRequeue(r, err) {
 		return subreconciler.RequeueWithError(err)
 	}
+	if len(basicAuthenticator.Spec.CredentialsSecretRefs) > 0 {
+		return r.ensureMergedSecret(ctx, basicAuthenticator)
+	}
 	r.credentialName = basicAuthenticator.Spec.CredentialsSecretRef
 	var credentialSecret corev1.Secret
 	if r.credentialName == "" {
@@ -97,11 +118,16 @@ func (r *BasicAuthenticatorReconciler) ensureSecret(ctx context.Context, req ctr
 			r.logger.Error(err, "failed to create credentials")
 			return subreconciler.RequeueWithError(err)
 		}
-		err = updateHtpasswdField(newSecret)
+		err = updateHtpasswdField(newSecret, getHashOptions(basicAuthenticator, r.CustomConfig))
 		if err != nil {
 			r.logger.Error(err, "failed to update secret to include htpasswd field")
 			return subreconciler.RequeueWithError(err)
 		}
+		err = updatePathHtpasswdFields(newSecret, basicAuthenticator)
+		if err != nil {
+			r.logger.Error(err, "failed to update secret to include path htpasswd fields")
+			return subreconciler.RequeueWithError(err)
+		}
 		err = r.Get(ctx, types.NamespacedName{Name: newSecret.Name, Namespace: newSecret.Namespace}, &credentialSecret)
 		if errors.IsNotFound(err) {
 			if err := ctrl.SetControllerReference(basicAuthenticator, newSecret, r.Scheme); err != nil {
@@ -134,21 +160,120 @@ func (r *BasicAuthenticatorReconciler) ensureSecret(ctx context.Context, req ctr
 			r.logger.Error(err, "failed to fetch secret")
 			return subreconciler.RequeueWithError(err)
 		}
-		err = updateHtpasswdField(&credentialSecret)
+		var rotationStatus func(*v1alpha1.BasicAuthenticatorStatus, int64)
+		// only credentials generated by the controller are rotated
+		if metav1.IsControlledBy(&credentialSecret, basicAuthenticator) {
+			rotationStatus, err = r.rotateCredentials(basicAuthenticator, &credentialSecret)
+			if err != nil {
+				r.logger.Error(err, "failed to rotate credentials")
+				return subreconciler.RequeueWithError(err)
+			}
+		}
+		err = updateHtpasswdField(&credentialSecret, getHashOptions(basicAuthenticator, r.CustomConfig))
 		if err != nil {
 			r.logger.Error(err, "failed to update secret to include htpasswd field")
 			return subreconciler.RequeueWithError(err)
 		}
+		err = updatePathHtpasswdFields(&credentialSecret, basicAuthenticator)
+		if err != nil {
+			r.logger.Error(err, "failed to update secret to include path htpasswd fields")
+			return subreconciler.RequeueWithError(err)
+		}
 		err = r.Update(ctx, &credentialSecret)
 		if err != nil {
 			r.logger.Error(err, "failed to update secret")
 			return subreconciler.RequeueWithError(err)
 		}
 		r.credentialName = credentialSecret.Name
+		if rotationStatus != nil {
+			if err := r.updateStatus(ctx, req, rotationStatus); err != nil {
+				r.logger.Error(err, "failed to record credentials rotation")
+				return subreconciler.RequeueWithError(err)
+			}
+		}
+		if _, ok := basicAuthenticator.Annotations[RotateCredentialsAnnotation]; ok {
+			if err := r.Get(ctx, req.NamespacedName, basicAuthenticator); err != nil {
+				r.logger.Error(err, "failed to fetch basic authenticator")
+				return subreconciler.RequeueWithError(err)
+			}
+			delete(basicAuthenticator.Annotations, RotateCredentialsAnnotation)
+			if err := r.Update(ctx, basicAuthenticator); err != nil {
+				r.logger.Error(err, "failed to remove rotation annotation")
+				return subreconciler.RequeueWithError(err)
+			}
+		}
 	}
 	return subreconciler.ContinueReconciling()
 }
 
+// ensureMergedSecret merges every referenced credential secret into a single htpasswd secret owned by basicAuthenticator
+func (r *BasicAuthenticatorReconciler) ensureMergedSecret(ctx context.Context, basicAuthenticator *v1alpha1.BasicAuthenticator) (*ctrl.Result, error) {
+	credentials := make([]*corev1.Secret, 0)
+	for _, secretName := range getCredentialsSecretRefs(basicAuthenticator) {
+		var credentialSecret corev1.Secret
+		err := r.Get(ctx, types.NamespacedName{Name: secretName, Namespace: basicAuthenticator.Namespace}, &credentialSecret)
+		if errors.IsNotFound(err) {
+			// a deleted secret revokes its user
+			r.logger.Info("credentials secret not found, skipping", "secret", secretName)
+			continue
+		} else if err != nil {
+			r.logger.Error(err, "failed to fetch credentials secret", "secret", secretName)
+			return subreconciler.RequeueWithError(err)
+		}
+		credentials = append(credentials, &credentialSecret)
+	}
+
+	mergedSecret := createMergedCredentials(basicAuthenticator)
+	var foundSecret corev1.Secret
+	err := r.Get(ctx, types.NamespacedName{Name: mergedSecret.Name, Namespace: mergedSecret.Namespace}, &foundSecret)
+	if errors.IsNotFound(err) {
+		htpasswdField, err := mergeHtpasswd(credentials, nil, getHashOptions(basicAuthenticator, r.CustomConfig))
+		if err != nil {
+			r.logger.Error(err, "failed to merge credentials")
+			return subreconciler.RequeueWithError(err)
+		}
+		mergedSecret.Data[SecretHtpasswdField] = htpasswdField
+		if err := updatePathHtpasswdFields(mergedSecret, basicAuthenticator); err != nil {
+			r.logger.Error(err, "failed to update secret to include path htpasswd fields")
+			return subreconciler.RequeueWithError(err)
+		}
+		if err := ctrl.SetControllerReference(basicAuthenticator, mergedSecret, r.Scheme); err != nil {
+			r.logger.Error(err, "failed to set secret owner")
+			return subreconciler.RequeueWithError(err)
+		}
+		err = r.Create(ctx, mergedSecret)
+		if err != nil {
+			r.logger.Error(err, "failed to create merged secret")
+			return subreconciler.RequeueWithError(err)
+		}
+	} else if err != nil {
+		r.logger.Error(err, "failed to fetch merged secret")
+		return subreconciler.RequeueWithError(err)
+	} else {
+		htpasswdField, err := mergeHtpasswd(credentials, foundSecret.Data[SecretHtpasswdField], getHashOptions(basicAuthenticator, r.CustomConfig))
+		if err != nil {
+			r.logger.Error(err, "failed to merge credentials")
+			return subreconciler.RequeueWithError(err)
+		}
+		mergedSecret.Data[SecretHtpasswdField] = htpasswdField
+		if err := updatePathHtpasswdFields(mergedSecret, basicAuthenticator); err != nil {
+			r.logger.Error(err, "failed to update secret to include path htpasswd fields")
+			return subreconciler.RequeueWithError(err)
+		}
+		if !reflect.DeepEqual(mergedSecret.Data, foundSecret.Data) {
+			r.logger.Info("updating merged secret")
+			foundSecret.Data = mergedSecret.Data
+			err = r.Update(ctx, &foundSecret)
+			if err != nil {
+				r.logger.Error(err, "failed to update merged secret")
+				return subreconciler.RequeueWithError(err)
+			}
+		}
+	}
+	r.credentialName = mergedSecret.Name
+	return subreconciler.ContinueReconciling()
+}
+
 func (r *BasicAuthenticatorReconciler) ensureConfigmap(ctx context.Context, req ctrl.Request) (*ctrl.Result, error) {
 	basicAuthenticator := &v1alpha1.BasicAuthenticator{}
 
@@ -156,9 +281,13 @@ func (r *BasicAuthenticatorReconciler) ensureConfigmap(ctx context.Context, req
 		return subreconciler.RequeueWithError(err)
 	}
 
-	authenticatorConfig := createNginxConfigmap(basicAuthenticator)
+	authenticatorConfig, err := createNginxConfigmap(basicAuthenticator)
+	if err != nil {
+		r.logger.Error(err, "failed to create nginx configmap")
+		return subreconciler.RequeueWithError(err)
+	}
 	var foundConfigmap corev1.ConfigMap
-	err := r.Get(ctx, types.NamespacedName{Name: authenticatorConfig.Name, Namespace: basicAuthenticator.Namespace}, &foundConfigmap)
+	err = r.Get(ctx, types.NamespacedName{Name: authenticatorConfig.Name, Namespace: basicAuthenticator.Namespace}, &foundConfigmap)
 	if errors.IsNotFound(err) {
 		if err := ctrl.SetControllerReference(basicAuthenticator, authenticatorConfig, r.Scheme); err != nil {
 			r.logger.Error(err, "failed to set configmap owner")
@@ -205,6 +334,12 @@ func (r *BasicAuthenticatorReconciler) ensureDeployment(ctx context.Context, req
 	if r.credentialName == "" {
 		return subreconciler.RequeueWithError(defaultError.New("secret's name not set. failed to ensure deployment"))
 	}
+	configHash, err := r.getConfigHash(ctx, basicAuthenticator)
+	if err != nil {
+		r.logger.Error(err, "failed to hash authenticator config")
+		return subreconciler.RequeueWithError(err)
+	}
+	r.configHash = configHash
 	//Deciding to create sidecar injection or create deployment
 	isSidecar := basicAuthenticator.Spec.Type == "sidecar"
 	if isSidecar {
@@ -260,8 +395,19 @@ func (r *BasicAuthenticatorReconciler) setAvailableStatus(ctx context.Context, r
 		return subreconciler.RequeueWithError(err)
 	}
 
-	basicAuthenticator.Status.State = StatusAvailable
-	if err := r.Update(ctx, basicAuthenticator); err != nil {
+	err := r.updateStatus(ctx, req, func(status *v1alpha1.BasicAuthenticatorStatus, generation int64) {
+		status.ObservedGeneration = generation
+		status.Reason = ""
+		setCondition(status, v1alpha1.ConditionDegraded, metav1.ConditionFalse, v1alpha1.ReasonAvailable, "", generation)
+		if r.workloadReady {
+			status.State = StatusAvailable
+			setCondition(status, v1alpha1.ConditionReady, metav1.ConditionTrue, v1alpha1.ReasonAvailable, "", generation)
+		} else {
+			status.State = StatusReconciling
+			setCondition(status, v1alpha1.ConditionReady, metav1.ConditionFalse, v1alpha1.ReasonWaitingForReplicas, "waiting for authenticator replicas to become ready", generation)
+		}
+	})
+	if err != nil {
 		r.logger.Error(err, "failed to update status")
 		return subreconciler.Requeue()
 	}
@@ -271,6 +417,7 @@ func (r *BasicAuthenticatorReconciler) setAvailableStatus(ctx context.Context, r
 func (r *BasicAuthenticatorReconciler) createDeploymentAuthenticator(ctx context.Context, req ctrl.Request, basicAuthenticator *v1alpha1.BasicAuthenticator, authenticatorConfigName, secretName string) (*ctrl.Result, error) {
 
 	newDeployment := createNginxDeployment(basicAuthenticator, authenticatorConfigName, secretName, r.CustomConfig)
+	setConfigHash(&newDeployment.Spec.Template.ObjectMeta, r.configHash)
 	foundDeployment := &appv1.Deployment{}
 	err := r.Get(ctx, types.NamespacedName{Name: newDeployment.Name, Namespace: basicAuthenticator.Namespace}, foundDeployment)
 	if errors.IsNotFound(err) {
@@ -286,6 +433,9 @@ func (r *BasicAuthenticatorReconciler) createDeploymentAuthenticator(ctx context
 			}
 			newDeployment.Spec.Replicas = &replica
 		}
+		if hasAutoscaler(basicAuthenticator) {
+			newDeployment.Spec.Replicas = createHorizontalPodAutoscaler(basicAuthenticator).Spec.MinReplicas
+		}
 		//create deployment
 		err := r.Create(ctx, newDeployment)
 		if err != nil {
@@ -308,12 +458,20 @@ func (r *BasicAuthenticatorReconciler) createDeploymentAuthenticator(ctx context
 			}
 			targetReplica = &replica
 		}
+		if hasAutoscaler(basicAuthenticator) {
+			// the HorizontalPodAutoscaler owns the replica count
+			targetReplica = foundDeployment.Spec.Replicas
+		}
 
+		// without a fresh hash the current one is kept rather than rolling the pods for nothing
+		if r.configHash == "" {
+			setConfigHash(&newDeployment.Spec.Template.ObjectMeta, foundDeployment.Spec.Template.Annotations[ConfigHashAnnotation])
+		}
+		newDeployment.Spec.Replicas = targetReplica
 		if !reflect.DeepEqual(newDeployment.Spec, foundDeployment.Spec) {
 			r.logger.Info("updating deployment")
 
 			foundDeployment.Spec = newDeployment.Spec
-			foundDeployment.Spec.Replicas = targetReplica
 			err = r.Update(ctx, foundDeployment)
 			if err != nil {
 				r.logger.Error(err, "failed to update deployment")
@@ -321,62 +479,52 @@ func (r *BasicAuthenticatorReconciler) createDeploymentAuthenticator(ctx context
 			}
 		}
 		r.logger.Info("updating ready replicas")
-		basicAuthenticator.Status.ReadyReplicas = int(foundDeployment.Status.ReadyReplicas)
-		err = r.Status().Update(ctx, basicAuthenticator)
+		err = r.updateStatus(ctx, req, func(status *v1alpha1.BasicAuthenticatorStatus, generation int64) {
+			status.ReadyReplicas = int(foundDeployment.Status.ReadyReplicas)
+		})
 		if err != nil {
 			r.logger.Error(err, "failed to update basic authenticator status")
 			return subreconciler.RequeueWithError(err)
 		}
+		r.deploymentLabel = foundDeployment.Spec.Selector
+		r.workloadReady = isDeploymentReady(foundDeployment)
 	}
 	return subreconciler.ContinueReconciling()
 }
 
 func (r *BasicAuthenticatorReconciler) createSidecarAuthenticator(ctx context.Context, req ctrl.Request, basicAuthenticator *v1alpha1.BasicAuthenticator, authenticatorConfigName, secretName string) (*ctrl.Result, error) {
-	deploymentsToUpdate, err := injector(ctx, basicAuthenticator, authenticatorConfigName, secretName, r.CustomConfig, r.Client)
+	if basicAuthenticator.Spec.InjectionMode == v1alpha1.InjectionModePodWebhook {
+		// the pod webhook injects the sidecar as pods are created and stamps them with the configuration hash in
+		// the status, workloads are left alone and pods injected with an outdated configuration are evicted instead
+		if r.configHash != "" && basicAuthenticator.Status.ConfigHash != r.configHash {
+			err := r.updateStatus(ctx, req, func(status *v1alpha1.BasicAuthenticatorStatus, generation int64) {
+				status.ConfigHash = r.configHash
+			})
+			if err != nil {
+				r.logger.Error(err, "failed to update basic authenticator status")
+				return subreconciler.RequeueWithError(err)
+			}
+		}
+		rolled, err := r.rollInjectedPods(ctx, basicAuthenticator)
+		if err != nil {
+			r.logger.Error(err, "failed to roll injected pods")
+			return subreconciler.RequeueWithError(err)
+		}
+		r.workloadReady = rolled
+		return subreconciler.ContinueReconciling()
+	}
+	workloadsToUpdate, err := injector(ctx, basicAuthenticator, authenticatorConfigName, secretName, r.configHash, r.CustomConfig, r.Client)
 	if err != nil {
-		r.logger.Error(err, "failed to inject into deployments")
+		r.logger.Error(err, "failed to inject into workloads")
 		return subreconciler.RequeueWithError(err)
 	}
-	for _, deploy := range deploymentsToUpdate {
-		err := r.Update(ctx, deploy)
+	for _, workload := range workloadsToUpdate {
+		err := r.Update(ctx, workload)
 		if err != nil {
-			r.logger.Error(err, "failed to update injected deployments")
+			r.logger.Error(err, "failed to update injected workloads", "workload", workload.GetName())
 			return subreconciler.RequeueWithError(err)
 		}
 	}
+	r.workloadReady = true
 	return subreconciler.ContinueReconciling()
 }
-
-func (r *BasicAuthenticatorReconciler) acquireTargetReplica(ctx context.Context, basicAuthenticator *v1alpha1.BasicAuthenticator) (int32, error) {
-	var targetService corev1.Service
-	// service should be in same ns with basic auth
-	if err := r.Get(ctx, types.NamespacedName{Name: basicAuthenticator.Spec.AppService, Namespace: basicAuthenticator.ObjectMeta.Namespace}, &targetService); err != nil {
-		return -1, err
-	}
-	labelSelector := targetService.Spec.Selector
-
-	deployments := &appv1.DeploymentList{}
-	if err := r.List(ctx, deployments, client.MatchingLabels(labelSelector)); err != nil {
-		return -1, err
-	}
-
-	if len(deployments.Items) == 0 {
-		return -1, defaultError.New("no deployment is selected by appService")
-	}
-
-	targetDeploy := deployments.Items[0] //we expect it to be single deployment
-	if targetDeploy.ObjectMeta.Annotations == nil {
-		targetDeploy.ObjectMeta.Annotations = make(map[string]string)
-	}
-
-	targetDeploy.ObjectMeta.Annotations[ExternallyManaged] = basicAuthenticator.Name
-
-	err := r.Update(ctx, &targetDeploy)
-	if err != nil {
-		return -1, err
-	}
-	replicas := deployments.Items[0].Spec.Replicas
-	targetReplica := math.Floor(float64((*replicas + 1) / 2))
-
-	return int32(targetReplica), nil
-}
@@ -0,0 +1,211 @@
+package basic_authenticator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strings"
+	"time"
+)
+
+// podRollDelay is how long to wait for an evicted pod to be replaced before evicting the next one
+const podRollDelay = 10 * time.Second
+
+// getConfigHash hashes everything the authenticator pods read from the configmap, the credentials secret and the
+// other mounted secrets, such as the TLS certificate and the auth server's. Stamped on a pod template it rolls the
+// authenticator pods as soon as any of them changes, instead of waiting for the kubelet to sync the mounted files
+// and for nginx to be restarted.
+func (r *BasicAuthenticatorReconciler) getConfigHash(ctx context.Context, basicAuthenticator *v1alpha1.BasicAuthenticator) (string, error) {
+	var configMap corev1.ConfigMap
+	var secret corev1.Secret
+	err := r.Get(ctx, types.NamespacedName{Name: r.configMapName, Namespace: basicAuthenticator.Namespace}, &configMap)
+	if err == nil {
+		err = r.Get(ctx, types.NamespacedName{Name: r.credentialName, Namespace: basicAuthenticator.Namespace}, &secret)
+	}
+	if errors.IsNotFound(err) {
+		// just created and not cached yet, the resulting event reconciles again
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	configMapData := make(map[string][]byte, len(configMap.Data))
+	for key, value := range configMap.Data {
+		configMapData[key] = []byte(value)
+	}
+	writeHashData(hash, configMapData)
+	// plain text credentials are not mounted, only the htpasswd files built from them
+	htpasswdData := make(map[string][]byte)
+	for key, value := range secret.Data {
+		if key == SecretHtpasswdField || strings.HasPrefix(key, SecretPathHtpasswdFieldPrefix) {
+			htpasswdData[key] = value
+		}
+	}
+	writeHashData(hash, htpasswdData)
+	for _, secretName := range getMountedSecretNames(basicAuthenticator) {
+		var mountedSecret corev1.Secret
+		err := r.Get(ctx, types.NamespacedName{Name: secretName, Namespace: basicAuthenticator.Namespace}, &mountedSecret)
+		if errors.IsNotFound(err) {
+			// the pods can't start without it, its creation reconciles and rolls them
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		hash.Write([]byte(secretName))
+		writeHashData(hash, mountedSecret.Data)
+	}
+	for _, configMapName := range getMountedConfigMapNames(basicAuthenticator) {
+		var mountedConfigMap corev1.ConfigMap
+		err := r.Get(ctx, types.NamespacedName{Name: configMapName, Namespace: basicAuthenticator.Namespace}, &mountedConfigMap)
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		mountedData := make(map[string][]byte, len(mountedConfigMap.Data))
+		for key, value := range mountedConfigMap.Data {
+			mountedData[key] = []byte(value)
+		}
+		hash.Write([]byte(configMapName))
+		writeHashData(hash, mountedData)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// getMountedSecretNames returns the secrets mounted into the authenticator pods besides the credentials secret
+func getMountedSecretNames(basicAuthenticator *v1alpha1.BasicAuthenticator) []string {
+	var secretNames []string
+	if tlsSecretName := getTLSSecretName(basicAuthenticator); tlsSecretName != "" {
+		secretNames = append(secretNames, tlsSecretName)
+	}
+	if ldapConfig := basicAuthenticator.Spec.LDAP; ldapConfig != nil && ldapConfig.BindSecretRef != "" {
+		secretNames = append(secretNames, ldapConfig.BindSecretRef)
+	}
+	if oidcConfig := basicAuthenticator.Spec.OIDC; oidcConfig != nil {
+		secretNames = append(secretNames, oidcConfig.ClientSecretRef)
+	}
+	if basicAuthenticator.Spec.APIKeys != nil {
+		secretNames = append(secretNames, getAPIKeysSecretName(basicAuthenticator))
+	}
+	if jwtConfig := basicAuthenticator.Spec.JWT; jwtConfig != nil && jwtConfig.PublicKeySecretRef != "" {
+		secretNames = append(secretNames, jwtConfig.PublicKeySecretRef)
+	}
+	if mtlsConfig := basicAuthenticator.Spec.MTLS; mtlsConfig != nil && mtlsConfig.CASecretRef != "" {
+		secretNames = append(secretNames, mtlsConfig.CASecretRef)
+	}
+	return secretNames
+}
+
+// getMountedConfigMapNames returns the configmaps mounted into the authenticator pods besides the nginx configuration
+func getMountedConfigMapNames(basicAuthenticator *v1alpha1.BasicAuthenticator) []string {
+	if mtlsConfig := basicAuthenticator.Spec.MTLS; mtlsConfig != nil && mtlsConfig.CAConfigMapRef != "" {
+		return []string{mtlsConfig.CAConfigMapRef}
+	}
+	return nil
+}
+
+// writeHashData writes data to w ordered by key, so the hash is stable across reconciles
+func writeHashData(w io.Writer, data map[string][]byte) {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		w.Write([]byte(key))
+		w.Write(data[key])
+	}
+}
+
+// setConfigHash stamps configHash on a pod template, reporting whether it changed. An empty configHash is ignored.
+func setConfigHash(meta *metav1.ObjectMeta, configHash string) bool {
+	if configHash == "" || meta.Annotations[ConfigHashAnnotation] == configHash {
+		return false
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	meta.Annotations[ConfigHashAnnotation] = configHash
+	return true
+}
+
+// rollInjectedPods evicts the pods the pod webhook injected before the configuration last changed, so their
+// owners recreate them with the current one. A ready pod is only evicted while every other injected pod is ready,
+// one per reconcile, and evictions respect PodDisruptionBudgets. Pods without an owner would not come back and are
+// left alone. It reports whether every injected pod runs the current configuration.
+func (r *BasicAuthenticatorReconciler) rollInjectedPods(ctx context.Context, basicAuthenticator *v1alpha1.BasicAuthenticator) (bool, error) {
+	if r.configHash == "" {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(&basicAuthenticator.Spec.Selector)
+	if err != nil {
+		return false, err
+	}
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods,
+		client.MatchingLabelsSelector{Selector: selector},
+		client.InNamespace(basicAuthenticator.Namespace)); err != nil {
+		return false, err
+	}
+	outdatedPods, unavailable := getOutdatedInjectedPods(pods.Items, basicAuthenticator.Name, r.configHash)
+	if len(outdatedPods) == 0 {
+		return true, nil
+	}
+	r.requeueAfter(podRollDelay)
+	pod := outdatedPods[0]
+	if isPodReady(pod) && unavailable > 0 {
+		return false, nil
+	}
+	r.logger.Info("evicting pod injected with an outdated configuration", "pod", pod.Name)
+	err = r.SubResource("eviction").Create(ctx, pod, &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+	})
+	if errors.IsTooManyRequests(err) || errors.IsNotFound(err) {
+		// a PodDisruptionBudget blocks the eviction for now, or the pod is gone already
+		return false, nil
+	}
+	return false, err
+}
+
+// getOutdatedInjectedPods returns the owned pods injected for basicAuthenticatorName with another configuration
+// than configHash, the ones that are not ready first, and how many injected pods are not ready or terminating
+func getOutdatedInjectedPods(pods []corev1.Pod, basicAuthenticatorName, configHash string) ([]*corev1.Pod, int) {
+	var outdatedPods []*corev1.Pod
+	unavailable := 0
+	for idx := range pods {
+		pod := &pods[idx]
+		if pod.Annotations[InjectedAnnotation] != basicAuthenticatorName || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if pod.DeletionTimestamp != nil || !isPodReady(pod) {
+			unavailable++
+		}
+		if pod.DeletionTimestamp == nil && pod.Annotations[ConfigHashAnnotation] != configHash && metav1.GetControllerOf(pod) != nil {
+			outdatedPods = append(outdatedPods, pod)
+		}
+	}
+	sort.SliceStable(outdatedPods, func(a, b int) bool {
+		return !isPodReady(outdatedPods[a]) && isPodReady(outdatedPods[b])
+	})
+	return outdatedPods, unavailable
+}
+
+// isPodReady reports whether pod passes its readiness checks
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package basic_authenticator
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	"testing"
+)
+
+type testPod struct {
+	name        string
+	injectedFor string
+	configHash  string
+	ready       bool
+	terminating bool
+	unowned     bool
+	phase       corev1.PodPhase
+}
+
+func newTestPod(pod testPod) corev1.Pod {
+	readyStatus := corev1.ConditionFalse
+	if pod.ready {
+		readyStatus = corev1.ConditionTrue
+	}
+	controller := true
+	result := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        pod.name,
+			Annotations: map[string]string{},
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "app", Controller: &controller},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase:      pod.phase,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: readyStatus}},
+		},
+	}
+	if pod.injectedFor != "" {
+		result.Annotations[InjectedAnnotation] = pod.injectedFor
+	}
+	if pod.configHash != "" {
+		result.Annotations[ConfigHashAnnotation] = pod.configHash
+	}
+	if pod.terminating {
+		result.DeletionTimestamp = &metav1.Time{}
+	}
+	if pod.unowned {
+		result.OwnerReferences = nil
+	}
+	return result
+}
+
+func TestGetOutdatedInjectedPods(t *testing.T) {
+	tests := []struct {
+		name            string
+		pods            []testPod
+		wantOutdated    []string
+		wantUnavailable int
+	}{
+		{name: "no pods"},
+		{
+			name: "up to date",
+			pods: []testPod{{name: "a", injectedFor: "auth", configHash: "new", ready: true}, {name: "b", injectedFor: "auth", configHash: "new", ready: true}},
+		},
+		{
+			name:         "outdated and missing hashes",
+			pods:         []testPod{{name: "a", injectedFor: "auth", configHash: "old", ready: true}, {name: "b", injectedFor: "auth", ready: true}, {name: "c", injectedFor: "auth", configHash: "new", ready: true}},
+			wantOutdated: []string{"a", "b"},
+		},
+		{
+			name:            "not ready pods first",
+			pods:            []testPod{{name: "a", injectedFor: "auth", configHash: "old", ready: true}, {name: "b", injectedFor: "auth", configHash: "old"}},
+			wantOutdated:    []string{"b", "a"},
+			wantUnavailable: 1,
+		},
+		{
+			name:            "terminating pods",
+			pods:            []testPod{{name: "a", injectedFor: "auth", configHash: "old", ready: true}, {name: "b", injectedFor: "auth", configHash: "old", ready: true, terminating: true}},
+			wantOutdated:    []string{"a"},
+			wantUnavailable: 1,
+		},
+		{
+			name:            "up to date pod not ready",
+			pods:            []testPod{{name: "a", injectedFor: "auth", configHash: "old", ready: true}, {name: "b", injectedFor: "auth", configHash: "new"}},
+			wantOutdated:    []string{"a"},
+			wantUnavailable: 1,
+		},
+		{
+			name: "other pods",
+			pods: []testPod{
+				{name: "other", injectedFor: "other-auth", configHash: "old", ready: true},
+				{name: "plain", configHash: "old"},
+				{name: "unowned", injectedFor: "auth", configHash: "old", ready: true, unowned: true},
+				{name: "completed", injectedFor: "auth", configHash: "old", phase: corev1.PodSucceeded},
+				{name: "failed", injectedFor: "auth", configHash: "old", phase: corev1.PodFailed},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pods := make([]corev1.Pod, 0, len(test.pods))
+			for _, pod := range test.pods {
+				pods = append(pods, newTestPod(pod))
+			}
+			outdatedPods, unavailable := getOutdatedInjectedPods(pods, "auth", "new")
+			outdatedNames := make([]string, 0, len(outdatedPods))
+			for _, pod := range outdatedPods {
+				outdatedNames = append(outdatedNames, pod.Name)
+			}
+			if len(test.wantOutdated) == 0 {
+				test.wantOutdated = []string{}
+			}
+			if !reflect.DeepEqual(outdatedNames, test.wantOutdated) {
+				t.Errorf("getOutdatedInjectedPods() = %v, want %v", outdatedNames, test.wantOutdated)
+			}
+			if unavailable != test.wantUnavailable {
+				t.Errorf("getOutdatedInjectedPods() unavailable = %d, want %d", unavailable, test.wantUnavailable)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package basic_authenticator
+
+import (
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	"github.com/snapp-incubator/simple-authenticator/pkg/htpasswd"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"time"
+)
+
+const defaultRotationGracePeriod = time.Hour
+
+// rotateCredentials rotates the generated credentials held in secret once they are due or a rotation was
+// requested through RotateCredentialsAnnotation, and forgets the replaced credentials when their grace
+// period is over. The rotation is recorded in secret's annotations, persisted together with the credentials,
+// so a failure to record it in the status or to remove the request doesn't rotate the credentials again.
+// It returns the status changes mirroring secret's annotations, to record once secret is persisted.
+func (r *BasicAuthenticatorReconciler) rotateCredentials(basicAuthenticator *v1alpha1.BasicAuthenticator, secret *corev1.Secret) (func(*v1alpha1.BasicAuthenticatorStatus, int64), error) {
+	now := time.Now()
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	// authenticators rotated before the secret was annotated only have the status to go by
+	lastRotation := getRotationTime(secret, LastRotationAnnotation, basicAuthenticator.Status.LastRotationTime)
+	previousExpiration := getRotationTime(secret, PreviousCredentialsExpirationAnnotation, basicAuthenticator.Status.PreviousCredentialsExpirationTime)
+
+	if previousExpiration != nil {
+		if now.Before(previousExpiration.Time) {
+			r.requeueAfter(previousExpiration.Sub(now))
+		} else {
+			r.logger.Info("previous credentials expired")
+			delete(secret.Data, SecretPreviousHtpasswdField)
+			previousExpiration = nil
+		}
+	}
+
+	request, rotationRequested := basicAuthenticator.Annotations[RotateCredentialsAnnotation]
+	if !rotationRequested {
+		delete(secret.Annotations, HandledRotationAnnotation)
+	} else if handled, ok := secret.Annotations[HandledRotationAnnotation]; ok && handled == request {
+		// rotated already, only removing the request is left
+		rotationRequested = false
+	} else {
+		secret.Annotations[HandledRotationAnnotation] = request
+	}
+	rotation := basicAuthenticator.Spec.CredentialsRotation
+	if rotation != nil && rotation.Interval != nil && rotation.Interval.Duration > 0 {
+		lastRotationTime := secret.CreationTimestamp.Time
+		if lastRotation != nil {
+			lastRotationTime = lastRotation.Time
+		}
+		nextRotation := lastRotationTime.Add(rotation.Interval.Duration)
+		if now.Before(nextRotation) {
+			r.requeueAfter(nextRotation.Sub(now))
+		} else {
+			rotationRequested = true
+		}
+	}
+
+	if rotationRequested {
+		r.logger.Info("rotating credentials")
+		username, password, err := generateCredentials()
+		if err != nil {
+			return nil, err
+		}
+		if rotatesUsername(rotation) {
+			if currentEntry, ok := parseHtpasswd(secret.Data[SecretHtpasswdField]).Get(string(secret.Data["username"])); ok {
+				previousFile := htpasswd.NewFile()
+				if err := previousFile.Set(currentEntry.Username, currentEntry.Hash); err != nil {
+					return nil, err
+				}
+				secret.Data[SecretPreviousHtpasswdField] = previousFile.Bytes()
+			}
+			gracePeriod := getGracePeriod(rotation)
+			previousExpiration = &metav1.Time{Time: now.Add(gracePeriod)}
+			r.requeueAfter(gracePeriod)
+			secret.Data["username"] = []byte(username)
+		}
+		secret.Data["password"] = []byte(password)
+		lastRotation = &metav1.Time{Time: now}
+		if rotation != nil && rotation.Interval != nil && rotation.Interval.Duration > 0 {
+			r.requeueAfter(rotation.Interval.Duration)
+		}
+	}
+
+	setRotationTime(secret, LastRotationAnnotation, lastRotation)
+	setRotationTime(secret, PreviousCredentialsExpirationAnnotation, previousExpiration)
+	return func(status *v1alpha1.BasicAuthenticatorStatus, generation int64) {
+		status.LastRotationTime = lastRotation
+		status.PreviousCredentialsExpirationTime = previousExpiration
+	}, nil
+}
+
+// rotatesUsername reports whether a rotation replaces the username too, which is the default so the replaced
+// credentials can stay valid for the grace period
+func rotatesUsername(rotation *v1alpha1.CredentialsRotation) bool {
+	return rotation == nil || rotation.RotateUsername == nil || *rotation.RotateUsername
+}
+
+// getGracePeriod returns how long the replaced credentials stay valid after a rotation
+func getGracePeriod(rotation *v1alpha1.CredentialsRotation) time.Duration {
+	if rotation == nil || rotation.GracePeriod == nil || rotation.GracePeriod.Duration <= 0 {
+		return defaultRotationGracePeriod
+	}
+	return rotation.GracePeriod.Duration
+}
+
+// getRotationTime returns the time recorded in secret's annotation, or fallback without one
+func getRotationTime(secret *corev1.Secret, annotation string, fallback *metav1.Time) *metav1.Time {
+	value, ok := secret.Annotations[annotation]
+	if !ok {
+		return fallback
+	}
+	recorded, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return fallback
+	}
+	return &metav1.Time{Time: recorded}
+}
+
+// setRotationTime records value in secret's annotation, or removes the annotation if value is nil
+func setRotationTime(secret *corev1.Secret, annotation string, value *metav1.Time) {
+	if value == nil {
+		delete(secret.Annotations, annotation)
+		return
+	}
+	secret.Annotations[annotation] = value.UTC().Format(time.RFC3339)
+}
+
+// requeueAfter schedules the next reconciliation no later than after
+func (r *BasicAuthenticatorReconciler) requeueAfter(after time.Duration) {
+	if r.requeueDelay == 0 || after < r.requeueDelay {
+		r.requeueDelay = after
+	}
+}
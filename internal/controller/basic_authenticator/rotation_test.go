@@ -0,0 +1,247 @@
+package basic_authenticator
+
+import (
+	"github.com/go-logr/logr"
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+const (
+	currentUsername = "current-user"
+	currentPassword = "current-password"
+	currentHash     = "$apr1$salt$hash"
+)
+
+func newRotationSecret(created time.Time, annotations map[string]string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			CreationTimestamp: metav1.Time{Time: created},
+			Annotations:       annotations,
+		},
+		Data: map[string][]byte{
+			"username":          []byte(currentUsername),
+			"password":          []byte(currentPassword),
+			SecretHtpasswdField: []byte(currentUsername + ":" + currentHash + "\n"),
+		},
+	}
+}
+
+func formatRotationTime(value time.Time) string {
+	return value.UTC().Format(time.RFC3339)
+}
+
+// sameSecond reports whether the status time is the annotation time, which only keeps seconds
+func sameSecond(status, annotation *metav1.Time) bool {
+	return status != nil && annotation != nil && status.Unix() == annotation.Unix()
+}
+
+// withinDelay reports whether got is want, give or take the time a test takes
+func withinDelay(got, want time.Duration) bool {
+	return got <= want && got > want-time.Minute
+}
+
+func TestRotateCredentials(t *testing.T) {
+	now := time.Now()
+	keepUsername := false
+	interval := &metav1.Duration{Duration: 2 * time.Hour}
+
+	tests := []struct {
+		name               string
+		rotation           *v1alpha1.CredentialsRotation
+		annotations        map[string]string
+		requestAnnotation  string
+		status             v1alpha1.BasicAuthenticatorStatus
+		created            time.Time
+		previousHtpasswd   bool
+		wantPassword       bool
+		wantUsername       bool
+		wantPrevious       bool
+		wantPreviousExpiry time.Duration
+		wantRequeue        time.Duration
+	}{
+		{name: "no rotation", created: now.Add(-24 * time.Hour)},
+		{name: "never rotated and not due", rotation: &v1alpha1.CredentialsRotation{Interval: interval}, created: now.Add(-time.Hour), wantRequeue: time.Hour},
+		{
+			name:         "never rotated and due",
+			rotation:     &v1alpha1.CredentialsRotation{Interval: interval},
+			created:      now.Add(-3 * time.Hour),
+			wantPassword: true, wantUsername: true, wantPrevious: true,
+			wantPreviousExpiry: defaultRotationGracePeriod,
+			wantRequeue:        defaultRotationGracePeriod,
+		},
+		{
+			name:        "rotated recently",
+			rotation:    &v1alpha1.CredentialsRotation{Interval: interval},
+			annotations: map[string]string{LastRotationAnnotation: formatRotationTime(now.Add(-90 * time.Minute))},
+			created:     now.Add(-24 * time.Hour),
+			wantRequeue: 30 * time.Minute,
+		},
+		{
+			name:         "rotated before the interval",
+			rotation:     &v1alpha1.CredentialsRotation{Interval: interval, GracePeriod: &metav1.Duration{Duration: 3 * time.Hour}},
+			annotations:  map[string]string{LastRotationAnnotation: formatRotationTime(now.Add(-3 * time.Hour))},
+			created:      now.Add(-24 * time.Hour),
+			wantPassword: true, wantUsername: true, wantPrevious: true,
+			wantPreviousExpiry: 3 * time.Hour,
+			wantRequeue:        2 * time.Hour,
+		},
+		{
+			name:        "last rotation from the status",
+			rotation:    &v1alpha1.CredentialsRotation{Interval: interval},
+			status:      v1alpha1.BasicAuthenticatorStatus{LastRotationTime: &metav1.Time{Time: now.Add(-time.Hour)}},
+			created:     now.Add(-24 * time.Hour),
+			wantRequeue: time.Hour,
+		},
+		{
+			name:         "keeping the username",
+			rotation:     &v1alpha1.CredentialsRotation{Interval: interval, RotateUsername: &keepUsername},
+			created:      now.Add(-3 * time.Hour),
+			wantPassword: true,
+			wantRequeue:  2 * time.Hour,
+		},
+		{
+			name:              "requested rotation",
+			requestAnnotation: "1",
+			created:           now,
+			wantPassword:      true, wantUsername: true, wantPrevious: true,
+			wantPreviousExpiry: defaultRotationGracePeriod,
+			wantRequeue:        defaultRotationGracePeriod,
+		},
+		{
+			name:              "handled rotation request",
+			requestAnnotation: "1",
+			annotations:       map[string]string{HandledRotationAnnotation: "1", LastRotationAnnotation: formatRotationTime(now.Add(-time.Minute))},
+			created:           now.Add(-time.Hour),
+		},
+		{
+			name:               "previous credentials valid",
+			annotations:        map[string]string{PreviousCredentialsExpirationAnnotation: formatRotationTime(now.Add(30 * time.Minute))},
+			created:            now.Add(-time.Hour),
+			previousHtpasswd:   true,
+			wantPrevious:       true,
+			wantPreviousExpiry: 30 * time.Minute,
+			wantRequeue:        30 * time.Minute,
+		},
+		{
+			name:             "previous credentials expired",
+			annotations:      map[string]string{PreviousCredentialsExpirationAnnotation: formatRotationTime(now.Add(-time.Minute))},
+			created:          now.Add(-time.Hour),
+			previousHtpasswd: true,
+		},
+		{
+			name:             "previous credentials expired by the status",
+			status:           v1alpha1.BasicAuthenticatorStatus{PreviousCredentialsExpirationTime: &metav1.Time{Time: now.Add(-time.Minute)}},
+			created:          now.Add(-time.Hour),
+			previousHtpasswd: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			basicAuthenticator := &v1alpha1.BasicAuthenticator{
+				Spec:   v1alpha1.BasicAuthenticatorSpec{CredentialsRotation: test.rotation},
+				Status: test.status,
+			}
+			if test.requestAnnotation != "" {
+				basicAuthenticator.Annotations = map[string]string{RotateCredentialsAnnotation: test.requestAnnotation}
+			}
+			secret := newRotationSecret(test.created, test.annotations)
+			if test.previousHtpasswd {
+				secret.Data[SecretPreviousHtpasswdField] = []byte("previous-user:" + currentHash + "\n")
+			}
+			reconciler := &BasicAuthenticatorReconciler{logger: logr.Discard()}
+
+			recordStatus, err := reconciler.rotateCredentials(basicAuthenticator, secret)
+			if err != nil {
+				t.Fatalf("rotateCredentials() returned error: %v", err)
+			}
+			if got := string(secret.Data["password"]) != currentPassword; got != test.wantPassword {
+				t.Errorf("rotateCredentials() rotated password = %v, want %v", got, test.wantPassword)
+			}
+			if got := string(secret.Data["username"]) != currentUsername; got != test.wantUsername {
+				t.Errorf("rotateCredentials() rotated username = %v, want %v", got, test.wantUsername)
+			}
+			_, gotPrevious := secret.Data[SecretPreviousHtpasswdField]
+			if gotPrevious != test.wantPrevious {
+				t.Errorf("rotateCredentials() kept previous htpasswd = %v, want %v", gotPrevious, test.wantPrevious)
+			}
+			if test.wantUsername {
+				if entry, ok := parseHtpasswd(secret.Data[SecretPreviousHtpasswdField]).Get(currentUsername); !ok || entry.Hash != currentHash {
+					t.Errorf("rotateCredentials() previous htpasswd = %q, want the replaced user", secret.Data[SecretPreviousHtpasswdField])
+				}
+			}
+			if !withinDelay(reconciler.requeueDelay, test.wantRequeue) {
+				t.Errorf("rotateCredentials() requeue = %v, want %v", reconciler.requeueDelay, test.wantRequeue)
+			}
+
+			status := v1alpha1.BasicAuthenticatorStatus{}
+			recordStatus(&status, 1)
+			if test.wantPrevious {
+				expiration := getRotationTime(secret, PreviousCredentialsExpirationAnnotation, nil)
+				if expiration == nil || !withinDelay(time.Until(expiration.Time), test.wantPreviousExpiry) {
+					t.Errorf("rotateCredentials() previous expiration = %v, want in %v", expiration, test.wantPreviousExpiry)
+				}
+				if !sameSecond(status.PreviousCredentialsExpirationTime, expiration) {
+					t.Errorf("rotateCredentials() status previous expiration = %v, want %v", status.PreviousCredentialsExpirationTime, expiration)
+				}
+			} else if _, ok := secret.Annotations[PreviousCredentialsExpirationAnnotation]; ok || status.PreviousCredentialsExpirationTime != nil {
+				t.Errorf("rotateCredentials() kept a previous expiration")
+			}
+			if test.wantPassword {
+				lastRotation := getRotationTime(secret, LastRotationAnnotation, nil)
+				if lastRotation == nil || time.Since(lastRotation.Time) > time.Minute {
+					t.Errorf("rotateCredentials() last rotation = %v, want now", lastRotation)
+				}
+				if !sameSecond(status.LastRotationTime, lastRotation) {
+					t.Errorf("rotateCredentials() status last rotation = %v, want %v", status.LastRotationTime, lastRotation)
+				}
+			}
+			if test.requestAnnotation != "" && secret.Annotations[HandledRotationAnnotation] != test.requestAnnotation {
+				t.Errorf("rotateCredentials() handled request = %q, want %q", secret.Annotations[HandledRotationAnnotation], test.requestAnnotation)
+			}
+		})
+	}
+}
+
+func TestRotateCredentialsForgetsHandledRequest(t *testing.T) {
+	secret := newRotationSecret(time.Now(), map[string]string{HandledRotationAnnotation: "1"})
+	reconciler := &BasicAuthenticatorReconciler{logger: logr.Discard()}
+	if _, err := reconciler.rotateCredentials(&v1alpha1.BasicAuthenticator{}, secret); err != nil {
+		t.Fatalf("rotateCredentials() returned error: %v", err)
+	}
+	if _, ok := secret.Annotations[HandledRotationAnnotation]; ok {
+		t.Errorf("rotateCredentials() kept the handled request without a request")
+	}
+}
+
+func TestGetGracePeriod(t *testing.T) {
+	tests := []struct {
+		name     string
+		rotation *v1alpha1.CredentialsRotation
+		want     time.Duration
+	}{
+		{name: "no rotation", rotation: nil, want: defaultRotationGracePeriod},
+		{name: "unset", rotation: &v1alpha1.CredentialsRotation{}, want: defaultRotationGracePeriod},
+		{name: "zero", rotation: &v1alpha1.CredentialsRotation{GracePeriod: &metav1.Duration{}}, want: defaultRotationGracePeriod},
+		{name: "set", rotation: &v1alpha1.CredentialsRotation{GracePeriod: &metav1.Duration{Duration: 24 * time.Hour}}, want: 24 * time.Hour},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := getGracePeriod(test.rotation); got != test.want {
+				t.Errorf("getGracePeriod() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRequeueAfter(t *testing.T) {
+	reconciler := &BasicAuthenticatorReconciler{}
+	reconciler.requeueAfter(time.Hour)
+	reconciler.requeueAfter(2 * time.Hour)
+	reconciler.requeueAfter(30 * time.Minute)
+	if reconciler.requeueDelay != 30*time.Minute {
+		t.Errorf("requeueAfter() = %v, want the earliest delay", reconciler.requeueDelay)
+	}
+}
@@ -0,0 +1,100 @@
+package basic_authenticator
+
+import (
+	"github.com/snapp-incubator/simple-authenticator/internal/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// nginxUnprivilegedUser is the nginx user of the nginx-unprivileged image
+	nginxUnprivilegedUser = 101
+	// exporterUnprivilegedUser is the nobody user the exporter image runs as
+	exporterUnprivilegedUser = 65534
+	// TmpVolumeName and CacheVolumeName are the writable paths nginx needs with a read-only root filesystem
+	TmpVolumeName   = "basicauthenticator-tmp"
+	CacheVolumeName = "basicauthenticator-cache"
+	tmpMountPath    = "/tmp"
+	cacheMountPath  = "/var/cache/nginx"
+)
+
+// isUnprivileged reports whether nginx runs hardened, which the operator's configuration can opt out of
+// for images that need root
+func isUnprivileged(customConfig *config.CustomConfig) bool {
+	return customConfig == nil || !customConfig.WebserverConf.Privileged
+}
+
+// newRestrictedSecurityContext satisfies the restricted Pod Security Standard for a container running as user
+func newRestrictedSecurityContext(user int64) *corev1.SecurityContext {
+	runAsNonRoot := true
+	allowPrivilegeEscalation := false
+	readOnlyRootFilesystem := true
+	return &corev1.SecurityContext{
+		RunAsUser:                &user,
+		RunAsGroup:               &user,
+		RunAsNonRoot:             &runAsNonRoot,
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+// newRestrictedPodSecurityContext is the pod level part of the restricted Pod Security Standard
+func newRestrictedPodSecurityContext() *corev1.PodSecurityContext {
+	runAsNonRoot := true
+	return &corev1.PodSecurityContext{
+		RunAsNonRoot: &runAsNonRoot,
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+// getWritableVolumes returns the emptyDir volumes backing nginx's temp and cache paths and their mounts
+func getWritableVolumes() ([]corev1.Volume, []corev1.VolumeMount) {
+	volumes := []corev1.Volume{
+		{
+			Name:         TmpVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+		{
+			Name:         CacheVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+	}
+	mounts := []corev1.VolumeMount{
+		{
+			Name:      TmpVolumeName,
+			MountPath: tmpMountPath,
+		},
+		{
+			Name:      CacheVolumeName,
+			MountPath: cacheMountPath,
+		},
+	}
+	return volumes, mounts
+}
+
+// hardenContainer runs the nginx container as non-root on a read-only root filesystem
+func hardenContainer(container *corev1.Container, customConfig *config.CustomConfig) {
+	if !isUnprivileged(customConfig) {
+		return
+	}
+	_, mounts := getWritableVolumes()
+	container.VolumeMounts = append(container.VolumeMounts, mounts...)
+	container.SecurityContext = newRestrictedSecurityContext(nginxUnprivilegedUser)
+}
+
+// hardenPodSpec adds the writable volumes of hardenContainer and the pod level security context
+func hardenPodSpec(podSpec *corev1.PodSpec, customConfig *config.CustomConfig) {
+	if !isUnprivileged(customConfig) {
+		return
+	}
+	volumes, _ := getWritableVolumes()
+	podSpec.Volumes = append(podSpec.Volumes, volumes...)
+	podSpec.SecurityContext = newRestrictedPodSecurityContext()
+}
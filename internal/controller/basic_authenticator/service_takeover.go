@@ -0,0 +1,273 @@
+package basic_authenticator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/opdev/subreconciler"
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// originalService is what a taken over service looked like, stored in OriginalServiceAnnotation
+type originalService struct {
+	Selector map[string]string    `json:"selector"`
+	Ports    []corev1.ServicePort `json:"ports"`
+}
+
+// getBackingServiceName is the service selecting the application pods while AppService is taken over
+func getBackingServiceName(basicAuthenticator *v1alpha1.BasicAuthenticator) string {
+	return fmt.Sprintf("%s-backend", basicAuthenticator.Spec.AppService)
+}
+
+// getUpstreamService is the service the authenticator proxies to
+func getUpstreamService(basicAuthenticator *v1alpha1.BasicAuthenticator) string {
+	switch {
+	case basicAuthenticator.Spec.Type == "sidecar":
+		return "localhost"
+	case basicAuthenticator.Spec.ServiceTakeover:
+		return getBackingServiceName(basicAuthenticator)
+	}
+	return basicAuthenticator.Spec.AppService
+}
+
+// getOriginalService returns the selector and ports appService had before basicAuthenticator took it over
+func getOriginalService(basicAuthenticator *v1alpha1.BasicAuthenticator, appService *corev1.Service) (*originalService, error) {
+	takenOverBy, takenOver := appService.Labels[ServiceTakeoverLabel]
+	if takenOver && takenOverBy != basicAuthenticator.Name {
+		return nil, fmt.Errorf("service %s is already taken over by %s", appService.Name, takenOverBy)
+	}
+	if recorded, exists := appService.Annotations[OriginalServiceAnnotation]; takenOver && exists {
+		original := &originalService{}
+		if err := json.Unmarshal([]byte(recorded), original); err != nil {
+			return nil, fmt.Errorf("unreadable %s annotation on service %s: %w", OriginalServiceAnnotation, appService.Name, err)
+		}
+		return original, nil
+	}
+
+	if appService.Spec.ClusterIP == corev1.ClusterIPNone {
+		return nil, fmt.Errorf("headless service %s can not be taken over", appService.Name)
+	}
+	if len(appService.Spec.Selector) == 0 {
+		return nil, fmt.Errorf("service %s has no selector to take over", appService.Name)
+	}
+	if len(appService.Spec.Ports) != 1 || int(appService.Spec.Ports[0].Port) != basicAuthenticator.Spec.AppPort {
+		return nil, fmt.Errorf("service %s must expose only appPort %d to be taken over", appService.Name, basicAuthenticator.Spec.AppPort)
+	}
+	return &originalService{
+		Selector: appService.Spec.Selector,
+		Ports:    appService.Spec.Ports,
+	}, nil
+}
+
+func createBackingService(basicAuthenticator *v1alpha1.BasicAuthenticator, original *originalService) *corev1.Service {
+	ports := make([]corev1.ServicePort, 0, len(original.Ports))
+	for _, port := range original.Ports {
+		port.NodePort = 0
+		ports = append(ports, port)
+	}
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getBackingServiceName(basicAuthenticator),
+			Namespace: basicAuthenticator.Namespace,
+			Labels: map[string]string{
+				basicAuthenticatorNameLabel: basicAuthenticator.Name,
+				ServiceTakeoverLabel:        basicAuthenticator.Name,
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: original.Selector,
+			Ports:    ports,
+			Type:     corev1.ServiceTypeClusterIP,
+		},
+	}
+}
+
+// ensureBackingService copies AppService's original selector into the backing service, so the
+// authenticator has an upstream before AppService is pointed at it
+func (r *BasicAuthenticatorReconciler) ensureBackingService(ctx context.Context, req ctrl.Request) (*ctrl.Result, error) {
+	basicAuthenticator := &v1alpha1.BasicAuthenticator{}
+
+	if r, err := r.getLatestBasicAuthenticator(ctx, req, basicAuthenticator); subreconciler.ShouldHaltOrRequeue(r, err) {
+		return subreconciler.RequeueWithError(err)
+	}
+	if !basicAuthenticator.Spec.ServiceTakeover {
+		return subreconciler.ContinueReconciling()
+	}
+
+	appService := &corev1.Service{}
+	if err := r.Get(ctx, types.NamespacedName{Name: basicAuthenticator.Spec.AppService, Namespace: basicAuthenticator.Namespace}, appService); err != nil {
+		r.logger.Error(err, "failed to fetch service to take over")
+		return subreconciler.RequeueWithError(err)
+	}
+	original, err := getOriginalService(basicAuthenticator, appService)
+	if err != nil {
+		r.logger.Error(err, "failed to take over service")
+		return subreconciler.RequeueWithError(err)
+	}
+
+	newService := createBackingService(basicAuthenticator, original)
+	foundService := &corev1.Service{}
+	err = r.Get(ctx, types.NamespacedName{Name: newService.Name, Namespace: newService.Namespace}, foundService)
+	if errors.IsNotFound(err) {
+		if err := ctrl.SetControllerReference(basicAuthenticator, newService, r.Scheme); err != nil {
+			r.logger.Error(err, "failed to set backing service owner")
+			return subreconciler.RequeueWithError(err)
+		}
+		if err := r.Create(ctx, newService); err != nil {
+			r.logger.Error(err, "failed to create backing service")
+			return subreconciler.RequeueWithError(err)
+		}
+	} else if err != nil {
+		r.logger.Error(err, "failed to fetch backing service")
+		return subreconciler.RequeueWithError(err)
+	} else if !metav1.IsControlledBy(foundService, basicAuthenticator) {
+		return subreconciler.RequeueWithError(fmt.Errorf("service %s exists and is not managed by %s", foundService.Name, basicAuthenticator.Name))
+	} else if !equality.Semantic.DeepEqual(foundService.Spec.Selector, newService.Spec.Selector) || !equality.Semantic.DeepEqual(foundService.Spec.Ports, newService.Spec.Ports) {
+		r.logger.Info("updating backing service")
+		foundService.Spec.Selector = newService.Spec.Selector
+		foundService.Spec.Ports = newService.Spec.Ports
+		if err := r.Update(ctx, foundService); err != nil {
+			r.logger.Error(err, "failed to update backing service")
+			return subreconciler.RequeueWithError(err)
+		}
+	}
+	return subreconciler.ContinueReconciling()
+}
+
+// takeOverAppService points AppService at the authenticator pods once they are ready, recording what
+// it pointed at before. Services taken over earlier and no longer wanted are rolled back.
+func (r *BasicAuthenticatorReconciler) takeOverAppService(ctx context.Context, req ctrl.Request) (*ctrl.Result, error) {
+	basicAuthenticator := &v1alpha1.BasicAuthenticator{}
+
+	if r, err := r.getLatestBasicAuthenticator(ctx, req, basicAuthenticator); subreconciler.ShouldHaltOrRequeue(r, err) {
+		return subreconciler.RequeueWithError(err)
+	}
+	keep := make([]string, 0)
+	if basicAuthenticator.Spec.ServiceTakeover {
+		keep = append(keep, basicAuthenticator.Spec.AppService, getBackingServiceName(basicAuthenticator))
+	}
+	if err := r.releaseServices(ctx, basicAuthenticator, keep); err != nil {
+		r.logger.Error(err, "failed to roll back taken over services")
+		return subreconciler.RequeueWithError(err)
+	}
+	if !basicAuthenticator.Spec.ServiceTakeover {
+		return subreconciler.ContinueReconciling()
+	}
+	if !r.workloadReady || r.deploymentLabel == nil {
+		// callers are only moved over once the authenticator can serve them
+		r.logger.Info("waiting for the authenticator to become ready before taking over service")
+		return subreconciler.ContinueReconciling()
+	}
+
+	appService := &corev1.Service{}
+	if err := r.Get(ctx, types.NamespacedName{Name: basicAuthenticator.Spec.AppService, Namespace: basicAuthenticator.Namespace}, appService); err != nil {
+		r.logger.Error(err, "failed to fetch service to take over")
+		return subreconciler.RequeueWithError(err)
+	}
+	original, err := getOriginalService(basicAuthenticator, appService)
+	if err != nil {
+		r.logger.Error(err, "failed to take over service")
+		return subreconciler.RequeueWithError(err)
+	}
+	recorded, err := json.Marshal(original)
+	if err != nil {
+		return subreconciler.RequeueWithError(err)
+	}
+
+	ports := make([]corev1.ServicePort, 0, len(original.Ports))
+	for _, port := range original.Ports {
+		port.TargetPort = intstr.FromInt(basicAuthenticator.Spec.AuthenticatorPort)
+		ports = append(ports, port)
+	}
+	if appService.Labels[ServiceTakeoverLabel] == basicAuthenticator.Name &&
+		appService.Annotations[OriginalServiceAnnotation] == string(recorded) &&
+		equality.Semantic.DeepEqual(appService.Spec.Selector, r.deploymentLabel.MatchLabels) &&
+		equality.Semantic.DeepEqual(appService.Spec.Ports, ports) {
+		return subreconciler.ContinueReconciling()
+	}
+
+	if appService.Labels == nil {
+		appService.Labels = make(map[string]string)
+	}
+	if appService.Annotations == nil {
+		appService.Annotations = make(map[string]string)
+	}
+	appService.Labels[ServiceTakeoverLabel] = basicAuthenticator.Name
+	appService.Annotations[OriginalServiceAnnotation] = string(recorded)
+	appService.Spec.Selector = r.deploymentLabel.MatchLabels
+	appService.Spec.Ports = ports
+	r.logger.Info("taking over service", "service", appService.Name)
+	if err := r.Update(ctx, appService); err != nil {
+		r.logger.Error(err, "failed to take over service")
+		return subreconciler.RequeueWithError(err)
+	}
+	return subreconciler.ContinueReconciling()
+}
+
+// releaseServices rolls back the services taken over by basicAuthenticator and deletes their backing
+// services, except for the ones named in keep. Taken over services are restored before any backing
+// service goes away, so callers are never left without endpoints.
+func (r *BasicAuthenticatorReconciler) releaseServices(ctx context.Context, basicAuthenticator *v1alpha1.BasicAuthenticator, keep []string) error {
+	var services corev1.ServiceList
+	if err := r.List(ctx, &services,
+		client.MatchingLabels{ServiceTakeoverLabel: basicAuthenticator.Name},
+		client.InNamespace(basicAuthenticator.Namespace)); err != nil {
+		return err
+	}
+
+	backingServices := make([]*corev1.Service, 0)
+	for idx := range services.Items {
+		service := &services.Items[idx]
+		if existsInList(keep, service.Name) {
+			continue
+		}
+		recorded, takenOver := service.Annotations[OriginalServiceAnnotation]
+		if !takenOver {
+			if metav1.IsControlledBy(service, basicAuthenticator) {
+				backingServices = append(backingServices, service)
+			}
+			continue
+		}
+		original := &originalService{}
+		if err := json.Unmarshal([]byte(recorded), original); err != nil {
+			return fmt.Errorf("unreadable %s annotation on service %s: %w", OriginalServiceAnnotation, service.Name, err)
+		}
+		service.Spec.Selector = original.Selector
+		service.Spec.Ports = original.Ports
+		delete(service.Labels, ServiceTakeoverLabel)
+		delete(service.Annotations, OriginalServiceAnnotation)
+		r.logger.Info("rolling back taken over service", "service", service.Name)
+		if err := r.Update(ctx, service); err != nil {
+			return err
+		}
+	}
+	for _, service := range backingServices {
+		r.logger.Info("deleting backing service", "service", service.Name)
+		if err := client.IgnoreNotFound(r.Delete(ctx, service)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollbackServiceTakeover hands the taken over service back to the application pods on deletion
+func (r *BasicAuthenticatorReconciler) rollbackServiceTakeover(ctx context.Context, req ctrl.Request) (*ctrl.Result, error) {
+	basicAuthenticator := &v1alpha1.BasicAuthenticator{}
+
+	if r, err := r.getLatestBasicAuthenticator(ctx, req, basicAuthenticator); subreconciler.ShouldHaltOrRequeue(r, err) {
+		return subreconciler.RequeueWithError(err)
+	}
+	if err := r.releaseServices(ctx, basicAuthenticator, nil); err != nil {
+		r.logger.Error(err, "failed to roll back taken over services")
+		return subreconciler.RequeueWithError(err)
+	}
+	return subreconciler.ContinueReconciling()
+}
@@ -0,0 +1,216 @@
+package basic_authenticator
+
+import (
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	"github.com/snapp-incubator/simple-authenticator/internal/config"
+	"github.com/snapp-incubator/simple-authenticator/pkg/random_generator"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	"strings"
+)
+
+// InjectSidecar adds the nginx sidecar of basicAuthenticator, its auth server if any, and their volumes to a pod
+// template, or brings already injected ones back to their desired state, e.g. after an image bump or a renamed
+// secret. What was injected is recorded in the template's annotations so replaced containers and volumes can be
+// removed. It reports whether meta or podSpec changed.
+func InjectSidecar(meta *metav1.ObjectMeta, podSpec *corev1.PodSpec, basicAuthenticator *v1alpha1.BasicAuthenticator, configMapName, credentialName string, customConfig *config.CustomConfig) bool {
+	containers := []corev1.Container{newSidecarContainer(basicAuthenticator, configMapName, credentialName, customConfig)}
+	if hasAuthServer(basicAuthenticator) {
+		containers = append(containers, newAuthServerContainer(basicAuthenticator, customConfig))
+	}
+	volumes := newSidecarVolumes(basicAuthenticator, configMapName, credentialName, customConfig)
+	changed := false
+
+	containerNames := make([]string, 0, len(containers))
+	for _, container := range containers {
+		containerNames = append(containerNames, container.Name)
+	}
+	for _, previousContainer := range strings.Split(meta.Annotations[SidecarContainerAnnotation], ",") {
+		if previousContainer == "" || existsInList(containerNames, previousContainer) {
+			continue
+		}
+		if idx := getContainerIndex(podSpec.Containers, previousContainer); idx != -1 {
+			podSpec.Containers = append(podSpec.Containers[:idx], podSpec.Containers[idx+1:]...)
+			changed = true
+		}
+	}
+	for _, container := range containers {
+		if updateSidecarContainer(podSpec, container) {
+			changed = true
+		}
+	}
+
+	volumeNames := make([]string, 0, len(volumes))
+	for _, volume := range volumes {
+		volumeNames = append(volumeNames, volume.Name)
+	}
+	for _, previousVolume := range strings.Split(meta.Annotations[SidecarVolumesAnnotation], ",") {
+		if previousVolume == "" || existsInList(volumeNames, previousVolume) {
+			continue
+		}
+		if idx := getVolumeIndex(podSpec.Volumes, previousVolume); idx != -1 {
+			podSpec.Volumes = append(podSpec.Volumes[:idx], podSpec.Volumes[idx+1:]...)
+			changed = true
+		}
+	}
+	for _, volume := range volumes {
+		if idx := getVolumeIndex(podSpec.Volumes, volume.Name); idx == -1 {
+			podSpec.Volumes = append(podSpec.Volumes, volume)
+			changed = true
+		} else if !reflect.DeepEqual(podSpec.Volumes[idx], volume) {
+			podSpec.Volumes[idx] = volume
+			changed = true
+		}
+	}
+
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	if joinedContainerNames := strings.Join(containerNames, ","); meta.Annotations[SidecarContainerAnnotation] != joinedContainerNames {
+		meta.Annotations[SidecarContainerAnnotation] = joinedContainerNames
+		changed = true
+	}
+	if joinedVolumeNames := strings.Join(volumeNames, ","); meta.Annotations[SidecarVolumesAnnotation] != joinedVolumeNames {
+		meta.Annotations[SidecarVolumesAnnotation] = joinedVolumeNames
+		changed = true
+	}
+	return changed
+}
+
+// updateSidecarContainer adds container to podSpec, or updates the fields the sidecar sets on an already injected
+// one. It reports whether podSpec changed.
+func updateSidecarContainer(podSpec *corev1.PodSpec, container corev1.Container) bool {
+	idx := getContainerIndex(podSpec.Containers, container.Name)
+	if idx == -1 {
+		podSpec.Containers = append(podSpec.Containers, container)
+		return true
+	}
+	// fields the sidecar does not set are left to their defaults, or to whoever changed them
+	updatedContainer := podSpec.Containers[idx].DeepCopy()
+	updatedContainer.Image = container.Image
+	updatedContainer.Args = container.Args
+	updatedContainer.Ports = container.Ports
+	updatedContainer.VolumeMounts = container.VolumeMounts
+	updatedContainer.Resources = container.Resources
+	updatedContainer.LivenessProbe = container.LivenessProbe
+	updatedContainer.ReadinessProbe = container.ReadinessProbe
+	updatedContainer.SecurityContext = container.SecurityContext
+	updatedContainer.Env = container.Env
+	if container.ImagePullPolicy != "" {
+		updatedContainer.ImagePullPolicy = container.ImagePullPolicy
+	}
+	// quantities only compare equal semantically once they went through the API server
+	if equality.Semantic.DeepEqual(*updatedContainer, podSpec.Containers[idx]) {
+		return false
+	}
+	podSpec.Containers[idx] = *updatedContainer
+	return true
+}
+
+// newSidecarContainer returns the desired sidecar, with the API server's defaults for the fields it sets so
+// it compares equal to an already injected one
+func newSidecarContainer(basicAuthenticator *v1alpha1.BasicAuthenticator, configMapName, credentialName string, customConfig *config.CustomConfig) corev1.Container {
+	container := corev1.Container{
+		Name:  getNginxContainerName(customConfig),
+		Image: getNginxContainerImage(customConfig),
+		Ports: []corev1.ContainerPort{
+			{
+				ContainerPort: int32(basicAuthenticator.Spec.AuthenticatorPort),
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      configMapName,
+				MountPath: ConfigMountPath,
+			},
+			{
+				Name:      credentialName,
+				MountPath: SecretMountDir,
+			},
+		},
+	}
+	if _, mount := getTLSVolume(basicAuthenticator); mount != nil {
+		container.VolumeMounts = append(container.VolumeMounts, *mount)
+		if redirectPort := basicAuthenticator.Spec.TLS.HTTPRedirectPort; redirectPort != 0 {
+			container.Ports = append(container.Ports, corev1.ContainerPort{ContainerPort: int32(redirectPort)})
+		}
+	}
+	if _, mount := getMTLSVolume(basicAuthenticator); mount != nil {
+		container.VolumeMounts = append(container.VolumeMounts, *mount)
+	}
+	for idx := range container.Ports {
+		container.Ports[idx].Protocol = corev1.ProtocolTCP
+	}
+	hardenContainer(&container, customConfig)
+	applyContainerOverrides(&container, basicAuthenticator, customConfig)
+	return container
+}
+
+func newSidecarVolumes(basicAuthenticator *v1alpha1.BasicAuthenticator, configMapName, credentialName string, customConfig *config.CustomConfig) []corev1.Volume {
+	defaultMode := corev1.SecretVolumeSourceDefaultMode
+	volumes := []corev1.Volume{
+		{
+			Name: configMapName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: configMapName,
+					},
+					DefaultMode: &defaultMode,
+				},
+			},
+		},
+		{
+			Name: credentialName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName:  credentialName,
+					Items:       getSecretVolumeItems(basicAuthenticator),
+					DefaultMode: &defaultMode,
+				},
+			},
+		},
+	}
+	if volume, _ := getTLSVolume(basicAuthenticator); volume != nil {
+		volume.Secret.DefaultMode = &defaultMode
+		volumes = append(volumes, *volume)
+	}
+	if volume, _ := getMTLSVolume(basicAuthenticator); volume != nil {
+		volumes = append(volumes, *volume)
+	}
+	if isUnprivileged(customConfig) {
+		writableVolumes, _ := getWritableVolumes()
+		volumes = append(volumes, writableVolumes...)
+	}
+	volumes = append(volumes, getAuthServerVolumes(basicAuthenticator)...)
+	return volumes
+}
+
+func getVolumeIndex(volumes []corev1.Volume, name string) int {
+	for idx, volume := range volumes {
+		if volume.Name == name {
+			return idx
+		}
+	}
+	return -1
+}
+
+// GetSidecarResourceNames returns the configmap and secret the sidecar of basicAuthenticator mounts.
+// The secret name is empty until the controller generated credentials for basicAuthenticator.
+func GetSidecarResourceNames(basicAuthenticator *v1alpha1.BasicAuthenticator) (string, string) {
+	credentialName := basicAuthenticator.Spec.CredentialsSecretRef
+	if len(basicAuthenticator.Spec.CredentialsSecretRefs) > 0 {
+		credentialName = getMergedCredentialsName(basicAuthenticator)
+	}
+	return getNginxConfigmapName(basicAuthenticator), credentialName
+}
+
+func getNginxConfigmapName(basicAuthenticator *v1alpha1.BasicAuthenticator) string {
+	return random_generator.GenerateRandomName(basicAuthenticator.Name, "configmap")
+}
+
+func getMergedCredentialsName(basicAuthenticator *v1alpha1.BasicAuthenticator) string {
+	return random_generator.GenerateRandomName(basicAuthenticator.Name, "htpasswd")
+}
@@ -0,0 +1,71 @@
+package basic_authenticator
+
+import (
+	"context"
+	"fmt"
+	"github.com/opdev/subreconciler"
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// withCondition wraps the sub-reconcilers provisioning conditionType so that their outcome is recorded as
+// conditionType, written once after all of them ran or one of them failed. Recording each step on its own would
+// flip the condition on every failing reconcile, and the resulting status write would requeue it right away.
+// A failure also marks the basicAuthenticator as degraded and not ready.
+func (r *BasicAuthenticatorReconciler) withCondition(conditionType string, provisioners ...subreconciler.FnWithRequest) subreconciler.FnWithRequest {
+	return func(ctx context.Context, req ctrl.Request) (*ctrl.Result, error) {
+		for _, provisioner := range provisioners {
+			result, err := provisioner(ctx, req)
+			if err != nil {
+				message := fmt.Sprintf("%s: %s", conditionType, err.Error())
+				if statusErr := r.updateStatus(ctx, req, func(status *v1alpha1.BasicAuthenticatorStatus, generation int64) {
+					setCondition(status, conditionType, metav1.ConditionFalse, v1alpha1.ReasonProvisioningFailed, err.Error(), generation)
+					setCondition(status, v1alpha1.ConditionDegraded, metav1.ConditionTrue, v1alpha1.ReasonProvisioningFailed, message, generation)
+					setCondition(status, v1alpha1.ConditionReady, metav1.ConditionFalse, v1alpha1.ReasonProvisioningFailed, message, generation)
+					status.Reason = message
+				}); statusErr != nil {
+					r.logger.Error(statusErr, "failed to update status conditions", "condition", conditionType)
+				}
+				return result, err
+			}
+			if subreconciler.ShouldHaltOrRequeue(result, err) {
+				return result, err
+			}
+		}
+		if statusErr := r.updateStatus(ctx, req, func(status *v1alpha1.BasicAuthenticatorStatus, generation int64) {
+			setCondition(status, conditionType, metav1.ConditionTrue, v1alpha1.ReasonProvisioned, "", generation)
+		}); statusErr != nil {
+			r.logger.Error(statusErr, "failed to update status conditions", "condition", conditionType)
+			return subreconciler.RequeueWithError(statusErr)
+		}
+		return subreconciler.ContinueReconciling()
+	}
+}
+
+// updateStatus applies mutate to the latest basicAuthenticator's status and writes it through
+// the status subresource, skipping the write when nothing changed.
+func (r *BasicAuthenticatorReconciler) updateStatus(ctx context.Context, req ctrl.Request, mutate func(status *v1alpha1.BasicAuthenticatorStatus, generation int64)) error {
+	basicAuthenticator := &v1alpha1.BasicAuthenticator{}
+	if err := r.Get(ctx, req.NamespacedName, basicAuthenticator); err != nil {
+		return err
+	}
+	previousStatus := basicAuthenticator.Status.DeepCopy()
+	mutate(&basicAuthenticator.Status, basicAuthenticator.Generation)
+	if reflect.DeepEqual(previousStatus, &basicAuthenticator.Status) {
+		return nil
+	}
+	return r.Status().Update(ctx, basicAuthenticator)
+}
+
+func setCondition(status *v1alpha1.BasicAuthenticatorStatus, conditionType string, conditionStatus metav1.ConditionStatus, reason, message string, generation int64) {
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             conditionStatus,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: generation,
+	})
+}
@@ -0,0 +1,71 @@
+package basic_authenticator
+
+import (
+	"context"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// getPodTemplate returns the pod template of the workload kinds a sidecar can be injected into, or nil for other objects
+func getPodTemplate(workload client.Object) *corev1.PodTemplateSpec {
+	switch typedWorkload := workload.(type) {
+	case *appsv1.Deployment:
+		return &typedWorkload.Spec.Template
+	case *appsv1.StatefulSet:
+		return &typedWorkload.Spec.Template
+	case *appsv1.DaemonSet:
+		return &typedWorkload.Spec.Template
+	case *appsv1.ReplicaSet:
+		return &typedWorkload.Spec.Template
+	}
+	return nil
+}
+
+// listTargetWorkloads lists the Deployments, StatefulSets, DaemonSets and ReplicaSets of namespace matching selector.
+// ReplicaSets controlled by a Deployment are skipped, their Deployment is the one to inject.
+func listTargetWorkloads(ctx context.Context, k8Client client.Client, namespace string, selector labels.Selector) ([]client.Object, error) {
+	listOptions := []client.ListOption{
+		client.MatchingLabelsSelector{Selector: selector},
+		client.InNamespace(namespace),
+	}
+	workloads := make([]client.Object, 0)
+
+	var deploymentList appsv1.DeploymentList
+	if err := k8Client.List(ctx, &deploymentList, listOptions...); err != nil {
+		return nil, err
+	}
+	for idx := range deploymentList.Items {
+		workloads = append(workloads, &deploymentList.Items[idx])
+	}
+
+	var statefulSetList appsv1.StatefulSetList
+	if err := k8Client.List(ctx, &statefulSetList, listOptions...); err != nil {
+		return nil, err
+	}
+	for idx := range statefulSetList.Items {
+		workloads = append(workloads, &statefulSetList.Items[idx])
+	}
+
+	var daemonSetList appsv1.DaemonSetList
+	if err := k8Client.List(ctx, &daemonSetList, listOptions...); err != nil {
+		return nil, err
+	}
+	for idx := range daemonSetList.Items {
+		workloads = append(workloads, &daemonSetList.Items[idx])
+	}
+
+	var replicaSetList appsv1.ReplicaSetList
+	if err := k8Client.List(ctx, &replicaSetList, listOptions...); err != nil {
+		return nil, err
+	}
+	for idx := range replicaSetList.Items {
+		if metav1.GetControllerOf(&replicaSetList.Items[idx]) != nil {
+			continue
+		}
+		workloads = append(workloads, &replicaSetList.Items[idx])
+	}
+	return workloads, nil
+}
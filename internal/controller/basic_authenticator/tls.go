@@ -0,0 +1,183 @@
+package basic_authenticator
+
+import (
+	"context"
+	"fmt"
+	"github.com/opdev/subreconciler"
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	"github.com/snapp-incubator/simple-authenticator/pkg/random_generator"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// certificateGVK is cert-manager's Certificate, handled as unstructured so cert-manager stays an optional dependency
+var certificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+// nginxTLSConfig is the TLS part of nginxConfig
+type nginxTLSConfig struct {
+	CertificatePath string
+	KeyPath         string
+	RedirectPort    int
+	// RedirectHost is appended to $host in redirects, empty when HTTPS is served on the default port
+	RedirectHost string
+}
+
+func newNginxTLSConfig(basicAuthenticator *v1alpha1.BasicAuthenticator) *nginxTLSConfig {
+	tls := basicAuthenticator.Spec.TLS
+	if tls == nil {
+		return nil
+	}
+	redirectHost := ""
+	if basicAuthenticator.Spec.AuthenticatorPort != 443 {
+		redirectHost = fmt.Sprintf(":%d", basicAuthenticator.Spec.AuthenticatorPort)
+	}
+	return &nginxTLSConfig{
+		CertificatePath: fmt.Sprintf("%s/%s", TLSMountDir, corev1.TLSCertKey),
+		KeyPath:         fmt.Sprintf("%s/%s", TLSMountDir, corev1.TLSPrivateKeyKey),
+		RedirectPort:    tls.HTTPRedirectPort,
+		RedirectHost:    redirectHost,
+	}
+}
+
+// getTLSSecretName returns the secret holding the authenticator's certificate, or an empty string without TLS
+func getTLSSecretName(basicAuthenticator *v1alpha1.BasicAuthenticator) string {
+	tls := basicAuthenticator.Spec.TLS
+	switch {
+	case tls == nil:
+		return ""
+	case tls.SecretName != "":
+		return tls.SecretName
+	case tls.CertManager != nil:
+		return random_generator.GenerateRandomName(basicAuthenticator.Name, "tls")
+	}
+	return ""
+}
+
+// getTLSVolume returns the volume and mount exposing the certificate to nginx
+func getTLSVolume(basicAuthenticator *v1alpha1.BasicAuthenticator) (*corev1.Volume, *corev1.VolumeMount) {
+	secretName := getTLSSecretName(basicAuthenticator)
+	if secretName == "" {
+		return nil, nil
+	}
+	volume := &corev1.Volume{
+		Name: TLSVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: secretName,
+				Items: []corev1.KeyToPath{
+					{
+						Key:  corev1.TLSCertKey,
+						Path: corev1.TLSCertKey,
+					},
+					{
+						Key:  corev1.TLSPrivateKeyKey,
+						Path: corev1.TLSPrivateKeyKey,
+					},
+				},
+			},
+		},
+	}
+	mount := &corev1.VolumeMount{
+		Name:      TLSVolumeName,
+		MountPath: TLSMountDir,
+		ReadOnly:  true,
+	}
+	return volume, mount
+}
+
+// getCertificateDNSNames covers every name the authenticator service is reachable through inside the cluster
+func getCertificateDNSNames(basicAuthenticator *v1alpha1.BasicAuthenticator) []string {
+	serviceName := fmt.Sprintf("%s-svc", basicAuthenticator.Name)
+	dnsNames := []string{
+		serviceName,
+		fmt.Sprintf("%s.%s", serviceName, basicAuthenticator.Namespace),
+		fmt.Sprintf("%s.%s.svc", serviceName, basicAuthenticator.Namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", serviceName, basicAuthenticator.Namespace),
+	}
+	for _, dnsName := range basicAuthenticator.Spec.TLS.CertManager.DNSNames {
+		if !existsInList(dnsNames, dnsName) {
+			dnsNames = append(dnsNames, dnsName)
+		}
+	}
+	return dnsNames
+}
+
+func createCertificate(basicAuthenticator *v1alpha1.BasicAuthenticator) *unstructured.Unstructured {
+	certManager := basicAuthenticator.Spec.TLS.CertManager
+	secretName := getTLSSecretName(basicAuthenticator)
+	issuerKind := certManager.IssuerRef.Kind
+	if issuerKind == "" {
+		issuerKind = "Issuer"
+	}
+	issuerGroup := certManager.IssuerRef.Group
+	if issuerGroup == "" {
+		issuerGroup = certificateGVK.Group
+	}
+	dnsNames := make([]interface{}, 0)
+	for _, dnsName := range getCertificateDNSNames(basicAuthenticator) {
+		dnsNames = append(dnsNames, dnsName)
+	}
+
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(certificateGVK)
+	certificate.SetName(secretName)
+	certificate.SetNamespace(basicAuthenticator.Namespace)
+	certificate.SetLabels(map[string]string{basicAuthenticatorNameLabel: basicAuthenticator.Name})
+	certificate.Object["spec"] = map[string]interface{}{
+		"secretName": secretName,
+		"dnsNames":   dnsNames,
+		"issuerRef": map[string]interface{}{
+			"name":  certManager.IssuerRef.Name,
+			"kind":  issuerKind,
+			"group": issuerGroup,
+		},
+	}
+	return certificate
+}
+
+// ensureCertificate asks cert-manager for the authenticator's certificate when TLS is delegated to it
+func (r *BasicAuthenticatorReconciler) ensureCertificate(ctx context.Context, req ctrl.Request) (*ctrl.Result, error) {
+	basicAuthenticator := &v1alpha1.BasicAuthenticator{}
+
+	if r, err := r.getLatestBasicAuthenticator(ctx, req, basicAuthenticator); subreconciler.ShouldHaltOrRequeue(r, err) {
+		return subreconciler.RequeueWithError(err)
+	}
+	if basicAuthenticator.Spec.TLS == nil || basicAuthenticator.Spec.TLS.CertManager == nil {
+		return subreconciler.ContinueReconciling()
+	}
+
+	newCertificate := createCertificate(basicAuthenticator)
+	foundCertificate := &unstructured.Unstructured{}
+	foundCertificate.SetGroupVersionKind(certificateGVK)
+	err := r.Get(ctx, types.NamespacedName{Name: newCertificate.GetName(), Namespace: newCertificate.GetNamespace()}, foundCertificate)
+	if errors.IsNotFound(err) {
+		if err := ctrl.SetControllerReference(basicAuthenticator, newCertificate, r.Scheme); err != nil {
+			r.logger.Error(err, "failed to set certificate owner")
+			return subreconciler.RequeueWithError(err)
+		}
+		if err := r.Create(ctx, newCertificate); err != nil {
+			r.logger.Error(err, "failed to create certificate")
+			return subreconciler.RequeueWithError(err)
+		}
+	} else if err != nil {
+		r.logger.Error(err, "failed to fetch certificate")
+		return subreconciler.RequeueWithError(err)
+	} else if !metav1.IsControlledBy(foundCertificate, basicAuthenticator) {
+		return subreconciler.RequeueWithError(fmt.Errorf("certificate %s exists and is not managed by %s", foundCertificate.GetName(), basicAuthenticator.Name))
+	} else {
+		// cert-manager defaults some fields, so only the ones the controller sets are compared
+		if mergeUnstructuredSpec(foundCertificate, newCertificate) {
+			r.logger.Info("updating certificate")
+			if err := r.Update(ctx, foundCertificate); err != nil {
+				r.logger.Error(err, "failed to update certificate")
+				return subreconciler.RequeueWithError(err)
+			}
+		}
+	}
+	return subreconciler.ContinueReconciling()
+}
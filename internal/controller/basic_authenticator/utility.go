@@ -1,7 +1,12 @@
 package basic_authenticator
 
 import (
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
 	"github.com/snapp-incubator/simple-authenticator/internal/config"
+	"github.com/snapp-incubator/simple-authenticator/pkg/htpasswd"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 func getNginxContainerImage(customConfig *config.CustomConfig) string {
@@ -17,3 +22,53 @@ func getNginxContainerName(customConfig *config.CustomConfig) string {
 	}
 	return nginxDefaultContainerName
 }
+
+// getHashOptions prefers the basicAuthenticator's hashing settings over the operator wide ones
+func getHashOptions(basicAuthenticator *v1alpha1.BasicAuthenticator, customConfig *config.CustomConfig) htpasswd.HashOptions {
+	hashOptions := htpasswd.HashOptions{}
+	if customConfig != nil {
+		hashOptions.Algorithm = htpasswd.Algorithm(customConfig.HtpasswdConf.Algorithm)
+		hashOptions.BcryptCost = customConfig.HtpasswdConf.BcryptCost
+	}
+	if basicAuthenticator.Spec.HashAlgorithm != "" {
+		hashOptions.Algorithm = htpasswd.Algorithm(basicAuthenticator.Spec.HashAlgorithm)
+	}
+	if basicAuthenticator.Spec.BcryptCost != 0 {
+		hashOptions.BcryptCost = basicAuthenticator.Spec.BcryptCost
+	}
+	return hashOptions
+}
+
+func isDeploymentReady(deployment *appsv1.Deployment) bool {
+	if deployment.Status.ObservedGeneration < deployment.Generation {
+		return false
+	}
+	desiredReplicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		desiredReplicas = *deployment.Spec.Replicas
+	}
+	return deployment.Status.ReadyReplicas >= desiredReplicas
+}
+
+// mergeUnstructuredSpec copies the top level spec fields of desired into found, leaving the fields only
+// defaulted by the API server alone, and reports whether found changed. optionalFields are removed from
+// found when desired leaves them out.
+func mergeUnstructuredSpec(found, desired *unstructured.Unstructured, optionalFields ...string) bool {
+	foundSpec, _, _ := unstructured.NestedMap(found.Object, "spec")
+	desiredSpec, _, _ := unstructured.NestedMap(desired.Object, "spec")
+	merged := make(map[string]interface{}, len(foundSpec))
+	for key, value := range foundSpec {
+		merged[key] = value
+	}
+	for _, key := range optionalFields {
+		delete(merged, key)
+	}
+	for key, value := range desiredSpec {
+		merged[key] = value
+	}
+	if equality.Semantic.DeepEqual(merged, foundSpec) {
+		return false
+	}
+	found.Object["spec"] = merged
+	return true
+}
@@ -15,7 +15,6 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"strings"
 )
 
 // TODO: come up with better name that "nginx"
@@ -82,12 +81,7 @@ func createNginxDeployment(basicAuthenticator *v1alpha1.BasicAuthenticator, conf
 							VolumeSource: corev1.VolumeSource{
 								Secret: &corev1.SecretVolumeSource{
 									SecretName: credentialName,
-									Items: []corev1.KeyToPath{
-										{
-											Key:  SecretHtpasswdField,
-											Path: SecretHtpasswdField,
-										},
-									},
+									Items:      getSecretVolumeItems(basicAuthenticator),
 								},
 							},
 						},
@@ -96,15 +90,40 @@ func createNginxDeployment(basicAuthenticator *v1alpha1.BasicAuthenticator, conf
 			},
 		},
 	}
+	addTLSVolume(basicAuthenticator, &deploy.Spec.Template.Spec, &deploy.Spec.Template.Spec.Containers[0])
+	addMTLSVolume(basicAuthenticator, &deploy.Spec.Template.Spec, &deploy.Spec.Template.Spec.Containers[0])
+	hardenContainer(&deploy.Spec.Template.Spec.Containers[0], customConfig)
+	hardenPodSpec(&deploy.Spec.Template.Spec, customConfig)
+	addAuthServer(basicAuthenticator, &deploy.Spec.Template.Spec, customConfig)
+	applyContainerOverrides(&deploy.Spec.Template.Spec.Containers[0], basicAuthenticator, customConfig)
+	applyPodTemplateOverrides(&deploy.Spec.Template, basicAuthenticator, customConfig)
+	addTopologySpread(basicAuthenticator, &deploy.Spec.Template.Spec, basicAuthLabels)
+	addAutoscalingSupport(basicAuthenticator, &deploy.Spec.Template, &deploy.Spec.Template.Spec.Containers[0], customConfig)
 	return deploy
 }
 
-func createNginxConfigmap(basicAuthenticator *v1alpha1.BasicAuthenticator) *corev1.ConfigMap {
-	configmapName := random_generator.GenerateRandomName(basicAuthenticator.Name, "configmap")
+// addTLSVolume mounts the authenticator's certificate into container and exposes its redirect port
+func addTLSVolume(basicAuthenticator *v1alpha1.BasicAuthenticator, podSpec *corev1.PodSpec, container *corev1.Container) {
+	volume, mount := getTLSVolume(basicAuthenticator)
+	if volume == nil {
+		return
+	}
+	podSpec.Volumes = append(podSpec.Volumes, *volume)
+	container.VolumeMounts = append(container.VolumeMounts, *mount)
+	if redirectPort := basicAuthenticator.Spec.TLS.HTTPRedirectPort; redirectPort != 0 {
+		container.Ports = append(container.Ports, corev1.ContainerPort{ContainerPort: int32(redirectPort)})
+	}
+}
+
+func createNginxConfigmap(basicAuthenticator *v1alpha1.BasicAuthenticator) (*corev1.ConfigMap, error) {
+	configmapName := getNginxConfigmapName(basicAuthenticator)
 	basicAuthLabels := map[string]string{
 		basicAuthenticatorNameLabel: basicAuthenticator.Name,
 	}
-	nginxConf := fillTemplate(template, SecretMountPath, basicAuthenticator)
+	nginxConf, err := renderNginxConfig(basicAuthenticator)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to render nginx config")
+	}
 	data := map[string]string{
 		"nginx.conf": nginxConf,
 	}
@@ -116,10 +135,10 @@ func createNginxConfigmap(basicAuthenticator *v1alpha1.BasicAuthenticator) *core
 		},
 		Data: data,
 	}
-	return configMap
+	return configMap, nil
 }
 
-func updateHtpasswdField(secret *corev1.Secret) error {
+func updateHtpasswdField(secret *corev1.Secret, hashOptions htpasswd.HashOptions) error {
 	username, ok := secret.Data["username"]
 	if !ok {
 		return defaultError.New("username not found in secret")
@@ -128,26 +147,114 @@ func updateHtpasswdField(secret *corev1.Secret) error {
 	if !ok {
 		return defaultError.New("password not found in secret")
 	}
-	salt, err := random_generator.GenerateRandomString(8)
-	if err != nil {
-		return errors.Wrap(err, "failed to generate salt")
-	}
-	hashedPassword, err := htpasswd.ApacheHash(string(password), salt)
+	htpasswdFile := htpasswd.NewFile()
+	err := setHtpasswdUser(htpasswdFile, string(username), string(password), parseHtpasswd(secret.Data[SecretHtpasswdField]), hashOptions)
 	if err != nil {
 		return err
 	}
-	htpasswdString := fmt.Sprintf("%s:%s", string(username), hashedPassword)
-	secret.Data["htpasswd"] = []byte(htpasswdString)
+	// credentials replaced by a rotation stay valid until their grace period is over
+	for _, previousEntry := range parseHtpasswd(secret.Data[SecretPreviousHtpasswdField]).Entries() {
+		if _, exists := htpasswdFile.Get(previousEntry.Username); !exists {
+			if err := htpasswdFile.Set(previousEntry.Username, previousEntry.Hash); err != nil {
+				return err
+			}
+		}
+	}
+	secret.Data["htpasswd"] = htpasswdFile.Bytes()
 	return nil
 }
-func createCredentials(basicAuthenticator *v1alpha1.BasicAuthenticator) (*corev1.Secret, error) {
+
+// mergeHtpasswd builds a single htpasswd file out of credential secrets, keeping the hashes
+// found in existingHtpasswd as long as they still match their passwords
+func mergeHtpasswd(credentials []*corev1.Secret, existingHtpasswd []byte, hashOptions htpasswd.HashOptions) ([]byte, error) {
+	existingFile := parseHtpasswd(existingHtpasswd)
+	seenUsers := make(map[string]string)
+	htpasswdFile := htpasswd.NewFile()
+	for _, secret := range credentials {
+		username, ok := secret.Data["username"]
+		if !ok {
+			return nil, fmt.Errorf("username not found in secret %s", secret.Name)
+		}
+		password, ok := secret.Data["password"]
+		if !ok {
+			return nil, fmt.Errorf("password not found in secret %s", secret.Name)
+		}
+		if owner, exists := seenUsers[string(username)]; exists {
+			return nil, fmt.Errorf("username %s is defined in both %s and %s", string(username), owner, secret.Name)
+		}
+		seenUsers[string(username)] = secret.Name
+		if err := setHtpasswdUser(htpasswdFile, string(username), string(password), existingFile, hashOptions); err != nil {
+			return nil, errors.Wrapf(err, "invalid credentials in secret %s", secret.Name)
+		}
+	}
+	return htpasswdFile.Bytes(), nil
+}
+
+// setHtpasswdUser stores username in htpasswdFile, reusing its hash from existingFile if it still
+// matches password and was produced with hashOptions
+func setHtpasswdUser(htpasswdFile *htpasswd.File, username, password string, existingFile *htpasswd.File, hashOptions htpasswd.HashOptions) error {
+	if entry, ok := existingFile.Get(username); ok && hashOptions.Matches(entry.Hash) && entry.Verify(password) {
+		return htpasswdFile.Set(username, entry.Hash)
+	}
+	if err := htpasswdFile.SetPassword(username, password, hashOptions); err != nil {
+		return errors.Wrap(err, "failed to hash password")
+	}
+	return nil
+}
+
+// parseHtpasswd parses a previously generated htpasswd field, an unreadable one is treated as empty
+func parseHtpasswd(htpasswdField []byte) *htpasswd.File {
+	htpasswdFile, err := htpasswd.Parse(htpasswdField)
+	if err != nil {
+		return htpasswd.NewFile()
+	}
+	return htpasswdFile
+}
+
+func getCredentialsSecretRefs(basicAuthenticator *v1alpha1.BasicAuthenticator) []string {
+	secretRefs := make([]string, 0, len(basicAuthenticator.Spec.CredentialsSecretRefs)+1)
+	if basicAuthenticator.Spec.CredentialsSecretRef != "" {
+		secretRefs = append(secretRefs, basicAuthenticator.Spec.CredentialsSecretRef)
+	}
+	for _, secretName := range basicAuthenticator.Spec.CredentialsSecretRefs {
+		if !existsInList(secretRefs, secretName) {
+			secretRefs = append(secretRefs, secretName)
+		}
+	}
+	return secretRefs
+}
+
+func createMergedCredentials(basicAuthenticator *v1alpha1.BasicAuthenticator) *corev1.Secret {
+	basicAuthLabels := map[string]string{
+		basicAuthenticatorNameLabel: basicAuthenticator.Name,
+	}
+	secretName := getMergedCredentialsName(basicAuthenticator)
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: basicAuthenticator.Namespace,
+			Labels:    basicAuthLabels,
+		},
+		Data: map[string][]byte{},
+	}
+}
+
+func generateCredentials() (string, string, error) {
 	username, err := random_generator.GenerateRandomString(20)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to generate username")
+		return "", "", errors.Wrap(err, "failed to generate username")
 	}
 	password, err := random_generator.GenerateRandomString(20)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to generate password")
+		return "", "", errors.Wrap(err, "failed to generate password")
+	}
+	return username, password, nil
+}
+
+func createCredentials(basicAuthenticator *v1alpha1.BasicAuthenticator) (*corev1.Secret, error) {
+	username, password, err := generateCredentials()
+	if err != nil {
+		return nil, err
 	}
 	salt, err := random_generator.GenerateRandomString(10)
 	if err != nil {
@@ -195,87 +302,47 @@ func createNginxService(ctx context.Context, basicAuthenticator *v1alpha1.BasicA
 			},
 		},
 	}
+	if tls := basicAuthenticator.Spec.TLS; tls != nil && tls.HTTPRedirectPort != 0 {
+		svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{
+			Port:       int32(tls.HTTPRedirectPort),
+			TargetPort: intstr.IntOrString{Type: intstr.Int, IntVal: int32(tls.HTTPRedirectPort)},
+			Name:       "http-redirect",
+		})
+	}
 	return &svc
 }
-func injector(ctx context.Context, basicAuthenticator *v1alpha1.BasicAuthenticator, configMapName string, credentialName string, customConfig *config.CustomConfig, k8Client client.Client) ([]*appsv1.Deployment, error) {
-	nginxImageAddress := getNginxContainerImage(customConfig)
-	nginxContainerName := getNginxContainerName(customConfig)
 
-	authenticatorPort := int32(basicAuthenticator.Spec.AuthenticatorPort)
-	var deploymentList appsv1.DeploymentList
-	if err := k8Client.List(
-		ctx,
-		&deploymentList,
-		client.MatchingLabelsSelector{Selector: labels.SelectorFromSet(basicAuthenticator.Spec.Selector.MatchLabels)},
-		client.InNamespace(basicAuthenticator.Namespace)); err != nil {
+// injector injects the sidecar into the workloads selected by basicAuthenticator and returns the ones that changed
+func injector(ctx context.Context, basicAuthenticator *v1alpha1.BasicAuthenticator, configMapName string, credentialName string, configHash string, customConfig *config.CustomConfig, k8Client client.Client) ([]client.Object, error) {
+	workloads, err := listTargetWorkloads(ctx, k8Client, basicAuthenticator.Namespace, labels.SelectorFromSet(basicAuthenticator.Spec.Selector.MatchLabels))
+	if err != nil {
 		return nil, err
 	}
-	resultDeployments := make([]*appsv1.Deployment, 0)
 
-	for _, deployment := range deploymentList.Items {
-		if deployment.Labels == nil {
-			deployment.Labels = make(map[string]string)
+	changedWorkloads := make([]client.Object, 0)
+	for _, workload := range workloads {
+		changed := false
+		workloadLabels := workload.GetLabels()
+		if workloadLabels == nil {
+			workloadLabels = make(map[string]string)
+		}
+		if workloadLabels[basicAuthenticatorNameLabel] != basicAuthenticator.Name {
+			workloadLabels[basicAuthenticatorNameLabel] = basicAuthenticator.Name
+			workload.SetLabels(workloadLabels)
+			changed = true
+		}
+		podTemplate := getPodTemplate(workload)
+		if InjectSidecar(&podTemplate.ObjectMeta, &podTemplate.Spec, basicAuthenticator, configMapName, credentialName, customConfig) {
+			changed = true
+		}
+		if setConfigHash(&podTemplate.ObjectMeta, configHash) {
+			changed = true
+		}
+		if changed {
+			changedWorkloads = append(changedWorkloads, workload)
 		}
-		deployment.Labels[basicAuthenticatorNameLabel] = basicAuthenticator.Name
-		idx := getContainerIndex(deployment.Spec.Template.Spec.Containers, nginxContainerName)
-		if idx == -1 { // meaning its the first time creating container
-			deployment.Spec.Template.Spec.Containers = append(deployment.Spec.Template.Spec.Containers, corev1.Container{
-				Name:  nginxContainerName,
-				Image: nginxImageAddress,
-				Ports: []corev1.ContainerPort{
-					{
-						ContainerPort: authenticatorPort,
-					},
-				},
-				VolumeMounts: []corev1.VolumeMount{
-					{
-						Name:      configMapName,
-						MountPath: ConfigMountPath,
-					},
-					{
-						Name:      credentialName,
-						MountPath: SecretMountDir,
-					},
-				},
-			})
-			deployment.Spec.Template.Spec.Volumes = append(deployment.Spec.Template.Spec.Volumes, corev1.Volume{
-				Name: configMapName,
-				VolumeSource: corev1.VolumeSource{
-					ConfigMap: &corev1.ConfigMapVolumeSource{
-						LocalObjectReference: corev1.LocalObjectReference{
-							Name: configMapName,
-						},
-					},
-				},
-			})
-			deployment.Spec.Template.Spec.Volumes = append(deployment.Spec.Template.Spec.Volumes, corev1.Volume{
-				Name: credentialName,
-				VolumeSource: corev1.VolumeSource{
-					Secret: &corev1.SecretVolumeSource{
-						SecretName: credentialName,
-					},
-				},
-			})
-		} //TODO: handling config change later (idx >=0)
-
-		resultDeployments = append(resultDeployments, &deployment)
 	}
-	return resultDeployments, nil
-}
-
-func fillTemplate(template string, secretPath string, authenticator *v1alpha1.BasicAuthenticator) string {
-	var result string
-	var appservice string
-	if authenticator.Spec.Type == "sidecar" {
-		appservice = "localhost"
-	} else {
-		appservice = authenticator.Spec.AppService
-	}
-	result = strings.Replace(template, "AUTHENTICATOR_PORT", fmt.Sprintf("%d", authenticator.Spec.AuthenticatorPort), 1)
-	result = strings.Replace(result, "FILE_PATH", secretPath, 1)
-	result = strings.Replace(result, "APP_SERVICE", appservice, 1)
-	result = strings.Replace(result, "APP_PORT", fmt.Sprintf("%d", authenticator.Spec.AppPort), 1)
-	return result
+	return changedWorkloads, nil
 }
 
 func getServiceType(serviceType string) corev1.ServiceType {
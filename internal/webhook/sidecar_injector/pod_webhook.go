@@ -0,0 +1,119 @@
+package sidecar_injector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	"github.com/snapp-incubator/simple-authenticator/internal/config"
+	"github.com/snapp-incubator/simple-authenticator/internal/controller/basic_authenticator"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sort"
+)
+
+const (
+	// WebhookPath is where the pod mutating webhook is served
+	WebhookPath = "/mutate-v1-pod"
+	// InjectionNamespaceLabel opts a namespace in to the pod webhook, whose namespaceSelector in config/webhook
+	// only sends it pods of namespaces labeled with InjectionNamespaceLabel=enabled
+	InjectionNamespaceLabel = "basicauthenticator.snappcloud.io/pod-injection"
+)
+
+var podinjectorlog = logf.Log.WithName("pod-sidecar-injector")
+
+//+kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,failurePolicy=ignore,sideEffects=None,groups="",resources=pods,verbs=create,versions=v1,name=mpod.basicauthenticator.snappcloud.io,admissionReviewVersions=v1
+//+kubebuilder:rbac:groups=authenticator.snappcloud.io,resources=basicauthenticators,verbs=get;list;watch
+
+// PodSidecarInjector injects the nginx sidecar into pods selected by a BasicAuthenticator using the
+// PodWebhook injection mode, so the workloads owning them are never modified
+type PodSidecarInjector struct {
+	Client       client.Client
+	CustomConfig *config.CustomConfig
+	Decoder      *admission.Decoder
+}
+
+var _ admission.Handler = &PodSidecarInjector{}
+
+// Handle implements admission.Handler
+func (i *PodSidecarInjector) Handle(ctx context.Context, req admission.Request) admission.Response {
+	pod := &corev1.Pod{}
+	if err := i.Decoder.Decode(req, pod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if _, injected := pod.Annotations[basic_authenticator.InjectedAnnotation]; injected {
+		return admission.Allowed("sidecar already injected")
+	}
+
+	basicAuthenticators, err := i.findSelectingBasicAuthenticators(ctx, req.Namespace, pod)
+	if err != nil {
+		podinjectorlog.Error(err, "failed to list basic authenticators", "namespace", req.Namespace)
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if len(basicAuthenticators) == 0 {
+		return admission.Allowed("no basic authenticator selects the pod")
+	}
+
+	warnings := make([]string, 0)
+	basicAuthenticator := basicAuthenticators[0]
+	if len(basicAuthenticators) > 1 {
+		warnings = append(warnings, fmt.Sprintf("pod is selected by %d basic authenticators, only %s is injected", len(basicAuthenticators), basicAuthenticator.Name))
+	}
+	configMapName, credentialName := basic_authenticator.GetSidecarResourceNames(basicAuthenticator)
+	if credentialName == "" {
+		warnings = append(warnings, fmt.Sprintf("credentials of basic authenticator %s are not provisioned yet, sidecar not injected", basicAuthenticator.Name))
+		return admission.Allowed("credentials not provisioned").WithWarnings(warnings...)
+	}
+	basic_authenticator.InjectSidecar(&pod.ObjectMeta, &pod.Spec, basicAuthenticator, configMapName, credentialName, i.CustomConfig)
+	pod.Annotations[basic_authenticator.InjectedAnnotation] = basicAuthenticator.Name
+	// the controller rolls pods injected with an outdated configuration
+	if configHash := basicAuthenticator.Status.ConfigHash; configHash != "" {
+		pod.Annotations[basic_authenticator.ConfigHashAnnotation] = configHash
+	}
+
+	marshaledPod, err := json.Marshal(pod)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	podinjectorlog.Info("injecting sidecar", "namespace", req.Namespace, "basicAuthenticator", basicAuthenticator.Name)
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod).WithWarnings(warnings...)
+}
+
+// findSelectingBasicAuthenticators returns the BasicAuthenticators of namespace injecting pod through the webhook, sorted by name
+func (i *PodSidecarInjector) findSelectingBasicAuthenticators(ctx context.Context, namespace string, pod *corev1.Pod) ([]*v1alpha1.BasicAuthenticator, error) {
+	var basicAuthenticatorList v1alpha1.BasicAuthenticatorList
+	if err := i.Client.List(ctx, &basicAuthenticatorList, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	result := make([]*v1alpha1.BasicAuthenticator, 0)
+	for idx := range basicAuthenticatorList.Items {
+		basicAuthenticator := &basicAuthenticatorList.Items[idx]
+		if basicAuthenticator.Spec.Type != "sidecar" || basicAuthenticator.Spec.InjectionMode != v1alpha1.InjectionModePodWebhook {
+			continue
+		}
+		if basicAuthenticator.DeletionTimestamp != nil {
+			continue
+		}
+		// an empty selector would inject every pod of the namespace
+		if len(basicAuthenticator.Spec.Selector.MatchLabels) == 0 && len(basicAuthenticator.Spec.Selector.MatchExpressions) == 0 {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(&basicAuthenticator.Spec.Selector)
+		if err != nil {
+			podinjectorlog.Error(err, "invalid selector", "basicAuthenticator", basicAuthenticator.Name)
+			continue
+		}
+		if selector.Matches(labels.Set(pod.Labels)) {
+			result = append(result, basicAuthenticator)
+		}
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].Name < result[b].Name
+	})
+	return result, nil
+}
@@ -0,0 +1,184 @@
+package sidecar_injector
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/snapp-incubator/simple-authenticator/api/v1alpha1"
+	"github.com/snapp-incubator/simple-authenticator/internal/config"
+	"github.com/snapp-incubator/simple-authenticator/internal/controller/basic_authenticator"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"strings"
+	"testing"
+)
+
+const testNamespace = "apps"
+
+func newTestBasicAuthenticator(name string, matchLabels map[string]string, credentialsSecretRef string) *v1alpha1.BasicAuthenticator {
+	return &v1alpha1.BasicAuthenticator{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Spec: v1alpha1.BasicAuthenticatorSpec{
+			Type:                 "sidecar",
+			InjectionMode:        v1alpha1.InjectionModePodWebhook,
+			Selector:             metav1.LabelSelector{MatchLabels: matchLabels},
+			AppPort:              8080,
+			AuthenticatorPort:    8081,
+			CredentialsSecretRef: credentialsSecretRef,
+		},
+		Status: v1alpha1.BasicAuthenticatorStatus{ConfigHash: name + "-hash"},
+	}
+}
+
+func newTestInjector(t *testing.T, basicAuthenticators ...*v1alpha1.BasicAuthenticator) *PodSidecarInjector {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	objects := make([]client.Object, 0, len(basicAuthenticators))
+	for _, basicAuthenticator := range basicAuthenticators {
+		objects = append(objects, basicAuthenticator)
+	}
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &PodSidecarInjector{
+		Client:       fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		CustomConfig: &config.CustomConfig{},
+		Decoder:      decoder,
+	}
+}
+
+func newPodRequest(t *testing.T, podLabels, podAnnotations map[string]string) admission.Request {
+	pod := &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: testNamespace, Labels: podLabels, Annotations: podAnnotations},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app", Image: "app:latest"}},
+		},
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Namespace: testNamespace,
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+}
+
+func TestHandle(t *testing.T) {
+	appLabels := map[string]string{"app": "payments"}
+	tests := []struct {
+		name                string
+		basicAuthenticators []*v1alpha1.BasicAuthenticator
+		podAnnotations      map[string]string
+		wantInjected        string
+		wantWarning         string
+	}{
+		{
+			name:                "matching selector",
+			basicAuthenticators: []*v1alpha1.BasicAuthenticator{newTestBasicAuthenticator("payments-auth", appLabels, "payments-credentials")},
+			wantInjected:        "payments-auth",
+		},
+		{
+			name:                "other selector",
+			basicAuthenticators: []*v1alpha1.BasicAuthenticator{newTestBasicAuthenticator("billing-auth", map[string]string{"app": "billing"}, "billing-credentials")},
+		},
+		{
+			name:                "empty selector",
+			basicAuthenticators: []*v1alpha1.BasicAuthenticator{newTestBasicAuthenticator("everything-auth", nil, "everything-credentials")},
+		},
+		{
+			name: "multiple matches",
+			basicAuthenticators: []*v1alpha1.BasicAuthenticator{
+				newTestBasicAuthenticator("payments-b", appLabels, "b-credentials"),
+				newTestBasicAuthenticator("payments-a", appLabels, "a-credentials"),
+			},
+			wantInjected: "payments-a",
+			wantWarning:  "selected by 2 basic authenticators, only payments-a is injected",
+		},
+		{
+			name:                "credentials not provisioned",
+			basicAuthenticators: []*v1alpha1.BasicAuthenticator{newTestBasicAuthenticator("payments-auth", appLabels, "")},
+			wantWarning:         "credentials of basic authenticator payments-auth are not provisioned yet",
+		},
+		{
+			name:                "already injected",
+			basicAuthenticators: []*v1alpha1.BasicAuthenticator{newTestBasicAuthenticator("payments-auth", appLabels, "payments-credentials")},
+			podAnnotations:      map[string]string{basic_authenticator.InjectedAnnotation: "payments-auth"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			injector := newTestInjector(t, test.basicAuthenticators...)
+			response := injector.Handle(context.Background(), newPodRequest(t, appLabels, test.podAnnotations))
+			if !response.Allowed {
+				t.Fatalf("Handle() denied the pod: %v", response.Result)
+			}
+			patches, err := json.Marshal(response.Patches)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.wantInjected == "" {
+				if len(response.Patches) != 0 {
+					t.Errorf("Handle() patched the pod: %s", patches)
+				}
+			} else {
+				for _, want := range []string{`"name":"nginx"`, `"` + basic_authenticator.InjectedAnnotation + `":"` + test.wantInjected + `"`, `"` + basic_authenticator.ConfigHashAnnotation + `":"` + test.wantInjected + `-hash"`} {
+					if !strings.Contains(string(patches), want) {
+						t.Errorf("Handle() patches = %s, want them to contain %s", patches, want)
+					}
+				}
+			}
+			if test.wantWarning == "" {
+				if len(response.Warnings) != 0 {
+					t.Errorf("Handle() warnings = %v, want none", response.Warnings)
+				}
+			} else if len(response.Warnings) != 1 || !strings.Contains(response.Warnings[0], test.wantWarning) {
+				t.Errorf("Handle() warnings = %v, want one containing %q", response.Warnings, test.wantWarning)
+			}
+		})
+	}
+}
+
+func TestFindSelectingBasicAuthenticators(t *testing.T) {
+	appLabels := map[string]string{"app": "payments", "tier": "backend"}
+	matching := newTestBasicAuthenticator("matching", map[string]string{"app": "payments"}, "credentials")
+	expression := newTestBasicAuthenticator("expression", nil, "credentials")
+	expression.Spec.Selector.MatchExpressions = []metav1.LabelSelectorRequirement{
+		{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"backend"}},
+	}
+	workloadMode := newTestBasicAuthenticator("workload-mode", map[string]string{"app": "payments"}, "credentials")
+	workloadMode.Spec.InjectionMode = v1alpha1.InjectionModeWorkload
+	deploymentType := newTestBasicAuthenticator("deployment-type", map[string]string{"app": "payments"}, "credentials")
+	deploymentType.Spec.Type = "deployment"
+	otherNamespace := newTestBasicAuthenticator("other-namespace", map[string]string{"app": "payments"}, "credentials")
+	otherNamespace.Namespace = "other"
+	emptySelector := newTestBasicAuthenticator("empty-selector", nil, "credentials")
+	otherLabels := newTestBasicAuthenticator("other-labels", map[string]string{"app": "billing"}, "credentials")
+
+	injector := newTestInjector(t, matching, expression, workloadMode, deploymentType, otherNamespace, emptySelector, otherLabels)
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: testNamespace, Labels: appLabels}}
+	basicAuthenticators, err := injector.findSelectingBasicAuthenticators(context.Background(), testNamespace, pod)
+	if err != nil {
+		t.Fatalf("findSelectingBasicAuthenticators() returned error: %v", err)
+	}
+	names := make([]string, 0, len(basicAuthenticators))
+	for _, basicAuthenticator := range basicAuthenticators {
+		names = append(names, basicAuthenticator.Name)
+	}
+	if want := []string{"expression", "matching"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("findSelectingBasicAuthenticators() = %v, want %v", names, want)
+	}
+}
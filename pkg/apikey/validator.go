@@ -0,0 +1,100 @@
+package apikey
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// AuthorizationHeader carries keys with the Bearer scheme, any other header carries the bare key
+	AuthorizationHeader = "Authorization"
+	bearerPrefix        = "bearer "
+)
+
+// Hash returns the hex encoded SHA-256 hash keys are stored as. Keys are random, so unlike passwords
+// they need no salt or slow hash.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// FormatEntry returns the line of a key file storing key under name
+func FormatEntry(name, key string) string {
+	return fmt.Sprintf("%s:%s\n", name, Hash(key))
+}
+
+// Validator checks the key of a request's header against a file of hashed keys, one name:hash per line
+type Validator struct {
+	header string
+	path   string
+
+	mu      sync.Mutex
+	modTime time.Time
+	entries map[string]string
+}
+
+// NewValidator returns a Validator reading the keys of path, reloading it whenever it changes
+func NewValidator(header, path string) *Validator {
+	return &Validator{header: http.CanonicalHeaderKey(header), path: path}
+}
+
+// AuthenticateRequest returns the name of the request's key, if it carries a known one
+func (v *Validator) AuthenticateRequest(r *http.Request) (string, bool) {
+	key := r.Header.Get(v.header)
+	if v.header == AuthorizationHeader {
+		if len(key) <= len(bearerPrefix) || !strings.EqualFold(key[:len(bearerPrefix)], bearerPrefix) {
+			return "", false
+		}
+		key = strings.TrimSpace(key[len(bearerPrefix):])
+	}
+	if key == "" {
+		return "", false
+	}
+	entries, err := v.load()
+	if err != nil {
+		return "", false
+	}
+	hash := Hash(key)
+	var name string
+	for entryName, entryHash := range entries {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(entryHash)) == 1 {
+			name = entryName
+		}
+	}
+	return name, name != ""
+}
+
+func (v *Validator) load() (map[string]string, error) {
+	info, err := os.Stat(v.path)
+	if err != nil {
+		return nil, err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.entries != nil && info.ModTime().Equal(v.modTime) {
+		return v.entries, nil
+	}
+	content, err := os.ReadFile(v.path)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		name, hash, found := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if found && hash != "" {
+			entries[name] = hash
+		}
+	}
+	v.entries, v.modTime = entries, info.ModTime()
+	return entries, nil
+}
@@ -0,0 +1,122 @@
+package apikey
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	ciKey     = "ci-secret-key"
+	deployKey = "deploy-secret-key"
+)
+
+func writeKeys(t *testing.T, path string, content string, modTime time.Time) {
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func authenticate(validator *Validator, header, value string) (string, bool) {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	if value != "" {
+		request.Header.Set(header, value)
+	}
+	return validator.AuthenticateRequest(request)
+}
+
+func TestHash(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "", want: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{key: "abc", want: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+	}
+	for _, test := range tests {
+		if got := Hash(test.key); got != test.want {
+			t.Errorf("Hash(%q) = %s, want %s", test.key, got, test.want)
+		}
+	}
+}
+
+func TestFormatEntry(t *testing.T) {
+	want := "ci:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad\n"
+	if got := FormatEntry("ci", "abc"); got != want {
+		t.Errorf("FormatEntry() = %q, want %q", got, want)
+	}
+}
+
+func TestAuthenticateRequest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	writeKeys(t, path, "# generated\n\n"+FormatEntry("ci", ciKey)+"  "+FormatEntry("deploy", deployKey)+"broken\nempty:\n", time.Now())
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   string
+	}{
+		{name: "bearer key", header: "Authorization", value: "Bearer " + ciKey, want: "ci"},
+		{name: "lower case scheme", header: "Authorization", value: "bearer " + deployKey, want: "deploy"},
+		{name: "upper case scheme", header: "Authorization", value: "BEARER " + ciKey, want: "ci"},
+		{name: "surrounding spaces", header: "Authorization", value: "Bearer  " + ciKey + " ", want: "ci"},
+		{name: "bare key in authorization", header: "Authorization", value: ciKey, want: ""},
+		{name: "basic scheme", header: "Authorization", value: "Basic " + ciKey, want: ""},
+		{name: "empty bearer key", header: "Authorization", value: "Bearer  ", want: ""},
+		{name: "scheme only", header: "Authorization", value: "Bearer", want: ""},
+		{name: "no header", header: "Authorization", value: "", want: ""},
+		{name: "unknown key", header: "Authorization", value: "Bearer unknown", want: ""},
+		{name: "key of an entry without hash", header: "Authorization", value: "Bearer empty", want: ""},
+		{name: "bare key", header: "x-api-key", value: ciKey, want: "ci"},
+		{name: "bearer prefix in another header", header: "x-api-key", value: "Bearer " + ciKey, want: ""},
+		{name: "empty bare key", header: "x-api-key", value: "", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			validator := NewValidator(test.header, path)
+			name, ok := authenticate(validator, test.header, test.value)
+			if ok != (test.want != "") || name != test.want {
+				t.Errorf("AuthenticateRequest() = %q, %v, want %q", name, ok, test.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticateRequestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	modTime := time.Now().Add(-time.Hour)
+	writeKeys(t, path, FormatEntry("ci", ciKey), modTime)
+	validator := NewValidator(AuthorizationHeader, path)
+
+	if name, ok := authenticate(validator, AuthorizationHeader, "Bearer "+ciKey); !ok || name != "ci" {
+		t.Fatalf("AuthenticateRequest() = %q, %v, want ci", name, ok)
+	}
+
+	// the file is only read again once its modification time changes
+	writeKeys(t, path, FormatEntry("deploy", deployKey), modTime)
+	if name, ok := authenticate(validator, AuthorizationHeader, "Bearer "+ciKey); !ok || name != "ci" {
+		t.Errorf("AuthenticateRequest() = %q, %v, want the cached ci key", name, ok)
+	}
+
+	writeKeys(t, path, FormatEntry("deploy", deployKey), modTime.Add(time.Minute))
+	if _, ok := authenticate(validator, AuthorizationHeader, "Bearer "+ciKey); ok {
+		t.Errorf("AuthenticateRequest() accepted a revoked key")
+	}
+	if name, ok := authenticate(validator, AuthorizationHeader, "Bearer "+deployKey); !ok || name != "deploy" {
+		t.Errorf("AuthenticateRequest() = %q, %v, want deploy", name, ok)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := authenticate(validator, AuthorizationHeader, "Bearer "+deployKey); ok {
+		t.Errorf("AuthenticateRequest() accepted a key without a key file")
+	}
+}
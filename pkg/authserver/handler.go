@@ -0,0 +1,119 @@
+package authserver
+
+import (
+	"fmt"
+	"github.com/go-logr/logr"
+	"net/http"
+)
+
+const (
+	// AuthPath answers nginx's auth_request subrequests
+	AuthPath = "/auth"
+	// LoginPath prefixes the endpoints of an interactive login, such as OIDC's
+	LoginPath = "/login/"
+	// HealthPath reports whether the server is up, without checking the credential source
+	HealthPath = "/healthz"
+	// UserHeader carries the authenticated username back to nginx
+	UserHeader = "X-Auth-User"
+)
+
+// CredentialValidator checks the username and password of a Basic Authorization header
+type CredentialValidator interface {
+	Authenticate(username, password string) (bool, error)
+}
+
+// RequestValidator checks credentials of a request other than Basic ones, e.g. a session cookie or an API key
+type RequestValidator interface {
+	// AuthenticateRequest returns the user of the request's credentials, if it carries valid ones
+	AuthenticateRequest(r *http.Request) (string, bool)
+}
+
+// HeaderValidator is implemented by RequestValidators passing details of the credentials upstream, such
+// as the claims of a token
+type HeaderValidator interface {
+	// AuthenticateRequestHeaders is AuthenticateRequest, also returning the headers nginx passes upstream
+	AuthenticateRequestHeaders(r *http.Request) (string, http.Header, bool)
+}
+
+// Options are the credential sources the auth server checks requests against. Either of them
+// authenticates a request, once Required accepted it.
+type Options struct {
+	// Realm is the realm of the Basic authentication challenge
+	Realm string
+	// Credentials checks Basic credentials, nil if nginx checks them itself
+	Credentials CredentialValidator
+	// Requests check the other credentials of a request, such as sessions established through Login
+	Requests []RequestValidator
+	// Required must accept every request before the others are checked, such as a client certificate
+	// combined with Basic credentials. It authenticates the request alone without other sources.
+	Required RequestValidator
+	// Login serves LoginPath, redirecting users without a session to their identity provider
+	Login http.Handler
+}
+
+// NewHandler serves the auth_request endpoint answering 200 for valid credentials and 401 otherwise, or
+// 403 if Required rejects the request. A failing credential source is answered with 503, which nginx
+// turns into a 500.
+func NewHandler(options Options, logger logr.Logger) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(HealthPath, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	if options.Login != nil {
+		mux.Handle(LoginPath, options.Login)
+	}
+	mux.HandleFunc(AuthPath, func(w http.ResponseWriter, r *http.Request) {
+		if options.Required != nil {
+			user, ok := options.Required.AuthenticateRequest(r)
+			if !ok {
+				// no password makes up for it, so there is no challenge
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if options.Credentials == nil && len(options.Requests) == 0 {
+				w.Header().Set(UserHeader, user)
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+		if username, password, ok := r.BasicAuth(); ok && options.Credentials != nil {
+			authenticated, err := options.Credentials.Authenticate(username, password)
+			if err != nil {
+				logger.Error(err, "failed to check credentials", "username", username)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if authenticated {
+				w.Header().Set(UserHeader, username)
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+		for _, validator := range options.Requests {
+			var user string
+			var headers http.Header
+			var ok bool
+			if headerValidator, isHeaderValidator := validator.(HeaderValidator); isHeaderValidator {
+				user, headers, ok = headerValidator.AuthenticateRequestHeaders(r)
+			} else {
+				user, ok = validator.AuthenticateRequest(r)
+			}
+			if ok {
+				for name, values := range headers {
+					w.Header()[name] = values
+				}
+				w.Header().Set(UserHeader, user)
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+		Unauthorized(w, options.Realm)
+	})
+	return mux
+}
+
+// Unauthorized answers with a Basic authentication challenge
+func Unauthorized(w http.ResponseWriter, realm string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
+	w.WriteHeader(http.StatusUnauthorized)
+}
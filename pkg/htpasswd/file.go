@@ -0,0 +1,171 @@
+package htpasswd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Entry is a single "username:hash" line of an htpasswd file
+type Entry struct {
+	Username string
+	Hash     string
+}
+
+// Algorithm returns the algorithm the entry's hash was produced with
+func (e Entry) Algorithm() (Algorithm, bool) {
+	return DetectAlgorithm(e.Hash)
+}
+
+// Verify reports whether pass matches the entry's hash
+func (e Entry) Verify(pass string) bool {
+	return Verify(pass, e.Hash)
+}
+
+func (e Entry) String() string {
+	return fmt.Sprintf("%s:%s", e.Username, e.Hash)
+}
+
+// File is an htpasswd file holding any number of users, kept in insertion order
+type File struct {
+	entries []Entry
+}
+
+// NewFile returns an empty htpasswd file
+func NewFile() *File {
+	return &File{}
+}
+
+// Parse reads an htpasswd file. Blank lines and lines starting with '#' are skipped;
+// malformed lines and users defined twice are reported with their line number.
+func Parse(data []byte) (*File, error) {
+	file := NewFile()
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		username, hash, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected \"username:hash\"", lineNumber)
+		}
+		if err := validateUsername(username); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if hash == "" {
+			return nil, fmt.Errorf("line %d: empty hash for user %s", lineNumber, username)
+		}
+		if _, exists := file.Get(username); exists {
+			return nil, fmt.Errorf("line %d: user %s is defined more than once", lineNumber, username)
+		}
+		file.entries = append(file.entries, Entry{Username: username, Hash: hash})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Entries returns a copy of the file's entries
+func (f *File) Entries() []Entry {
+	entries := make([]Entry, len(f.entries))
+	copy(entries, f.entries)
+	return entries
+}
+
+// Users returns the usernames in the file
+func (f *File) Users() []string {
+	users := make([]string, 0, len(f.entries))
+	for _, entry := range f.entries {
+		users = append(users, entry.Username)
+	}
+	return users
+}
+
+// Len returns the number of users in the file
+func (f *File) Len() int {
+	return len(f.entries)
+}
+
+// Get returns the entry of username
+func (f *File) Get(username string) (Entry, bool) {
+	if idx := f.index(username); idx >= 0 {
+		return f.entries[idx], true
+	}
+	return Entry{}, false
+}
+
+// Set adds username with an already hashed password, replacing the user if it exists
+func (f *File) Set(username, hash string) error {
+	if err := validateUsername(username); err != nil {
+		return err
+	}
+	if hash == "" || strings.ContainsAny(hash, ":\r\n") {
+		return fmt.Errorf("invalid hash for user %s", username)
+	}
+	entry := Entry{Username: username, Hash: hash}
+	if idx := f.index(username); idx >= 0 {
+		f.entries[idx] = entry
+		return nil
+	}
+	f.entries = append(f.entries, entry)
+	return nil
+}
+
+// SetPassword hashes pass with hashOptions and stores it for username, replacing the user if it exists
+func (f *File) SetPassword(username, pass string, hashOptions HashOptions) error {
+	hash, err := hashOptions.Hash(pass)
+	if err != nil {
+		return err
+	}
+	return f.Set(username, hash)
+}
+
+// Remove deletes username, reporting whether it existed
+func (f *File) Remove(username string) bool {
+	idx := f.index(username)
+	if idx < 0 {
+		return false
+	}
+	f.entries = append(f.entries[:idx], f.entries[idx+1:]...)
+	return true
+}
+
+// Verify reports whether pass is the password of username
+func (f *File) Verify(username, pass string) bool {
+	entry, ok := f.Get(username)
+	return ok && entry.Verify(pass)
+}
+
+// Bytes serializes the file, one entry per line
+func (f *File) Bytes() []byte {
+	var buffer bytes.Buffer
+	for _, entry := range f.entries {
+		buffer.WriteString(entry.String())
+		buffer.WriteByte('\n')
+	}
+	return buffer.Bytes()
+}
+
+func (f *File) index(username string) int {
+	for idx, entry := range f.entries {
+		if entry.Username == username {
+			return idx
+		}
+	}
+	return -1
+}
+
+func validateUsername(username string) error {
+	if username == "" {
+		return fmt.Errorf("empty username")
+	}
+	if strings.ContainsAny(username, ": \t\r\n") {
+		return fmt.Errorf("username %q must not contain colons or whitespace", username)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package htpasswd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	aliceHash = "$apr1$salt$hash"
+	bobHash   = "$2y$10$hash"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []Entry
+		wantErr string
+	}{
+		{name: "empty", data: "", want: []Entry{}},
+		{name: "single user", data: "alice:" + aliceHash + "\n", want: []Entry{{Username: "alice", Hash: aliceHash}}},
+		{name: "no trailing newline", data: "alice:" + aliceHash, want: []Entry{{Username: "alice", Hash: aliceHash}}},
+		{
+			name: "comments and blank lines",
+			data: "# users\n\nalice:" + aliceHash + "\n   \n  # disabled:" + bobHash + "\nbob:" + bobHash + "\n",
+			want: []Entry{{Username: "alice", Hash: aliceHash}, {Username: "bob", Hash: bobHash}},
+		},
+		{name: "surrounding whitespace", data: "  alice:" + aliceHash + "\t\r\n", want: []Entry{{Username: "alice", Hash: aliceHash}}},
+		{name: "insertion order", data: "bob:" + bobHash + "\nalice:" + aliceHash, want: []Entry{{Username: "bob", Hash: bobHash}, {Username: "alice", Hash: aliceHash}}},
+		{name: "colon in hash", data: "alice:a:b", want: []Entry{{Username: "alice", Hash: "a:b"}}},
+		{name: "missing colon", data: "alice:" + aliceHash + "\nbob", wantErr: "line 2: expected \"username:hash\""},
+		{name: "empty username", data: ":" + aliceHash, wantErr: "line 1: empty username"},
+		{name: "whitespace in username", data: "al ice:" + aliceHash, wantErr: "line 1: username"},
+		{name: "empty hash", data: "# users\nalice:", wantErr: "line 2: empty hash for user alice"},
+		{name: "duplicate user", data: "alice:" + aliceHash + "\nbob:" + bobHash + "\nalice:" + bobHash, wantErr: "line 3: user alice is defined more than once"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := Parse([]byte(test.data))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("Parse() error = %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() returned error: %v", err)
+			}
+			if got := file.Entries(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Parse() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	file := NewFile()
+	if err := file.Set("alice", aliceHash); err != nil {
+		t.Fatalf("Set() returned error: %v", err)
+	}
+	if err := file.Set("bob", bobHash); err != nil {
+		t.Fatalf("Set() returned error: %v", err)
+	}
+	// replacing a user keeps its position
+	if err := file.Set("alice", bobHash); err != nil {
+		t.Fatalf("Set() returned error: %v", err)
+	}
+	want := []Entry{{Username: "alice", Hash: bobHash}, {Username: "bob", Hash: bobHash}}
+	if got := file.Entries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Entries() = %v, want %v", got, want)
+	}
+
+	invalid := []struct {
+		username string
+		hash     string
+	}{
+		{username: "", hash: aliceHash},
+		{username: "al:ice", hash: aliceHash},
+		{username: "al ice", hash: aliceHash},
+		{username: "alice\n", hash: aliceHash},
+		{username: "carol", hash: ""},
+		{username: "carol", hash: "a:b"},
+		{username: "carol", hash: aliceHash + "\nmallory:" + bobHash},
+	}
+	for _, entry := range invalid {
+		if err := file.Set(entry.username, entry.hash); err == nil {
+			t.Errorf("Set(%q, %q) accepted an invalid entry", entry.username, entry.hash)
+		}
+	}
+	if file.Len() != 2 {
+		t.Errorf("Len() = %d after invalid entries, want 2", file.Len())
+	}
+}
+
+func TestRemove(t *testing.T) {
+	file, err := Parse([]byte("alice:" + aliceHash + "\nbob:" + bobHash + "\ncarol:" + aliceHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !file.Remove("bob") {
+		t.Errorf("Remove() = false for an existing user")
+	}
+	if file.Remove("bob") {
+		t.Errorf("Remove() = true for a removed user")
+	}
+	if file.Remove("mallory") {
+		t.Errorf("Remove() = true for an unknown user")
+	}
+	if want := []string{"alice", "carol"}; !reflect.DeepEqual(file.Users(), want) {
+		t.Errorf("Users() = %v, want %v", file.Users(), want)
+	}
+	if _, ok := file.Get("bob"); ok {
+		t.Errorf("Get() found a removed user")
+	}
+}
+
+func TestBytes(t *testing.T) {
+	data := "bob:" + bobHash + "\nalice:" + aliceHash + "\n"
+	file, err := Parse([]byte("# users\n\n" + data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(file.Bytes()); got != data {
+		t.Errorf("Bytes() = %q, want %q", got, data)
+	}
+	reparsed, err := Parse(file.Bytes())
+	if err != nil {
+		t.Fatalf("Parse() of Bytes() returned error: %v", err)
+	}
+	if !reflect.DeepEqual(reparsed.Entries(), file.Entries()) {
+		t.Errorf("Parse() of Bytes() = %v, want %v", reparsed.Entries(), file.Entries())
+	}
+	if got := NewFile().Bytes(); len(got) != 0 {
+		t.Errorf("Bytes() of an empty file = %q, want nothing", got)
+	}
+}
+
+func TestEntriesCopy(t *testing.T) {
+	file := NewFile()
+	if err := file.Set("alice", aliceHash); err != nil {
+		t.Fatal(err)
+	}
+	file.Entries()[0].Hash = bobHash
+	if entry, _ := file.Get("alice"); entry.Hash != aliceHash {
+		t.Errorf("changing Entries() changed the file")
+	}
+}
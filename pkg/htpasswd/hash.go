@@ -1,6 +1,152 @@
 package htpasswd
 
-import "github.com/johnaoss/htpasswd/apr1"
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/GehirnInc/crypt"
+	"github.com/GehirnInc/crypt/sha256_crypt"
+	"github.com/GehirnInc/crypt/sha512_crypt"
+	"github.com/johnaoss/htpasswd/apr1"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// Algorithm is a password hashing scheme nginx accepts in auth_basic_user_file
+type Algorithm string
+
+const (
+	// APR1 is Apache's MD5 based scheme, kept for compatibility with existing htpasswd files
+	APR1 Algorithm = "apr1"
+	// Bcrypt is the Blowfish based scheme with a configurable cost
+	Bcrypt Algorithm = "bcrypt"
+	// SHA256 is the SHA-256 crypt scheme
+	SHA256 Algorithm = "sha256"
+	// SHA512 is the SHA-512 crypt scheme
+	SHA512 Algorithm = "sha512"
+
+	DefaultAlgorithm  = APR1
+	DefaultBcryptCost = bcrypt.DefaultCost
+
+	sha256Prefix = "$5$"
+	sha512Prefix = "$6$"
+)
+
+var bcryptPrefixes = []string{"$2a$", "$2b$", "$2y$"}
+
+// HashOptions selects how passwords are hashed
+type HashOptions struct {
+	Algorithm Algorithm
+	// BcryptCost is only used by Bcrypt, zero means DefaultBcryptCost
+	BcryptCost int
+}
+
+// Hash hashes pass with the selected algorithm and a random salt
+func (o HashOptions) Hash(pass string) (string, error) {
+	switch o.algorithm() {
+	case APR1:
+		return ApacheHash(pass, "")
+	case Bcrypt:
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(pass), o.bcryptCost())
+		if err != nil {
+			return "", err
+		}
+		return string(hashedPassword), nil
+	case SHA256:
+		return sha256_crypt.New().Generate([]byte(pass), nil)
+	case SHA512:
+		return sha512_crypt.New().Generate([]byte(pass), nil)
+	default:
+		return "", fmt.Errorf("unsupported hash algorithm %q", o.Algorithm)
+	}
+}
+
+// Validate checks that passwords can be hashed with these options
+func (o HashOptions) Validate() error {
+	switch o.algorithm() {
+	case APR1, Bcrypt, SHA256, SHA512:
+	default:
+		return fmt.Errorf("unsupported hash algorithm %q", o.Algorithm)
+	}
+	if o.BcryptCost != 0 && (o.BcryptCost < bcrypt.MinCost || o.BcryptCost > bcrypt.MaxCost) {
+		return fmt.Errorf("bcrypt cost %d is outside of %d to %d", o.BcryptCost, bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	return nil
+}
+
+// Matches reports whether hashedPassword was produced with these options, so it does not need rehashing
+func (o HashOptions) Matches(hashedPassword string) bool {
+	algorithm, ok := DetectAlgorithm(hashedPassword)
+	if !ok || algorithm != o.algorithm() {
+		return false
+	}
+	if algorithm == Bcrypt {
+		cost, err := bcrypt.Cost([]byte(hashedPassword))
+		return err == nil && cost == o.bcryptCost()
+	}
+	return true
+}
+
+func (o HashOptions) algorithm() Algorithm {
+	if o.Algorithm == "" {
+		return DefaultAlgorithm
+	}
+	return o.Algorithm
+}
+
+func (o HashOptions) bcryptCost() int {
+	if o.BcryptCost == 0 {
+		return DefaultBcryptCost
+	}
+	return o.BcryptCost
+}
+
+// DetectAlgorithm returns the algorithm hashedPassword was produced with
+func DetectAlgorithm(hashedPassword string) (Algorithm, bool) {
+	switch {
+	case strings.HasPrefix(hashedPassword, apr1.Prefix):
+		return APR1, true
+	case strings.HasPrefix(hashedPassword, sha256Prefix):
+		return SHA256, true
+	case strings.HasPrefix(hashedPassword, sha512Prefix):
+		return SHA512, true
+	}
+	for _, prefix := range bcryptPrefixes {
+		if strings.HasPrefix(hashedPassword, prefix) {
+			return Bcrypt, true
+		}
+	}
+	return "", false
+}
+
+// Verify reports whether pass matches hashedPassword, whichever supported algorithm produced it
+func Verify(pass, hashedPassword string) bool {
+	algorithm, ok := DetectAlgorithm(hashedPassword)
+	if !ok {
+		return false
+	}
+	switch algorithm {
+	case APR1:
+		return VerifyApacheHash(pass, hashedPassword)
+	case Bcrypt:
+		return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(pass)) == nil
+	case SHA256:
+		return verifyCrypt(sha256_crypt.New(), pass, hashedPassword)
+	case SHA512:
+		return verifyCrypt(sha512_crypt.New(), pass, hashedPassword)
+	}
+	return false
+}
+
+// verifyCrypt reports whether pass matches a SHA crypt hash. The crypt library reads everything after
+// rounds=N$ as the salt, so hashedPassword is cut to its settings first for salts shorter than the maximum.
+func verifyCrypt(crypter crypt.Crypter, pass, hashedPassword string) bool {
+	settings := hashedPassword[:strings.LastIndex(hashedPassword, "$")]
+	computed, err := crypter.Generate([]byte(pass), []byte(settings))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hashedPassword)) == 1
+}
 
 func ApacheHash(pass, salt string) (string, error) {
 	hashedPassword, err := apr1.Hash(pass, salt)
@@ -9,3 +155,19 @@ func ApacheHash(pass, salt string) (string, error) {
 	}
 	return hashedPassword, nil
 }
+
+// VerifyApacheHash reports whether pass matches an APR1-MD5 hash produced by ApacheHash.
+func VerifyApacheHash(pass, hashedPassword string) bool {
+	if !strings.HasPrefix(hashedPassword, apr1.Prefix) {
+		return false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(hashedPassword, apr1.Prefix), "$", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	computed, err := apr1.Hash(pass, parts[0])
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hashedPassword)) == 1
+}
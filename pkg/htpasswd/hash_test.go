@@ -0,0 +1,181 @@
+package htpasswd
+
+import (
+	"strings"
+	"testing"
+)
+
+// known hashes of other implementations: openssl passwd, the OpenBSD bcrypt test vectors and the
+// SHA-crypt specification
+var knownHashes = []struct {
+	name      string
+	pass      string
+	hash      string
+	algorithm Algorithm
+}{
+	{name: "apr1", pass: "myPassword", hash: "$apr1$r31abcde$kl9eNjSys8oZ/nHjspdaj0", algorithm: APR1},
+	{name: "bcrypt 2a", pass: "U*U", hash: "$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", algorithm: Bcrypt},
+	{name: "bcrypt 2b", pass: "U*U", hash: "$2b$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", algorithm: Bcrypt},
+	{name: "bcrypt 2y", pass: "U*U", hash: "$2y$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", algorithm: Bcrypt},
+	{name: "sha256", pass: "Hello world!", hash: "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", algorithm: SHA256},
+	{name: "sha256 rounds", pass: "Hello world!", hash: "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA", algorithm: SHA256},
+	{name: "sha256 minimum rounds short salt", pass: "the minimum number is still observed", hash: "$5$rounds=1000$roundstoolow$yfvwcWrQ8l/K0DAWyuPMDNHpIVlTQebY9l/gL972bIC", algorithm: SHA256},
+	{name: "sha512", pass: "Hello world!", hash: "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", algorithm: SHA512},
+	{name: "sha512 minimum rounds short salt", pass: "the minimum number is still observed", hash: "$6$rounds=1000$roundstoolow$kUMsbe306n21p9R.FRkW3IGn.S9NPN0x50YhH1xhLsPuWGsUSklZt58jaTfF4ZEQpyUNGc0dqbpBYYBaHHrsX.", algorithm: SHA512},
+}
+
+func TestDetectAlgorithm(t *testing.T) {
+	for _, known := range knownHashes {
+		if got, ok := DetectAlgorithm(known.hash); !ok || got != known.algorithm {
+			t.Errorf("DetectAlgorithm(%s) = %q, %v, want %q", known.name, got, ok, known.algorithm)
+		}
+	}
+	for _, hash := range []string{"", "secret", "$1$salt$hash", "{SHA}qUqP5cyxm6YcTAhz05Hph5gvu9M=", "$2x$05$hash", "$7$hash", "apr1$salt$hash"} {
+		if got, ok := DetectAlgorithm(hash); ok {
+			t.Errorf("DetectAlgorithm(%q) = %q, want no algorithm", hash, got)
+		}
+	}
+}
+
+func TestVerifyKnownHashes(t *testing.T) {
+	for _, known := range knownHashes {
+		t.Run(known.name, func(t *testing.T) {
+			if !Verify(known.pass, known.hash) {
+				t.Errorf("Verify() rejected the right password")
+			}
+			if Verify(known.pass+"x", known.hash) {
+				t.Errorf("Verify() accepted a wrong password")
+			}
+			if Verify("", known.hash) {
+				t.Errorf("Verify() accepted an empty password")
+			}
+		})
+	}
+}
+
+func TestVerifyMalformedHashes(t *testing.T) {
+	for _, hash := range []string{"", "myPassword", "$apr1$", "$apr1$r31abcde", "$2y$05$short", "$5$", "$6$rounds=abc$salt$hash"} {
+		if Verify("myPassword", hash) {
+			t.Errorf("Verify() accepted malformed hash %q", hash)
+		}
+	}
+}
+
+func TestApacheHash(t *testing.T) {
+	hash, err := ApacheHash("myPassword", "r31abcde")
+	if err != nil {
+		t.Fatalf("ApacheHash() returned error: %v", err)
+	}
+	if want := "$apr1$r31abcde$kl9eNjSys8oZ/nHjspdaj0"; hash != want {
+		t.Errorf("ApacheHash() = %s, want %s", hash, want)
+	}
+	if VerifyApacheHash("myPassword", "$2y$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW") {
+		t.Errorf("VerifyApacheHash() accepted a bcrypt hash")
+	}
+}
+
+func TestHashRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		options HashOptions
+		want    Algorithm
+		prefix  string
+	}{
+		{name: "default", options: HashOptions{}, want: APR1, prefix: "$apr1$"},
+		{name: "apr1", options: HashOptions{Algorithm: APR1}, want: APR1, prefix: "$apr1$"},
+		{name: "bcrypt default cost", options: HashOptions{Algorithm: Bcrypt}, want: Bcrypt, prefix: "$2a$10$"},
+		{name: "bcrypt minimum cost", options: HashOptions{Algorithm: Bcrypt, BcryptCost: 4}, want: Bcrypt, prefix: "$2a$04$"},
+		{name: "sha256", options: HashOptions{Algorithm: SHA256}, want: SHA256, prefix: "$5$"},
+		{name: "sha512", options: HashOptions{Algorithm: SHA512}, want: SHA512, prefix: "$6$"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hash, err := test.options.Hash("s3cr:et pass")
+			if err != nil {
+				t.Fatalf("Hash() returned error: %v", err)
+			}
+			if !strings.HasPrefix(hash, test.prefix) {
+				t.Errorf("Hash() = %s, want prefix %s", hash, test.prefix)
+			}
+			if got, ok := DetectAlgorithm(hash); !ok || got != test.want {
+				t.Errorf("DetectAlgorithm() = %q, %v, want %q", got, ok, test.want)
+			}
+			if !Verify("s3cr:et pass", hash) || Verify("s3cr:et pas", hash) {
+				t.Errorf("Verify() does not match Hash()")
+			}
+			if !test.options.Matches(hash) {
+				t.Errorf("Matches() = false for its own hash")
+			}
+			// salts are random
+			if other, err := test.options.Hash("s3cr:et pass"); err != nil || other == hash {
+				t.Errorf("Hash() = %s, %v twice", other, err)
+			}
+		})
+	}
+}
+
+func TestHashBcryptCostBounds(t *testing.T) {
+	// bcrypt raises costs below its minimum to the default
+	hash, err := HashOptions{Algorithm: Bcrypt, BcryptCost: 3}.Hash("pass")
+	if err != nil || !strings.HasPrefix(hash, "$2a$10$") {
+		t.Errorf("Hash() = %s, %v, want the default cost", hash, err)
+	}
+	if _, err := (HashOptions{Algorithm: Bcrypt, BcryptCost: 32}).Hash("pass"); err == nil {
+		t.Errorf("Hash() accepted a cost above the maximum")
+	}
+	if _, err := (HashOptions{Algorithm: "md5"}).Hash("pass"); err == nil {
+		t.Errorf("Hash() accepted an unsupported algorithm")
+	}
+}
+
+func TestMatches(t *testing.T) {
+	const bcryptHash = "$2y$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW"
+	tests := []struct {
+		name    string
+		options HashOptions
+		hash    string
+		want    bool
+	}{
+		{name: "default apr1", options: HashOptions{}, hash: "$apr1$r31abcde$kl9eNjSys8oZ/nHjspdaj0", want: true},
+		{name: "other algorithm", options: HashOptions{Algorithm: SHA512}, hash: "$apr1$r31abcde$kl9eNjSys8oZ/nHjspdaj0", want: false},
+		{name: "bcrypt same cost", options: HashOptions{Algorithm: Bcrypt, BcryptCost: 5}, hash: bcryptHash, want: true},
+		{name: "bcrypt other cost", options: HashOptions{Algorithm: Bcrypt, BcryptCost: 12}, hash: bcryptHash, want: false},
+		{name: "bcrypt default cost", options: HashOptions{Algorithm: Bcrypt}, hash: bcryptHash, want: false},
+		{name: "unknown hash", options: HashOptions{}, hash: "secret", want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.options.Matches(test.hash); got != test.want {
+				t.Errorf("Matches() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHashOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options HashOptions
+		wantErr bool
+	}{
+		{name: "default", options: HashOptions{}},
+		{name: "apr1", options: HashOptions{Algorithm: APR1}},
+		{name: "bcrypt default cost", options: HashOptions{Algorithm: Bcrypt}},
+		{name: "bcrypt minimum cost", options: HashOptions{Algorithm: Bcrypt, BcryptCost: 4}},
+		{name: "bcrypt maximum cost", options: HashOptions{Algorithm: Bcrypt, BcryptCost: 31}},
+		{name: "sha256", options: HashOptions{Algorithm: SHA256}},
+		{name: "sha512", options: HashOptions{Algorithm: SHA512}},
+		{name: "unsupported algorithm", options: HashOptions{Algorithm: "md5"}, wantErr: true},
+		{name: "algorithm typo", options: HashOptions{Algorithm: "Bcrypt"}, wantErr: true},
+		{name: "bcrypt cost too low", options: HashOptions{Algorithm: Bcrypt, BcryptCost: 3}, wantErr: true},
+		{name: "bcrypt cost too high", options: HashOptions{Algorithm: Bcrypt, BcryptCost: 32}, wantErr: true},
+		{name: "negative bcrypt cost", options: HashOptions{BcryptCost: -1}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.options.Validate(); (err != nil) != test.wantErr {
+				t.Errorf("Validate() error = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
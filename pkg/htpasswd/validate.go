@@ -1,11 +1,29 @@
 package htpasswd
 
-import "strings"
+import (
+	"errors"
+	"fmt"
+)
 
+// ValidateHtpasswdFormat reports whether pass is a valid htpasswd file, see Validate
 func ValidateHtpasswdFormat(pass string) bool {
-	passParts := strings.Split(pass, ":")
-	if len(passParts) != 2 || passParts[0] == "" || passParts[1] == "" {
-		return false
+	return Validate([]byte(pass)) == nil
+}
+
+// Validate checks that data is a well-formed htpasswd file with at least one user,
+// whose hashes were all produced by a supported algorithm
+func Validate(data []byte) error {
+	file, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	if file.Len() == 0 {
+		return errors.New("htpasswd file has no users")
+	}
+	for _, entry := range file.entries {
+		if _, ok := entry.Algorithm(); !ok {
+			return fmt.Errorf("unsupported hash algorithm for user %s", entry.Username)
+		}
 	}
-	return true
+	return nil
 }
@@ -0,0 +1,41 @@
+package htpasswd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "apr1", data: "alice:$apr1$salt$hash"},
+		{name: "every algorithm", data: "a:$apr1$salt$hash\nb:$2a$10$hash\nc:$2b$10$hash\nd:$2y$10$hash\ne:$5$salt$hash\nf:$6$salt$hash"},
+		{name: "comments and blank lines", data: "# users\n\nalice:$apr1$salt$hash\n\n# end\n"},
+		{name: "empty", data: "", wantErr: "no users"},
+		{name: "only comments", data: "# users\n\n", wantErr: "no users"},
+		{name: "missing colon", data: "alice", wantErr: "line 1"},
+		{name: "duplicate user", data: "alice:$apr1$salt$hash\nalice:$2y$10$hash", wantErr: "more than once"},
+		{name: "plain text password", data: "alice:secret", wantErr: "unsupported hash algorithm for user alice"},
+		{name: "crypt des", data: "alice:rl0uRVhdSW6", wantErr: "unsupported hash algorithm"},
+		{name: "sha1", data: "alice:{SHA}qUqP5cyxm6YcTAhz05Hph5gvu9M=", wantErr: "unsupported hash algorithm"},
+		{name: "md5 crypt", data: "alice:$1$salt$hash", wantErr: "unsupported hash algorithm"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Validate([]byte(test.data))
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() returned error: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Validate() error = %v, want one containing %q", err, test.wantErr)
+			}
+			if got := ValidateHtpasswdFormat(test.data); got != (test.wantErr == "") {
+				t.Errorf("ValidateHtpasswdFormat() = %v, want %v", got, test.wantErr == "")
+			}
+		})
+	}
+}
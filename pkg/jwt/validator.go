@@ -0,0 +1,323 @@
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-logr/logr"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	bearerPrefix   = "bearer "
+	keysReloadTTL  = time.Minute
+	requestTimeout = 10 * time.Second
+)
+
+// Config describes the tokens a Validator accepts
+type Config struct {
+	// JWKSURL serves the keys tokens are signed with, fetched again whenever a token names an unknown key
+	JWKSURL string
+	// PublicKeysDir holds PEM encoded public keys or certificates tokens are signed with, one or more per file
+	PublicKeysDir string
+	// Issuer must match the iss claim if set
+	Issuer string
+	// Audiences lets tokens in whose aud claim contains any of them, if set
+	Audiences []string
+	// RequiredClaims must be present with the given value, or contain it if the claim is a list
+	RequiredClaims map[string]string
+	// ClockSkew is tolerated on exp, nbf and iat
+	ClockSkew time.Duration
+	// ClaimHeaders maps claims to the headers nginx passes them upstream in
+	ClaimHeaders map[string]string
+}
+
+// Validator checks the bearer token of a request
+type Validator struct {
+	config Config
+	logger logr.Logger
+	now    func() time.Time
+
+	mu         sync.Mutex
+	keySet     gooidc.KeySet
+	keysLoaded time.Time
+}
+
+// claims are the registered claims checked by the Validator, the audience is checked as a string or list
+type claims struct {
+	Issuer    string       `json:"iss"`
+	Subject   string       `json:"sub"`
+	Expiry    *json.Number `json:"exp"`
+	NotBefore *json.Number `json:"nbf"`
+	IssuedAt  *json.Number `json:"iat"`
+}
+
+// NewValidator returns a Validator for config. Exactly one of JWKSURL and PublicKeysDir must be set.
+func NewValidator(config Config, logger logr.Logger) (*Validator, error) {
+	if (config.JWKSURL == "") == (config.PublicKeysDir == "") {
+		return nil, errors.New("exactly one of a jwks url and a public keys directory is required")
+	}
+	validator := &Validator{config: config, logger: logger, now: time.Now}
+	if config.JWKSURL != "" {
+		validator.keySet = gooidc.NewRemoteKeySet(context.Background(), config.JWKSURL)
+	}
+	return validator, nil
+}
+
+// AuthenticateRequest returns the subject of the request's token, if it carries a valid one
+func (v *Validator) AuthenticateRequest(r *http.Request) (string, bool) {
+	user, _, ok := v.AuthenticateRequestHeaders(r)
+	return user, ok
+}
+
+// AuthenticateRequestHeaders returns the subject of the request's token, if it carries a valid one, and
+// its claims mapped to headers
+func (v *Validator) AuthenticateRequestHeaders(r *http.Request) (string, http.Header, bool) {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) <= len(bearerPrefix) || !strings.EqualFold(authorization[:len(bearerPrefix)], bearerPrefix) {
+		return "", nil, false
+	}
+	token := strings.TrimSpace(authorization[len(bearerPrefix):])
+	// API keys share the header, only something shaped like a JWS is worth verifying
+	if strings.Count(token, ".") != 2 {
+		return "", nil, false
+	}
+	keySet, err := v.getKeySet()
+	if err != nil {
+		v.logger.Error(err, "failed to load public keys")
+		return "", nil, false
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+	payload, err := keySet.VerifySignature(ctx, token)
+	if err != nil {
+		v.logger.V(1).Info("rejected token", "reason", err.Error())
+		return "", nil, false
+	}
+	subject, headers, err := v.validateClaims(payload)
+	if err != nil {
+		v.logger.V(1).Info("rejected token", "reason", err.Error())
+		return "", nil, false
+	}
+	return subject, headers, true
+}
+
+// validateClaims checks the claims of a verified token and returns its subject and claim headers
+func (v *Validator) validateClaims(payload []byte) (string, http.Header, error) {
+	var registered claims
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&registered); err != nil {
+		return "", nil, fmt.Errorf("malformed claims: %w", err)
+	}
+	all := make(map[string]interface{})
+	decoder = json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&all); err != nil {
+		return "", nil, fmt.Errorf("malformed claims: %w", err)
+	}
+
+	now := v.now()
+	if registered.Expiry == nil {
+		return "", nil, errors.New("no exp claim")
+	}
+	expiry, err := registered.Expiry.Float64()
+	if err != nil || now.Add(-v.config.ClockSkew).After(time.Unix(int64(expiry), 0)) {
+		return "", nil, errors.New("token expired")
+	}
+	if registered.NotBefore != nil {
+		notBefore, err := registered.NotBefore.Float64()
+		if err != nil || now.Add(v.config.ClockSkew).Before(time.Unix(int64(notBefore), 0)) {
+			return "", nil, errors.New("token not valid yet")
+		}
+	}
+	if registered.IssuedAt != nil {
+		issuedAt, err := registered.IssuedAt.Float64()
+		if err != nil || now.Add(v.config.ClockSkew).Before(time.Unix(int64(issuedAt), 0)) {
+			return "", nil, errors.New("token issued in the future")
+		}
+	}
+	if v.config.Issuer != "" && registered.Issuer != v.config.Issuer {
+		return "", nil, fmt.Errorf("unexpected issuer %q", registered.Issuer)
+	}
+	if len(v.config.Audiences) > 0 && !containsAny(stringList(all["aud"]), v.config.Audiences) {
+		return "", nil, errors.New("unexpected audience")
+	}
+	for name, value := range v.config.RequiredClaims {
+		if !containsAny(stringList(all[name]), []string{value}) {
+			return "", nil, fmt.Errorf("claim %s does not match", name)
+		}
+	}
+
+	headers := make(http.Header)
+	for name, header := range v.config.ClaimHeaders {
+		if value, ok := formatClaim(all[name]); ok {
+			headers.Set(header, value)
+		}
+	}
+	return registered.Subject, headers, nil
+}
+
+// getKeySet returns the key set tokens are verified with, reading the public keys directory again once
+// in a while so rotated keys are picked up
+func (v *Validator) getKeySet() (gooidc.KeySet, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.config.JWKSURL != "" || (v.keySet != nil && v.now().Sub(v.keysLoaded) < keysReloadTTL) {
+		return v.keySet, nil
+	}
+	keys, err := ReadPublicKeys(v.config.PublicKeysDir)
+	if err != nil {
+		return nil, err
+	}
+	v.keySet = &gooidc.StaticKeySet{PublicKeys: keys}
+	v.keysLoaded = v.now()
+	return v.keySet, nil
+}
+
+// ReadPublicKeys returns the public keys of the files in dir, skipping hidden files such as the ones
+// kubelet keeps mounted secrets in
+func ReadPublicKeys(dir string) ([]crypto.PublicKey, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var keys []crypto.PublicKey
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		fileKeys, err := ParsePublicKeys(content)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Name(), err)
+		}
+		keys = append(keys, fileKeys...)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public keys found in %s", dir)
+	}
+	return keys, nil
+}
+
+// ParsePublicKeys returns the RSA and ECDSA public keys and certificates of PEM encoded content
+func ParsePublicKeys(content []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+		var key crypto.PublicKey
+		switch block.Type {
+		case "PUBLIC KEY":
+			parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			key = parsed
+		case "RSA PUBLIC KEY":
+			parsed, err := x509.ParsePKCS1PublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			key = parsed
+		case "CERTIFICATE":
+			certificate, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			key = certificate.PublicKey
+		default:
+			return nil, fmt.Errorf("unsupported pem block %s", block.Type)
+		}
+		switch key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+			keys = append(keys, key)
+		default:
+			return nil, fmt.Errorf("unsupported public key type %T", key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no pem encoded public key")
+	}
+	return keys, nil
+}
+
+// stringList returns a string claim, or the strings of a list claim
+func stringList(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case json.Number:
+		return []string{value.String()}
+	case bool:
+		return []string{fmt.Sprint(value)}
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			values = append(values, stringList(item)...)
+		}
+		return values
+	}
+	return nil
+}
+
+func containsAny(values, wanted []string) bool {
+	for _, value := range values {
+		for _, candidate := range wanted {
+			if value == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// formatClaim renders a claim as a header value: strings as they are, lists of strings comma separated
+// and anything else as JSON
+func formatClaim(claim interface{}) (string, bool) {
+	var value string
+	switch typed := claim.(type) {
+	case nil:
+		return "", false
+	case string:
+		value = typed
+	case []interface{}:
+		items := stringList(typed)
+		if len(items) != len(typed) {
+			encoded, err := json.Marshal(typed)
+			if err != nil {
+				return "", false
+			}
+			value = string(encoded)
+		} else {
+			value = strings.Join(items, ",")
+		}
+	default:
+		encoded, err := json.Marshal(typed)
+		if err != nil {
+			return "", false
+		}
+		value = string(encoded)
+	}
+	// a header value can't span lines
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value), true
+}
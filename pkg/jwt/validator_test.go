@@ -0,0 +1,381 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-logr/logr"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	issuer   = "https://issuer.example.org"
+	audience = "simple-authenticator"
+)
+
+var testNow = time.Unix(1700000000, 0)
+
+func newTestValidator(config Config) *Validator {
+	return &Validator{config: config, logger: logr.Discard(), now: func() time.Time { return testNow }}
+}
+
+func marshalClaims(t *testing.T, claims map[string]interface{}) []byte {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+// validClaims returns claims every test validator accepts, with overrides applied and nil overrides removed
+func validClaims(overrides map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"iss": issuer,
+		"sub": "alice",
+		"aud": audience,
+		"exp": testNow.Add(time.Hour).Unix(),
+		"nbf": testNow.Add(-time.Minute).Unix(),
+		"iat": testNow.Add(-time.Minute).Unix(),
+	}
+	for name, value := range overrides {
+		if value == nil {
+			delete(claims, name)
+			continue
+		}
+		claims[name] = value
+	}
+	return claims
+}
+
+func TestValidateClaims(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		claims map[string]interface{}
+		want   bool
+	}{
+		{name: "valid token", claims: validClaims(nil), want: true},
+		{name: "no exp", claims: validClaims(map[string]interface{}{"exp": nil}), want: false},
+		{name: "expired", claims: validClaims(map[string]interface{}{"exp": testNow.Add(-time.Second).Unix()}), want: false},
+		{name: "expired within skew", config: Config{ClockSkew: time.Minute}, claims: validClaims(map[string]interface{}{"exp": testNow.Add(-30 * time.Second).Unix()}), want: true},
+		{name: "expired beyond skew", config: Config{ClockSkew: time.Minute}, claims: validClaims(map[string]interface{}{"exp": testNow.Add(-2 * time.Minute).Unix()}), want: false},
+		{name: "malformed exp", claims: validClaims(map[string]interface{}{"exp": "tomorrow"}), want: false},
+		{name: "not valid yet", claims: validClaims(map[string]interface{}{"nbf": testNow.Add(time.Second).Unix()}), want: false},
+		{name: "not valid yet within skew", config: Config{ClockSkew: time.Minute}, claims: validClaims(map[string]interface{}{"nbf": testNow.Add(30 * time.Second).Unix()}), want: true},
+		{name: "no nbf", claims: validClaims(map[string]interface{}{"nbf": nil}), want: true},
+		{name: "issued in the future", claims: validClaims(map[string]interface{}{"iat": testNow.Add(time.Second).Unix()}), want: false},
+		{name: "issued in the future within skew", config: Config{ClockSkew: time.Minute}, claims: validClaims(map[string]interface{}{"iat": testNow.Add(30 * time.Second).Unix()}), want: true},
+		{name: "fractional times", claims: validClaims(map[string]interface{}{"exp": float64(testNow.Unix()) + 0.5, "iat": float64(testNow.Unix()) - 0.5}), want: true},
+		{name: "expected issuer", config: Config{Issuer: issuer}, claims: validClaims(nil), want: true},
+		{name: "wrong issuer", config: Config{Issuer: issuer}, claims: validClaims(map[string]interface{}{"iss": "https://evil.example.org"}), want: false},
+		{name: "no issuer", config: Config{Issuer: issuer}, claims: validClaims(map[string]interface{}{"iss": nil}), want: false},
+		{name: "audience string", config: Config{Audiences: []string{"other", audience}}, claims: validClaims(nil), want: true},
+		{name: "audience list", config: Config{Audiences: []string{audience}}, claims: validClaims(map[string]interface{}{"aud": []string{"other", audience}}), want: true},
+		{name: "wrong audience", config: Config{Audiences: []string{audience}}, claims: validClaims(map[string]interface{}{"aud": "other"}), want: false},
+		{name: "wrong audience list", config: Config{Audiences: []string{audience}}, claims: validClaims(map[string]interface{}{"aud": []string{"other", "another"}}), want: false},
+		{name: "no audience", config: Config{Audiences: []string{audience}}, claims: validClaims(map[string]interface{}{"aud": nil}), want: false},
+		{name: "required claim", config: Config{RequiredClaims: map[string]string{"tenant": "snapp"}}, claims: validClaims(map[string]interface{}{"tenant": "snapp"}), want: true},
+		{name: "required claim in list", config: Config{RequiredClaims: map[string]string{"groups": "admins"}}, claims: validClaims(map[string]interface{}{"groups": []string{"users", "admins"}}), want: true},
+		{name: "required boolean claim", config: Config{RequiredClaims: map[string]string{"email_verified": "true"}}, claims: validClaims(map[string]interface{}{"email_verified": true}), want: true},
+		{name: "required number claim", config: Config{RequiredClaims: map[string]string{"level": "3"}}, claims: validClaims(map[string]interface{}{"level": 3}), want: true},
+		{name: "required claim mismatch", config: Config{RequiredClaims: map[string]string{"tenant": "snapp"}}, claims: validClaims(map[string]interface{}{"tenant": "other"}), want: false},
+		{name: "required claim missing", config: Config{RequiredClaims: map[string]string{"tenant": "snapp"}}, claims: validClaims(nil), want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subject, _, err := newTestValidator(test.config).validateClaims(marshalClaims(t, test.claims))
+			if got := err == nil; got != test.want {
+				t.Errorf("validateClaims() error = %v, want valid %v", err, test.want)
+			}
+			if err == nil && subject != "alice" {
+				t.Errorf("validateClaims() subject = %q, want alice", subject)
+			}
+		})
+	}
+}
+
+func TestValidateClaimsHeaders(t *testing.T) {
+	validator := newTestValidator(Config{ClaimHeaders: map[string]string{
+		"email":  "X-Auth-Email",
+		"groups": "X-Auth-Groups",
+		"level":  "X-Auth-Level",
+		"roles":  "X-Auth-Roles",
+		"name":   "X-Auth-Name",
+		"absent": "X-Auth-Absent",
+	}})
+	_, headers, err := validator.validateClaims(marshalClaims(t, validClaims(map[string]interface{}{
+		"email":  "alice@example.org",
+		"groups": []string{"users", "admins"},
+		"level":  3,
+		"roles":  []interface{}{"admin", map[string]string{"scope": "all"}},
+		"name":   "alice\r\nX-Injected: true",
+	})))
+	if err != nil {
+		t.Fatalf("validateClaims() returned error: %v", err)
+	}
+	want := map[string]string{
+		"X-Auth-Email":  "alice@example.org",
+		"X-Auth-Groups": "users,admins",
+		"X-Auth-Level":  "3",
+		"X-Auth-Roles":  `["admin",{"scope":"all"}]`,
+		"X-Auth-Name":   "aliceX-Injected: true",
+	}
+	for header, value := range want {
+		if got := headers.Get(header); got != value {
+			t.Errorf("header %s = %q, want %q", header, got, value)
+		}
+	}
+	if _, ok := headers["X-Auth-Absent"]; ok {
+		t.Errorf("header X-Auth-Absent set for a missing claim")
+	}
+}
+
+func encodePEM(blockType string, bytes []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: bytes})
+}
+
+func encodePKIX(t *testing.T, key interface{}) []byte {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encodePEM("PUBLIC KEY", der)
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestParsePublicKeys(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519Key, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "issuer"}, NotAfter: testNow.Add(time.Hour)}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &ecdsaKey.PublicKey, ecdsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    int
+	}{
+		{name: "rsa pkix", content: encodePKIX(t, &rsaKey.PublicKey), want: 1},
+		{name: "rsa pkcs1", content: encodePEM("RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)), want: 1},
+		{name: "ecdsa pkix", content: encodePKIX(t, &ecdsaKey.PublicKey), want: 1},
+		{name: "certificate", content: encodePEM("CERTIFICATE", certificate), want: 1},
+		{name: "several blocks", content: append(encodePKIX(t, &rsaKey.PublicKey), encodePKIX(t, &ecdsaKey.PublicKey)...), want: 2},
+		{name: "ed25519 key", content: encodePKIX(t, ed25519Key), want: 0},
+		{name: "private key", content: encodePEM("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)), want: 0},
+		{name: "malformed key", content: encodePEM("PUBLIC KEY", []byte("not a key")), want: 0},
+		{name: "malformed certificate", content: encodePEM("CERTIFICATE", []byte("not a certificate")), want: 0},
+		{name: "not pem", content: []byte("not pem"), want: 0},
+		{name: "empty", content: nil, want: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keys, err := ParsePublicKeys(test.content)
+			if test.want == 0 {
+				if err == nil {
+					t.Errorf("ParsePublicKeys() = %d keys, want an error", len(keys))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePublicKeys() returned error: %v", err)
+			}
+			if len(keys) != test.want {
+				t.Errorf("ParsePublicKeys() = %d keys, want %d", len(keys), test.want)
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, path string, content []byte) {
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadPublicKeys(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "first.pem"), encodePKIX(t, &newRSAKey(t).PublicKey))
+	writeFile(t, filepath.Join(dir, "second.pem"), encodePKIX(t, &newRSAKey(t).PublicKey))
+	// kubelet keeps the files of a mounted secret in hidden directories next to links to them
+	if err := os.Mkdir(filepath.Join(dir, "..data"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, ".hidden"), []byte("not pem"))
+
+	keys, err := ReadPublicKeys(dir)
+	if err != nil {
+		t.Fatalf("ReadPublicKeys() returned error: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("ReadPublicKeys() = %d keys, want 2", len(keys))
+	}
+
+	writeFile(t, filepath.Join(dir, "broken.pem"), []byte("not pem"))
+	if _, err := ReadPublicKeys(dir); err == nil || !strings.Contains(err.Error(), "broken.pem") {
+		t.Errorf("ReadPublicKeys() error = %v, want one naming broken.pem", err)
+	}
+	if _, err := ReadPublicKeys(t.TempDir()); err == nil {
+		t.Errorf("ReadPublicKeys() accepted an empty directory")
+	}
+	if _, err := ReadPublicKeys(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("ReadPublicKeys() accepted a missing directory")
+	}
+}
+
+func sign(t *testing.T, algorithm jose.SignatureAlgorithm, key interface{}, kid string, claims map[string]interface{}) string {
+	options := &jose.SignerOptions{}
+	if kid != "" {
+		options = options.WithHeader("kid", kid)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: algorithm, Key: key}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := signer.Sign(marshalClaims(t, claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := signed.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// unsignedToken returns a token claiming the none algorithm
+func unsignedToken(t *testing.T, claims map[string]interface{}) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + encode(marshalClaims(t, claims)) + "."
+}
+
+func authenticate(validator *Validator, authorization string) (string, bool) {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	return validator.AuthenticateRequest(request)
+}
+
+func TestAuthenticateRequest(t *testing.T) {
+	key := newRSAKey(t)
+	publicKeyPEM := encodePKIX(t, &key.PublicKey)
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "key.pem"), publicKeyPEM)
+	validator, err := NewValidator(Config{PublicKeysDir: dir, Issuer: issuer, Audiences: []string{audience}}, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator.now = func() time.Time { return testNow }
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          bool
+	}{
+		{name: "valid token", authorization: "Bearer " + sign(t, jose.RS256, key, "", validClaims(nil)), want: true},
+		{name: "lower case scheme", authorization: "bearer " + sign(t, jose.RS256, key, "", validClaims(nil)), want: true},
+		{name: "other rsa algorithm", authorization: "Bearer " + sign(t, jose.PS256, key, "", validClaims(nil)), want: true},
+		{name: "no header", authorization: "", want: false},
+		{name: "basic scheme", authorization: "Basic " + sign(t, jose.RS256, key, "", validClaims(nil)), want: false},
+		{name: "not a jws", authorization: "Bearer an-api-key", want: false},
+		{name: "expired", authorization: "Bearer " + sign(t, jose.RS256, key, "", validClaims(map[string]interface{}{"exp": testNow.Add(-time.Hour).Unix()})), want: false},
+		{name: "not valid yet", authorization: "Bearer " + sign(t, jose.RS256, key, "", validClaims(map[string]interface{}{"nbf": testNow.Add(time.Hour).Unix()})), want: false},
+		{name: "wrong issuer", authorization: "Bearer " + sign(t, jose.RS256, key, "", validClaims(map[string]interface{}{"iss": "https://evil.example.org"})), want: false},
+		{name: "wrong audience", authorization: "Bearer " + sign(t, jose.RS256, key, "", validClaims(map[string]interface{}{"aud": "other"})), want: false},
+		{name: "no exp", authorization: "Bearer " + sign(t, jose.RS256, key, "", validClaims(map[string]interface{}{"exp": nil})), want: false},
+		{name: "signed by another key", authorization: "Bearer " + sign(t, jose.RS256, newRSAKey(t), "", validClaims(nil)), want: false},
+		{name: "alg none", authorization: "Bearer " + unsignedToken(t, validClaims(nil)), want: false},
+		{name: "hmac with the public key", authorization: "Bearer " + sign(t, jose.HS256, publicKeyPEM, "", validClaims(nil)), want: false},
+		{name: "tampered payload", authorization: "Bearer " + tamper(t, sign(t, jose.RS256, key, "", validClaims(nil))), want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subject, got := authenticate(validator, test.authorization)
+			if got != test.want {
+				t.Errorf("AuthenticateRequest() = %v, want %v", got, test.want)
+			}
+			if got && subject != "alice" {
+				t.Errorf("AuthenticateRequest() subject = %q, want alice", subject)
+			}
+		})
+	}
+}
+
+// tamper swaps the subject of a signed token, keeping its signature
+func tamper(t *testing.T, token string) string {
+	parts := strings.Split(token, ".")
+	parts[1] = base64.RawURLEncoding.EncodeToString(marshalClaims(t, validClaims(map[string]interface{}{"sub": "admin"})))
+	return strings.Join(parts, ".")
+}
+
+func TestAuthenticateRequestJWKS(t *testing.T) {
+	key := newRSAKey(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if err := json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "current", Algorithm: "RS256", Use: "sig"}}}); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+	validator, err := NewValidator(Config{JWKSURL: server.URL}, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator.now = func() time.Time { return testNow }
+
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{name: "known kid", token: sign(t, jose.RS256, key, "current", validClaims(nil)), want: true},
+		{name: "unknown kid", token: sign(t, jose.RS256, newRSAKey(t), "retired", validClaims(nil)), want: false},
+		{name: "known kid of another key", token: sign(t, jose.RS256, newRSAKey(t), "current", validClaims(nil)), want: false},
+		{name: "alg none", token: unsignedToken(t, validClaims(nil)), want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, got := authenticate(validator, "Bearer "+test.token); got != test.want {
+				t.Errorf("AuthenticateRequest() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewValidator(t *testing.T) {
+	for _, config := range []Config{{}, {JWKSURL: "https://issuer.example.org/keys", PublicKeysDir: "/etc/jwt"}} {
+		if _, err := NewValidator(config, logr.Discard()); err == nil {
+			t.Errorf("NewValidator(%+v) accepted a config without exactly one key source", config)
+		}
+	}
+}
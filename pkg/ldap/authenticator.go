@@ -0,0 +1,191 @@
+package ldap
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	goldap "github.com/go-ldap/ldap/v3"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UsernamePlaceholder is replaced with the escaped username in user and group filters
+const UsernamePlaceholder = "{username}"
+
+// Conn is the part of an LDAP connection the Authenticator uses
+type Conn interface {
+	Bind(username, password string) error
+	Search(searchRequest *goldap.SearchRequest) (*goldap.SearchResult, error)
+	Close() error
+}
+
+// Dialer opens a connection to the directory described by config
+type Dialer func(config Config) (Conn, error)
+
+// Config describes the directory users are checked against
+type Config struct {
+	URL                string
+	BaseDN             string
+	UserFilter         string
+	GroupFilter        string
+	StartTLS           bool
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+	// CacheTTL is how long a successful authentication is remembered, zero disables the cache
+	CacheTTL time.Duration
+	// BindCredentials returns the DN and password the directory is searched with, the search is anonymous
+	// when it is nil or returns an empty DN
+	BindCredentials func() (string, string, error)
+}
+
+// Authenticator checks username and password pairs against a directory. A user authenticates if the user
+// filter, combined with the group filter, finds exactly one entry and the directory accepts a bind to it.
+type Authenticator struct {
+	config Config
+	dial   Dialer
+	now    func() time.Time
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]time.Time
+}
+
+// NewAuthenticator returns an Authenticator connecting to the directory with go-ldap
+func NewAuthenticator(config Config) *Authenticator {
+	return NewAuthenticatorWithDialer(config, Dial)
+}
+
+// NewAuthenticatorWithDialer returns an Authenticator connecting to the directory with dial
+func NewAuthenticatorWithDialer(config Config, dial Dialer) *Authenticator {
+	return &Authenticator{
+		config: config,
+		dial:   dial,
+		now:    time.Now,
+		cache:  make(map[[sha256.Size]byte]time.Time),
+	}
+}
+
+// Dial connects to config.URL, upgrading the connection with StartTLS if configured
+func Dial(config Config) (Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	opts := []goldap.DialOpt{goldap.DialWithTLSConfig(tlsConfig)}
+	if config.Timeout > 0 {
+		// covers connecting and the ldaps handshake, SetTimeout only covers the requests after it
+		opts = append(opts, goldap.DialWithDialer(&net.Dialer{Timeout: config.Timeout}))
+	}
+	conn, err := goldap.DialURL(config.URL, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if config.Timeout > 0 {
+		conn.SetTimeout(config.Timeout)
+	}
+	if config.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start tls: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// Authenticate reports whether the directory accepts password for username. Errors are only returned
+// when the directory couldn't be asked.
+func (a *Authenticator) Authenticate(username, password string) (bool, error) {
+	// an empty password would be an unauthenticated bind, which directories accept for any DN
+	if username == "" || password == "" {
+		return false, nil
+	}
+	key := sha256.Sum256([]byte(username + "\x00" + password))
+	if a.cached(key) {
+		return true, nil
+	}
+
+	conn, err := a.dial(a.config)
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to %s: %w", a.config.URL, err)
+	}
+	defer conn.Close()
+
+	if a.config.BindCredentials != nil {
+		bindDN, bindPassword, err := a.config.BindCredentials()
+		if err != nil {
+			return false, fmt.Errorf("failed to read bind credentials: %w", err)
+		}
+		if bindDN != "" {
+			if err := conn.Bind(bindDN, bindPassword); err != nil {
+				return false, fmt.Errorf("failed to bind as %s: %w", bindDN, err)
+			}
+		}
+	}
+
+	result, err := conn.Search(goldap.NewSearchRequest(
+		a.config.BaseDN,
+		goldap.ScopeWholeSubtree,
+		goldap.NeverDerefAliases,
+		2,
+		0,
+		false,
+		a.filter(username),
+		[]string{"1.1"},
+		nil,
+	))
+	if err != nil && !goldap.IsErrorWithCode(err, goldap.LDAPResultSizeLimitExceeded) {
+		return false, fmt.Errorf("failed to search for %s: %w", username, err)
+	}
+	if result == nil || len(result.Entries) != 1 {
+		return false, nil
+	}
+
+	if err := conn.Bind(result.Entries[0].DN, password); err != nil {
+		if goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to bind as %s: %w", username, err)
+	}
+	a.remember(key)
+	return true, nil
+}
+
+// filter returns the user filter, restricted to the group filter, for username
+func (a *Authenticator) filter(username string) string {
+	escaped := goldap.EscapeFilter(username)
+	filter := strings.ReplaceAll(a.config.UserFilter, UsernamePlaceholder, escaped)
+	if a.config.GroupFilter == "" {
+		return filter
+	}
+	return fmt.Sprintf("(&%s%s)", filter, strings.ReplaceAll(a.config.GroupFilter, UsernamePlaceholder, escaped))
+}
+
+func (a *Authenticator) cached(key [sha256.Size]byte) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	expiry, ok := a.cache[key]
+	return ok && a.now().Before(expiry)
+}
+
+func (a *Authenticator) remember(key [sha256.Size]byte) {
+	if a.config.CacheTTL <= 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	for cachedKey, expiry := range a.cache {
+		if !now.Before(expiry) {
+			delete(a.cache, cachedKey)
+		}
+	}
+	a.cache[key] = now.Add(a.config.CacheTTL)
+}
+
+// ValidateFilter checks that filter, with the username placeholder filled in, is a valid LDAP filter
+func ValidateFilter(filter string) error {
+	if filter == "" {
+		return errors.New("filter must not be empty")
+	}
+	_, err := goldap.CompileFilter(strings.ReplaceAll(filter, UsernamePlaceholder, "user"))
+	return err
+}
@@ -0,0 +1,232 @@
+package ldap
+
+import (
+	"errors"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	goldap "github.com/go-ldap/ldap/v3"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	baseDN       = "dc=example,dc=org"
+	bindDN       = "cn=reader,dc=example,dc=org"
+	bindPassword = "reader-secret"
+	adminsDN     = "cn=admins,ou=groups,dc=example,dc=org"
+)
+
+// directory is an in-process stand-in for an LDAP server, answering binds and searches from its entries
+type directory struct {
+	entries   []directoryEntry
+	dials     int
+	searchErr error
+}
+
+type directoryEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+func newDirectory() *directory {
+	return &directory{
+		entries: []directoryEntry{
+			{dn: bindDN, password: bindPassword, attributes: map[string][]string{"cn": {"reader"}}},
+			{
+				dn:         "uid=alice,ou=people,dc=example,dc=org",
+				password:   "alice-secret",
+				attributes: map[string][]string{"uid": {"alice"}, "objectClass": {"person"}, "memberOf": {adminsDN}},
+			},
+			{
+				dn:         "uid=bob,ou=people,dc=example,dc=org",
+				password:   "bob-secret",
+				attributes: map[string][]string{"uid": {"bob"}, "objectClass": {"person"}},
+			},
+		},
+	}
+}
+
+func (d *directory) dial(Config) (Conn, error) {
+	d.dials++
+	return &directoryConn{directory: d}, nil
+}
+
+type directoryConn struct {
+	directory *directory
+	boundDN   string
+}
+
+func (c *directoryConn) Bind(username, password string) error {
+	for _, entry := range c.directory.entries {
+		if entry.dn == username && entry.password == password {
+			c.boundDN = username
+			return nil
+		}
+	}
+	return goldap.NewError(goldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+}
+
+func (c *directoryConn) Search(searchRequest *goldap.SearchRequest) (*goldap.SearchResult, error) {
+	if c.directory.searchErr != nil {
+		return nil, c.directory.searchErr
+	}
+	if c.boundDN != bindDN {
+		return nil, goldap.NewError(goldap.LDAPResultInsufficientAccessRights, errors.New("anonymous search"))
+	}
+	filter, err := goldap.CompileFilter(searchRequest.Filter)
+	if err != nil {
+		return nil, err
+	}
+	result := &goldap.SearchResult{}
+	for _, entry := range c.directory.entries {
+		if strings.HasSuffix(entry.dn, searchRequest.BaseDN) && matches(filter, entry) {
+			result.Entries = append(result.Entries, goldap.NewEntry(entry.dn, nil))
+		}
+	}
+	return result, nil
+}
+
+func (c *directoryConn) Close() error {
+	return nil
+}
+
+// matches evaluates the and, or, not, equality and presence filters the tests use
+func matches(filter *ber.Packet, entry directoryEntry) bool {
+	switch filter.Tag {
+	case goldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matches(child, entry) {
+				return false
+			}
+		}
+		return true
+	case goldap.FilterOr:
+		for _, child := range filter.Children {
+			if matches(child, entry) {
+				return true
+			}
+		}
+		return false
+	case goldap.FilterNot:
+		return !matches(filter.Children[0], entry)
+	case goldap.FilterEqualityMatch:
+		attribute := ber.DecodeString(filter.Children[0].Data.Bytes())
+		value := ber.DecodeString(filter.Children[1].Data.Bytes())
+		for _, candidate := range entry.values(attribute) {
+			if strings.EqualFold(candidate, value) {
+				return true
+			}
+		}
+		return false
+	case goldap.FilterPresent:
+		return len(entry.values(filter.Data.String())) > 0
+	default:
+		return false
+	}
+}
+
+func (e directoryEntry) values(attribute string) []string {
+	for name, values := range e.attributes {
+		if strings.EqualFold(name, attribute) {
+			return values
+		}
+	}
+	return nil
+}
+
+func newTestAuthenticator(dir *directory, groupFilter string) *Authenticator {
+	return NewAuthenticatorWithDialer(Config{
+		BaseDN:      baseDN,
+		UserFilter:  "(&(objectClass=person)(uid={username}))",
+		GroupFilter: groupFilter,
+		CacheTTL:    time.Minute,
+		BindCredentials: func() (string, string, error) {
+			return bindDN, bindPassword, nil
+		},
+	}, dir.dial)
+}
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name        string
+		groupFilter string
+		username    string
+		password    string
+		want        bool
+	}{
+		{name: "valid credentials", username: "alice", password: "alice-secret", want: true},
+		{name: "wrong password", username: "alice", password: "bob-secret", want: false},
+		{name: "unknown user", username: "carol", password: "alice-secret", want: false},
+		{name: "empty password", username: "alice", password: "", want: false},
+		{name: "filter injection", username: "*", password: "alice-secret", want: false},
+		{name: "bind entry is not a person", username: "reader", password: bindPassword, want: false},
+		{name: "group member", groupFilter: "(memberOf=" + adminsDN + ")", username: "alice", password: "alice-secret", want: true},
+		{name: "not a group member", groupFilter: "(memberOf=" + adminsDN + ")", username: "bob", password: "bob-secret", want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authenticator := newTestAuthenticator(newDirectory(), test.groupFilter)
+			got, err := authenticator.Authenticate(test.username, test.password)
+			if err != nil {
+				t.Fatalf("Authenticate() returned error: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("Authenticate() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticateCache(t *testing.T) {
+	dir := newDirectory()
+	authenticator := newTestAuthenticator(dir, "")
+	now := time.Now()
+	authenticator.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if ok, err := authenticator.Authenticate("alice", "alice-secret"); !ok || err != nil {
+			t.Fatalf("Authenticate() = %v, %v, want true", ok, err)
+		}
+	}
+	if dir.dials != 1 {
+		t.Errorf("directory was asked %d times within the cache ttl, want 1", dir.dials)
+	}
+
+	// a cached password must not let a different password through
+	if ok, _ := authenticator.Authenticate("alice", "wrong"); ok {
+		t.Errorf("Authenticate() accepted a wrong password of a cached user")
+	}
+	// failures are not cached
+	if ok, _ := authenticator.Authenticate("alice", "wrong"); ok || dir.dials != 3 {
+		t.Errorf("Authenticate() = %v after %d dials, want false after 3", ok, dir.dials)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if ok, err := authenticator.Authenticate("alice", "alice-secret"); !ok || err != nil {
+		t.Fatalf("Authenticate() = %v, %v, want true", ok, err)
+	}
+	if dir.dials != 4 {
+		t.Errorf("directory was asked %d times, want the expired entry to be checked again", dir.dials)
+	}
+}
+
+func TestAuthenticateDirectoryError(t *testing.T) {
+	dir := newDirectory()
+	dir.searchErr = goldap.NewError(goldap.ErrorNetwork, errors.New("connection reset"))
+	authenticator := newTestAuthenticator(dir, "")
+	if ok, err := authenticator.Authenticate("alice", "alice-secret"); ok || err == nil {
+		t.Errorf("Authenticate() = %v, %v, want an error", ok, err)
+	}
+}
+
+func TestValidateFilter(t *testing.T) {
+	if err := ValidateFilter("(&(objectClass=person)(uid={username}))"); err != nil {
+		t.Errorf("ValidateFilter() rejected a valid filter: %v", err)
+	}
+	for _, filter := range []string{"", "uid={username}", "(&(uid={username})"} {
+		if err := ValidateFilter(filter); err == nil {
+			t.Errorf("ValidateFilter(%q) accepted an invalid filter", filter)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package mtls
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+)
+
+// CertificateHeader carries the URL encoded PEM client certificate nginx verified, set by nginx only
+// after a successful verification so clients can't send one of their own
+const CertificateHeader = "X-Client-Certificate"
+
+// Validator checks the subject of the client certificate nginx verified
+type Validator struct {
+	allowedSubjects map[string]bool
+}
+
+// NewValidator returns a Validator letting certificates with any of allowedSubjects in, or any verified
+// certificate if empty
+func NewValidator(allowedSubjects []string) *Validator {
+	allowed := make(map[string]bool, len(allowedSubjects))
+	for _, subject := range allowedSubjects {
+		allowed[subject] = true
+	}
+	return &Validator{allowedSubjects: allowed}
+}
+
+// AuthenticateRequest returns the allowed subject of the request's client certificate, or its first
+// subject without an allowed list, if nginx verified one
+func (v *Validator) AuthenticateRequest(r *http.Request) (string, bool) {
+	encoded := r.Header.Get(CertificateHeader)
+	if encoded == "" {
+		return "", false
+	}
+	// nginx escapes '+' as well, so unlike QueryUnescape PathUnescape keeps the base64 intact
+	decoded, err := url.PathUnescape(encoded)
+	if err != nil {
+		return "", false
+	}
+	block, _ := pem.Decode([]byte(decoded))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", false
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", false
+	}
+	subjects := Subjects(certificate)
+	if len(v.allowedSubjects) == 0 {
+		if len(subjects) == 0 {
+			return "", true
+		}
+		return subjects[0], true
+	}
+	for _, subject := range subjects {
+		if v.allowedSubjects[subject] {
+			return subject, true
+		}
+	}
+	return "", false
+}
+
+// Subjects returns the common name of certificate followed by its DNS, email, URI and IP subject
+// alternative names
+func Subjects(certificate *x509.Certificate) []string {
+	var subjects []string
+	if certificate.Subject.CommonName != "" {
+		subjects = append(subjects, certificate.Subject.CommonName)
+	}
+	subjects = append(subjects, certificate.DNSNames...)
+	subjects = append(subjects, certificate.EmailAddresses...)
+	for _, uri := range certificate.URIs {
+		subjects = append(subjects, uri.String())
+	}
+	for _, ip := range certificate.IPAddresses {
+		subjects = append(subjects, ip.String())
+	}
+	return subjects
+}
@@ -0,0 +1,127 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newCertificate(t *testing.T, template *x509.Certificate) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(1)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// nginxEscape escapes content the way nginx renders $ssl_client_escaped_cert, keeping only unreserved
+// characters, or keeping '+' as well if keepPlus is set
+func nginxEscape(content []byte, keepPlus bool) string {
+	var escaped strings.Builder
+	for _, c := range content {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', strings.IndexByte("-._~", c) >= 0:
+			escaped.WriteByte(c)
+		case c == '+' && keepPlus:
+			escaped.WriteByte(c)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
+
+func authenticate(validator *Validator, certificate string) (string, bool) {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	if certificate != "" {
+		request.Header.Set(CertificateHeader, certificate)
+	}
+	return validator.AuthenticateRequest(request)
+}
+
+func TestAuthenticateRequest(t *testing.T) {
+	client := newCertificate(t, &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "payments"},
+		DNSNames:       []string{"payments.example.org"},
+		EmailAddresses: []string{"payments@example.org"},
+		URIs:           []*url.URL{{Scheme: "spiffe", Host: "example.org", Path: "/ns/payments/sa/api"}},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+	})
+	anonymous := newCertificate(t, &x509.Certificate{})
+	escapedClient := nginxEscape(client, false)
+
+	tests := []struct {
+		name            string
+		allowedSubjects []string
+		certificate     string
+		wantSubject     string
+		want            bool
+	}{
+		{name: "any certificate", certificate: escapedClient, wantSubject: "payments", want: true},
+		{name: "any certificate without subjects", certificate: nginxEscape(anonymous, false), wantSubject: "", want: true},
+		{name: "unescaped plus", certificate: nginxEscape(client, true), wantSubject: "payments", want: true},
+		{name: "allowed common name", allowedSubjects: []string{"payments"}, certificate: escapedClient, wantSubject: "payments", want: true},
+		{name: "allowed dns name", allowedSubjects: []string{"payments.example.org"}, certificate: escapedClient, wantSubject: "payments.example.org", want: true},
+		{name: "allowed email", allowedSubjects: []string{"payments@example.org"}, certificate: escapedClient, wantSubject: "payments@example.org", want: true},
+		{name: "allowed uri", allowedSubjects: []string{"spiffe://example.org/ns/payments/sa/api"}, certificate: escapedClient, wantSubject: "spiffe://example.org/ns/payments/sa/api", want: true},
+		{name: "allowed ip", allowedSubjects: []string{"10.0.0.1"}, certificate: escapedClient, wantSubject: "10.0.0.1", want: true},
+		{name: "subject not allowed", allowedSubjects: []string{"billing"}, certificate: escapedClient, want: false},
+		{name: "no subjects with an allowed list", allowedSubjects: []string{"payments"}, certificate: nginxEscape(anonymous, false), want: false},
+		{name: "no certificate", certificate: "", want: false},
+		{name: "malformed escaping", certificate: "%zz" + escapedClient, want: false},
+		{name: "not pem", certificate: "payments", want: false},
+		{name: "public key", certificate: nginxEscape(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("key")}), false), want: false},
+		{name: "private key", certificate: nginxEscape(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")}), false), want: false},
+		{name: "malformed certificate", certificate: nginxEscape(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("certificate")}), false), want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subject, got := authenticate(NewValidator(test.allowedSubjects), test.certificate)
+			if got != test.want {
+				t.Errorf("AuthenticateRequest() = %v, want %v", got, test.want)
+			}
+			if got && subject != test.wantSubject {
+				t.Errorf("AuthenticateRequest() subject = %q, want %q", subject, test.wantSubject)
+			}
+		})
+	}
+}
+
+func TestSubjects(t *testing.T) {
+	content := newCertificate(t, &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "payments"},
+		DNSNames:       []string{"payments.example.org", "payments.internal"},
+		EmailAddresses: []string{"payments@example.org"},
+		URIs:           []*url.URL{{Scheme: "spiffe", Host: "example.org", Path: "/payments"}},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+	})
+	block, _ := pem.Decode(content)
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"payments", "payments.example.org", "payments.internal", "payments@example.org", "spiffe://example.org/payments", "10.0.0.1"}
+	if got := Subjects(certificate); !reflect.DeepEqual(got, want) {
+		t.Errorf("Subjects() = %v, want %v", got, want)
+	}
+}
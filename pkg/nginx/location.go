@@ -0,0 +1,42 @@
+package nginx
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Location modifiers select how nginx matches a location's path against the request URI
+const (
+	PrefixModifier = ""
+	ExactModifier  = "="
+	RegexModifier  = "~"
+)
+
+// ValidateLocationPath checks that path can be rendered as the quoted argument of a location
+// with the given modifier. Regular expressions are compiled with Go's regexp, which accepts
+// most of the PCRE syntax nginx understands.
+func ValidateLocationPath(modifier, path string) error {
+	if path == "" {
+		return fmt.Errorf("empty location path")
+	}
+	if strings.ContainsAny(path, "\"\r\n") {
+		return fmt.Errorf("location path %q must not contain quotes or line breaks", path)
+	}
+	switch modifier {
+	case PrefixModifier, ExactModifier:
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("location path %q must start with /", path)
+		}
+		if strings.ContainsAny(path, " \t\\;{}#'") {
+			return fmt.Errorf("location path %q must not contain whitespace, backslashes, quotes or nginx syntax", path)
+		}
+	case RegexModifier:
+		if _, err := regexp.Compile(path); err != nil {
+			return fmt.Errorf("invalid location regex %q: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("unsupported location modifier %q", modifier)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package nginx
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// deniedDirectives could be used to bypass authentication, read or write arbitrary files
+// on the authenticator or run code inside it. return and rewrite act in the rewrite phase,
+// before the auth checks, and error_page could turn a 401 into a redirect or a success.
+var deniedDirectives = map[string]struct{}{
+	"access_log":            {},
+	"alias":                 {},
+	"allow":                 {},
+	"auth_basic":            {},
+	"auth_basic_user_file":  {},
+	"auth_request":          {},
+	"auth_request_set":      {},
+	"client_body_temp_path": {},
+	"deny":                  {},
+	"error_log":             {},
+	"error_page":            {},
+	"fastcgi_pass":          {},
+	"fastcgi_store":         {},
+	"fastcgi_temp_path":     {},
+	"grpc_pass":             {},
+	"include":               {},
+	"load_module":           {},
+	"location":              {},
+	"memcached_pass":        {},
+	"proxy_pass":            {},
+	"proxy_store":           {},
+	"proxy_temp_path":       {},
+	"return":                {},
+	"rewrite":               {},
+	"root":                  {},
+	"satisfy":               {},
+	"scgi_pass":             {},
+	"scgi_store":            {},
+	"scgi_temp_path":        {},
+	"server":                {},
+	"ssl_certificate":       {},
+	"ssl_certificate_key":   {},
+	"uwsgi_pass":            {},
+	"uwsgi_store":           {},
+	"uwsgi_temp_path":       {},
+}
+
+// deniedDirectivePrefixes cover families of scripting directives
+var deniedDirectivePrefixes = []string{"js_", "lua_", "perl"}
+
+var deniedDirectiveSuffixes = []string{"_by_lua", "_by_lua_block", "_by_lua_file"}
+
+var headerNamePattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// ValidateSnippet checks that snippet is a balanced list of nginx directives, none of which is denied
+func ValidateSnippet(snippet string) error {
+	depth := 0
+	for _, char := range snippet {
+		switch char {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("snippet closes a block it did not open")
+			}
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("snippet has unbalanced braces")
+	}
+
+	statements := strings.FieldsFunc(snippet, func(char rune) bool {
+		return char == ';' || char == '{' || char == '}'
+	})
+	for _, statement := range statements {
+		fields := strings.Fields(stripComments(statement))
+		if len(fields) == 0 {
+			continue
+		}
+		// nginx accepts quoted and escaped directive names
+		directive := strings.ToLower(strings.NewReplacer("\"", "", "'", "", "\\", "").Replace(fields[0]))
+		if isDeniedDirective(directive) {
+			return fmt.Errorf("directive %s is not allowed in snippets", directive)
+		}
+	}
+	return nil
+}
+
+// ValidateHeader checks that a header can be safely rendered as a quoted nginx directive argument
+func ValidateHeader(name, value string) error {
+	if !headerNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid header name %q", name)
+	}
+	if strings.ContainsAny(value, "\"\\\r\n") {
+		return fmt.Errorf("header %s value must not contain quotes, backslashes or line breaks", name)
+	}
+	return nil
+}
+
+func isDeniedDirective(directive string) bool {
+	if _, denied := deniedDirectives[directive]; denied {
+		return true
+	}
+	for _, prefix := range deniedDirectivePrefixes {
+		if strings.HasPrefix(directive, prefix) {
+			return true
+		}
+	}
+	for _, suffix := range deniedDirectiveSuffixes {
+		if strings.HasSuffix(directive, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func stripComments(statement string) string {
+	lines := strings.Split(statement, "\n")
+	for idx, line := range lines {
+		if commentStart := strings.Index(line, "#"); commentStart >= 0 {
+			lines[idx] = line[:commentStart]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,100 @@
+package nginx
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSnippet(t *testing.T) {
+	tests := []struct {
+		name    string
+		snippet string
+		wantErr string
+	}{
+		{name: "empty", snippet: ""},
+		{name: "allowed directives", snippet: "add_header X-Frame-Options DENY;\nclient_max_body_size 10m;"},
+		{name: "allowed block", snippet: "if ($request_method = POST) {\n  add_header X-Post true;\n}"},
+		{name: "comments only", snippet: "# proxy_pass http://example.org;\n"},
+		{name: "comment after directive", snippet: "gzip on; # root /etc"},
+		{name: "denied directive", snippet: "root /etc;", wantErr: "directive root is not allowed"},
+		{name: "denied directive in block", snippet: "if ($host) {\n  alias /etc/;\n}", wantErr: "directive alias is not allowed"},
+		{name: "denied directive after comment", snippet: "# tuning\nproxy_pass http://example.org;", wantErr: "directive proxy_pass is not allowed"},
+		{name: "denied directive without semicolon", snippet: "gzip on; include /etc/nginx/*.conf", wantErr: "directive include is not allowed"},
+		{name: "upper case directive", snippet: "ROOT /etc;", wantErr: "directive root is not allowed"},
+		{name: "quoted directive", snippet: "\"root\" /etc;", wantErr: "directive root is not allowed"},
+		{name: "single quoted directive", snippet: "'alias' /etc/;", wantErr: "directive alias is not allowed"},
+		{name: "escaped directive", snippet: "ro\\ot /etc;", wantErr: "directive root is not allowed"},
+		{name: "return", snippet: "return 200 'ok';", wantErr: "directive return is not allowed"},
+		{name: "return in block", snippet: "if ($http_x_debug) {\n  return 302 https://example.org;\n}", wantErr: "directive return is not allowed"},
+		{name: "rewrite", snippet: "rewrite ^ /.basicauthenticator/login/callback last;", wantErr: "directive rewrite is not allowed"},
+		{name: "error page", snippet: "error_page 401 =200 /public;", wantErr: "directive error_page is not allowed"},
+		{name: "nested location", snippet: "location /admin {\n  return 200;\n}", wantErr: "directive location is not allowed"},
+		{name: "js prefix", snippet: "js_content main.handler;", wantErr: "directive js_content is not allowed"},
+		{name: "lua prefix", snippet: "lua_need_request_body on;", wantErr: "directive lua_need_request_body is not allowed"},
+		{name: "perl prefix", snippet: "perl_set $value 'sub { return 1; }';", wantErr: "directive perl_set is not allowed"},
+		{name: "lua suffix", snippet: "access_by_lua 'ngx.exit(200)';", wantErr: "directive access_by_lua is not allowed"},
+		{name: "lua block suffix", snippet: "content_by_lua_block {\n  ngx.say('ok')\n}", wantErr: "directive content_by_lua_block is not allowed"},
+		{name: "lua file suffix", snippet: "rewrite_by_lua_file /tmp/script.lua;", wantErr: "directive rewrite_by_lua_file is not allowed"},
+		{name: "unopened block", snippet: "gzip on; }", wantErr: "closes a block it did not open"},
+		{name: "unclosed block", snippet: "if ($host) {\n  return 200;", wantErr: "unbalanced braces"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateSnippet(test.snippet)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateSnippet() returned error: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("ValidateSnippet() error = %v, want one containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateSnippetDeniedDirectives(t *testing.T) {
+	for directive := range deniedDirectives {
+		if err := ValidateSnippet(directive + " value;"); err == nil {
+			t.Errorf("ValidateSnippet() accepted %s", directive)
+		}
+	}
+	// directives that write files on the authenticator, send requests to other upstreams or answer before the
+	// auth checks
+	for _, directive := range []string{
+		"proxy_store", "fastcgi_temp_path", "uwsgi_temp_path", "scgi_temp_path",
+		"fastcgi_pass", "uwsgi_pass", "scgi_pass", "grpc_pass", "memcached_pass",
+		"return", "rewrite", "error_page",
+	} {
+		if err := ValidateSnippet(directive + " /tmp/target;"); err == nil {
+			t.Errorf("ValidateSnippet() accepted %s", directive)
+		}
+	}
+}
+
+func TestValidateHeader(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		value     string
+		wantValid bool
+	}{
+		{name: "plain header", header: "X-Frame-Options", value: "DENY", wantValid: true},
+		{name: "value with spaces and symbols", header: "Content-Security-Policy", value: "default-src 'self'; img-src *", wantValid: true},
+		{name: "empty value", header: "X-Empty", value: "", wantValid: true},
+		{name: "empty name", header: "", value: "value"},
+		{name: "space in name", header: "X Frame", value: "DENY"},
+		{name: "colon in name", header: "X-Frame:", value: "DENY"},
+		{name: "underscore in name", header: "X_Frame", value: "DENY"},
+		{name: "quote in value", header: "X-Test", value: "a\" always; root /etc; add_header Y \"b"},
+		{name: "backslash in value", header: "X-Test", value: "a\\"},
+		{name: "carriage return in value", header: "X-Test", value: "a\rb"},
+		{name: "line break in value", header: "X-Test", value: "a\nroot /etc;"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := ValidateHeader(test.header, test.value); (err == nil) != test.wantValid {
+				t.Errorf("ValidateHeader() error = %v, want valid %v", err, test.wantValid)
+			}
+		})
+	}
+}
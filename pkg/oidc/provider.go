@@ -0,0 +1,437 @@
+package oidc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-logr/logr"
+	"github.com/snapp-incubator/simple-authenticator/pkg/authserver"
+	"golang.org/x/oauth2"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// StartPath, CallbackPath and SignOutPath are served below authserver.LoginPath
+	StartPath    = authserver.LoginPath + "start"
+	CallbackPath = authserver.LoginPath + "callback"
+	SignOutPath  = authserver.LoginPath + "sign_out"
+	// OriginalURIHeader carries the URI a user asked for before being sent to log in
+	OriginalURIHeader = "X-Original-URI"
+	// PublicPrefixHeader is the path prefix nginx serves the login endpoints on
+	PublicPrefixHeader = "X-Login-Prefix"
+
+	stateCookieSuffix = "_state"
+	stateTTL          = 10 * time.Minute
+	requestTimeout    = 10 * time.Second
+)
+
+// Config describes the identity provider users log in with and who of them may pass
+type Config struct {
+	IssuerURL string
+	ClientID  string
+	// ClientSecret returns the client secret, read whenever it is needed so a rotated secret is picked up
+	ClientSecret func() (string, error)
+	// RedirectURL is the callback registered at the identity provider, derived from the request if empty
+	RedirectURL string
+	Scopes      []string
+	GroupsClaim string
+	// AllowedGroups and AllowedEmails restrict the users, each only if not empty. Emails starting with @
+	// allow a whole domain.
+	AllowedGroups []string
+	AllowedEmails []string
+	Realm         string
+
+	CookieName   string
+	CookieDomain string
+	CookieExpire time.Duration
+	CookieSecure bool
+}
+
+// Provider logs users in with the authorization code flow and keeps them logged in with a signed cookie
+type Provider struct {
+	config Config
+	logger logr.Logger
+	now    func() time.Time
+
+	mu       sync.Mutex
+	provider *gooidc.Provider
+}
+
+// session is the payload of the signed cookies
+type session struct {
+	User    string `json:"u,omitempty"`
+	State   string `json:"s,omitempty"`
+	Nonce   string `json:"n,omitempty"`
+	Target  string `json:"t,omitempty"`
+	Expires int64  `json:"e"`
+}
+
+// NewProvider returns a Provider, discovering the identity provider on first use
+func NewProvider(config Config, logger logr.Logger) *Provider {
+	return &Provider{config: config, logger: logger, now: time.Now}
+}
+
+// getProvider discovers the identity provider once, retrying on the next request if it failed
+func (p *Provider) getProvider(ctx context.Context) (*gooidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.provider != nil {
+		return p.provider, nil
+	}
+	provider, err := gooidc.NewProvider(ctx, p.config.IssuerURL)
+	if err != nil {
+		return nil, err
+	}
+	p.provider = provider
+	return provider, nil
+}
+
+// AuthenticateRequest returns the user of a valid session cookie
+func (p *Provider) AuthenticateRequest(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(p.config.CookieName)
+	if err != nil {
+		return "", false
+	}
+	var s session
+	if err := p.decode(cookie.Value, &s); err != nil || s.User == "" {
+		return "", false
+	}
+	return s.User, true
+}
+
+// ServeHTTP serves the login endpoints
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case StartPath:
+		p.start(w, r)
+	case CallbackPath:
+		p.callback(w, r)
+	case SignOutPath:
+		p.clearCookie(w, p.config.CookieName)
+		http.Redirect(w, r, "/", http.StatusFound)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// start sends a user to the identity provider. Requests with an Authorization header come from machines,
+// which get the Basic challenge instead.
+func (p *Provider) start(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "" {
+		authserver.Unauthorized(w, p.config.Realm)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+	provider, err := p.getProvider(ctx)
+	if err != nil {
+		p.logger.Error(err, "failed to discover identity provider", "issuer", p.config.IssuerURL)
+		http.Error(w, "identity provider unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	oauth2Config, err := p.oauth2Config(r, provider)
+	if err != nil {
+		p.logger.Error(err, "failed to read client secret")
+		http.Error(w, "identity provider unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	state, err := randomString()
+	if err != nil {
+		http.Error(w, "failed to start login", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		http.Error(w, "failed to start login", http.StatusInternalServerError)
+		return
+	}
+	target := r.Header.Get(OriginalURIHeader)
+	if target == "" {
+		target = r.URL.Query().Get("rd")
+	}
+	stateCookie, err := p.encode(session{State: state, Nonce: nonce, Target: safeTarget(target), Expires: p.now().Add(stateTTL).Unix()})
+	if err != nil {
+		http.Error(w, "failed to start login", http.StatusInternalServerError)
+		return
+	}
+	p.setCookie(w, p.config.CookieName+stateCookieSuffix, stateCookie, stateTTL)
+	http.Redirect(w, r, oauth2Config.AuthCodeURL(state, gooidc.Nonce(nonce)), http.StatusFound)
+}
+
+// callback exchanges the authorization code, checks the user against the allowed groups and emails and
+// starts a session
+func (p *Provider) callback(w http.ResponseWriter, r *http.Request) {
+	stateCookie, err := r.Cookie(p.config.CookieName + stateCookieSuffix)
+	if err != nil {
+		http.Error(w, "login expired, try again", http.StatusBadRequest)
+		return
+	}
+	var state session
+	if err := p.decode(stateCookie.Value, &state); err != nil || state.State == "" || state.State != r.URL.Query().Get("state") {
+		http.Error(w, "invalid login state, try again", http.StatusBadRequest)
+		return
+	}
+	p.clearCookie(w, p.config.CookieName+stateCookieSuffix)
+	if errorCode := r.URL.Query().Get("error"); errorCode != "" {
+		http.Error(w, fmt.Sprintf("login failed: %s", errorCode), http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+	provider, err := p.getProvider(ctx)
+	if err != nil {
+		p.logger.Error(err, "failed to discover identity provider", "issuer", p.config.IssuerURL)
+		http.Error(w, "identity provider unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	oauth2Config, err := p.oauth2Config(r, provider)
+	if err != nil {
+		p.logger.Error(err, "failed to read client secret")
+		http.Error(w, "identity provider unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	token, err := oauth2Config.Exchange(ctx, r.URL.Query().Get("code"))
+	if err != nil {
+		p.logger.Error(err, "failed to exchange authorization code")
+		http.Error(w, "login failed", http.StatusForbidden)
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		http.Error(w, "login failed, no id token", http.StatusForbidden)
+		return
+	}
+	idToken, err := provider.Verifier(&gooidc.Config{ClientID: p.config.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil || idToken.Nonce != state.Nonce {
+		p.logger.Error(err, "invalid id token")
+		http.Error(w, "login failed, invalid id token", http.StatusForbidden)
+		return
+	}
+	claims := make(map[string]interface{})
+	if err := idToken.Claims(&claims); err != nil {
+		http.Error(w, "login failed, invalid id token", http.StatusForbidden)
+		return
+	}
+	user, err := p.authorize(idToken.Subject, claims)
+	if err != nil {
+		p.logger.Info("login denied", "subject", idToken.Subject, "reason", err.Error())
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+
+	sessionCookie, err := p.encode(session{User: user, Expires: p.now().Add(p.config.CookieExpire).Unix()})
+	if err != nil {
+		http.Error(w, "failed to start session", http.StatusInternalServerError)
+		return
+	}
+	p.setCookie(w, p.config.CookieName, sessionCookie, p.config.CookieExpire)
+	http.Redirect(w, r, safeTarget(state.Target), http.StatusFound)
+}
+
+// authorize returns the user of claims if the allowed groups and emails let them in
+func (p *Provider) authorize(subject string, claims map[string]interface{}) (string, error) {
+	email, _ := claims["email"].(string)
+	if len(p.config.AllowedEmails) > 0 {
+		if email == "" {
+			return "", errors.New("no email claim")
+		}
+		if verified, ok := claims["email_verified"].(bool); ok && !verified {
+			return "", errors.New("email not verified")
+		}
+		if !emailAllowed(email, p.config.AllowedEmails) {
+			return "", fmt.Errorf("email %s not allowed", email)
+		}
+	}
+	if len(p.config.AllowedGroups) > 0 && !groupAllowed(claims[p.config.GroupsClaim], p.config.AllowedGroups) {
+		return "", errors.New("not a member of an allowed group")
+	}
+	for _, user := range []string{email, stringClaim(claims, "preferred_username"), subject} {
+		if user != "" {
+			return user, nil
+		}
+	}
+	return "", errors.New("no subject")
+}
+
+func (p *Provider) oauth2Config(r *http.Request, provider *gooidc.Provider) (*oauth2.Config, error) {
+	clientSecret, err := p.config.ClientSecret()
+	if err != nil {
+		return nil, err
+	}
+	redirectURL := p.config.RedirectURL
+	if redirectURL == "" {
+		redirectURL = deriveRedirectURL(r)
+	}
+	return &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: clientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  redirectURL,
+		Scopes:       p.config.Scopes,
+	}, nil
+}
+
+// deriveRedirectURL builds the callback URL from the host and scheme the request reached nginx with
+func deriveRedirectURL(r *http.Request) string {
+	scheme := r.Header.Get("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "http"
+	}
+	host := r.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		host = r.Host
+	}
+	prefix := strings.TrimSuffix(r.Header.Get(PublicPrefixHeader), "/")
+	return (&url.URL{Scheme: scheme, Host: host, Path: prefix + "/callback"}).String()
+}
+
+// safeTarget keeps redirects after a login on the same host
+func safeTarget(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
+
+func emailAllowed(email string, allowed []string) bool {
+	email = strings.ToLower(email)
+	for _, entry := range allowed {
+		entry = strings.ToLower(entry)
+		if strings.HasPrefix(entry, "@") && strings.HasSuffix(email, entry) || email == entry {
+			return true
+		}
+	}
+	return false
+}
+
+func groupAllowed(claim interface{}, allowed []string) bool {
+	var groups []string
+	switch value := claim.(type) {
+	case string:
+		groups = []string{value}
+	case []interface{}:
+		for _, group := range value {
+			if group, ok := group.(string); ok {
+				groups = append(groups, group)
+			}
+		}
+	}
+	for _, group := range groups {
+		for _, allowedGroup := range allowed {
+			if group == allowedGroup {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func stringClaim(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// cookieKey signs the cookies. It is derived from the client secret, so every replica shares it and
+// rotating the secret ends all sessions, and from the allowed users, so changing them does as well.
+func (p *Provider) cookieKey() ([]byte, error) {
+	clientSecret, err := p.config.ClientSecret()
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, []byte(clientSecret))
+	mac.Write([]byte("cookie\x00" + p.config.ClientID + "\x00" + strings.Join(p.config.AllowedGroups, ",") + "\x00" + strings.Join(p.config.AllowedEmails, ",")))
+	return mac.Sum(nil), nil
+}
+
+func (p *Provider) encode(s session) (string, error) {
+	key, err := p.cookieKey()
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(encodedPayload))
+	return encodedPayload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (p *Provider) decode(value string, s *session) error {
+	encodedPayload, encodedSignature, found := strings.Cut(value, ".")
+	if !found {
+		return errors.New("malformed cookie")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return err
+	}
+	key, err := p.cookieKey()
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(encodedPayload))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return errors.New("invalid cookie signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(payload, s); err != nil {
+		return err
+	}
+	if p.now().Unix() >= s.Expires {
+		return errors.New("cookie expired")
+	}
+	return nil
+}
+
+func (p *Provider) setCookie(w http.ResponseWriter, name, value string, ttl time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   p.config.CookieDomain,
+		Expires:  p.now().Add(ttl),
+		MaxAge:   int(ttl.Seconds()),
+		Secure:   p.config.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (p *Provider) clearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     "/",
+		Domain:   p.config.CookieDomain,
+		MaxAge:   -1,
+		Secure:   p.config.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func randomString() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,231 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/go-logr/logr"
+	"github.com/snapp-incubator/simple-authenticator/pkg/authserver"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	clientID     = "simple-authenticator"
+	clientSecret = "client-secret"
+)
+
+// identityProvider is an in-process stand-in for an OIDC provider, issuing an id token with its claims
+// for every authorization code
+type identityProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+	nonce  string
+}
+
+func newIdentityProvider(t *testing.T) *identityProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &identityProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"jwks_uri":                              idp.server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != clientID || secret != clientSecret {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", "test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims := map[string]interface{}{
+			"iss":   idp.server.URL,
+			"aud":   clientID,
+			"sub":   "1234",
+			"nonce": idp.nonce,
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		}
+		for name, value := range idp.claims {
+			claims[name] = value
+		}
+		idToken, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeJSON(w, map[string]interface{}{"access_token": "access", "token_type": "Bearer", "id_token": idToken})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func newTestProvider(idp *identityProvider, allowedGroups, allowedEmails []string) *Provider {
+	return NewProvider(Config{
+		IssuerURL:     idp.server.URL,
+		ClientID:      clientID,
+		ClientSecret:  func() (string, error) { return clientSecret, nil },
+		RedirectURL:   "https://app.example.org/.basicauthenticator/login/callback",
+		Scopes:        []string{"openid", "email"},
+		GroupsClaim:   "groups",
+		AllowedGroups: allowedGroups,
+		AllowedEmails: allowedEmails,
+		Realm:         "test",
+		CookieName:    "_session",
+		CookieExpire:  time.Hour,
+	}, logr.Discard())
+}
+
+// login runs the authorization code flow for target and returns the callback's response
+func login(t *testing.T, provider *Provider, idp *identityProvider, target string) *http.Response {
+	start := httptest.NewRequest(http.MethodGet, StartPath, nil)
+	start.Header.Set(OriginalURIHeader, target)
+	startRecorder := httptest.NewRecorder()
+	provider.ServeHTTP(startRecorder, start)
+	if startRecorder.Code != http.StatusFound {
+		t.Fatalf("start answered %d, want a redirect", startRecorder.Code)
+	}
+	authorizeURL, err := url.Parse(startRecorder.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(authorizeURL.String(), idp.server.URL+"/authorize") {
+		t.Fatalf("start redirected to %q, want the identity provider", startRecorder.Header().Get("Location"))
+	}
+	idp.nonce = authorizeURL.Query().Get("nonce")
+
+	callback := httptest.NewRequest(http.MethodGet, CallbackPath+"?code=code&state="+url.QueryEscape(authorizeURL.Query().Get("state")), nil)
+	for _, cookie := range startRecorder.Result().Cookies() {
+		callback.AddCookie(cookie)
+	}
+	callbackRecorder := httptest.NewRecorder()
+	provider.ServeHTTP(callbackRecorder, callback)
+	return callbackRecorder.Result()
+}
+
+func sessionCookie(response *http.Response) *http.Cookie {
+	for _, cookie := range response.Cookies() {
+		if cookie.Name == "_session" && cookie.Value != "" {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		name          string
+		claims        map[string]interface{}
+		allowedGroups []string
+		allowedEmails []string
+		target        string
+		wantUser      string
+		wantLocation  string
+	}{
+		{name: "any user", claims: map[string]interface{}{"email": "alice@example.org"}, target: "/dashboard?tab=1", wantUser: "alice@example.org", wantLocation: "/dashboard?tab=1"},
+		{name: "allowed group", claims: map[string]interface{}{"email": "alice@example.org", "groups": []string{"dev", "admins"}}, allowedGroups: []string{"admins"}, target: "/", wantUser: "alice@example.org", wantLocation: "/"},
+		{name: "not in an allowed group", claims: map[string]interface{}{"email": "bob@example.org", "groups": []string{"dev"}}, allowedGroups: []string{"admins"}, target: "/"},
+		{name: "allowed email domain", claims: map[string]interface{}{"email": "alice@example.org"}, allowedEmails: []string{"@example.org"}, target: "/", wantUser: "alice@example.org", wantLocation: "/"},
+		{name: "other email domain", claims: map[string]interface{}{"email": "mallory@example.org.evil"}, allowedEmails: []string{"@example.org"}, target: "/"},
+		{name: "unverified email", claims: map[string]interface{}{"email": "alice@example.org", "email_verified": false}, allowedEmails: []string{"alice@example.org"}, target: "/"},
+		{name: "without an email", claims: map[string]interface{}{"preferred_username": "alice"}, target: "/", wantUser: "alice", wantLocation: "/"},
+		{name: "open redirect", claims: map[string]interface{}{"email": "alice@example.org"}, target: "//evil.example.com/", wantUser: "alice@example.org", wantLocation: "/"},
+		{name: "absolute redirect", claims: map[string]interface{}{"email": "alice@example.org"}, target: "https://evil.example.com/", wantUser: "alice@example.org", wantLocation: "/"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			idp := newIdentityProvider(t)
+			idp.claims = test.claims
+			provider := newTestProvider(idp, test.allowedGroups, test.allowedEmails)
+
+			response := login(t, provider, idp, test.target)
+			if test.wantUser == "" {
+				if response.StatusCode != http.StatusForbidden || sessionCookie(response) != nil {
+					t.Fatalf("callback answered %d, want a 403 without a session", response.StatusCode)
+				}
+				return
+			}
+			if response.StatusCode != http.StatusFound || response.Header.Get("Location") != test.wantLocation {
+				t.Fatalf("callback answered %d to %q, want a redirect to %q", response.StatusCode, response.Header.Get("Location"), test.wantLocation)
+			}
+			cookie := sessionCookie(response)
+			if cookie == nil {
+				t.Fatal("callback set no session cookie")
+			}
+			request := httptest.NewRequest(http.MethodGet, authserver.AuthPath, nil)
+			request.AddCookie(cookie)
+			if user, ok := provider.AuthenticateRequest(request); !ok || user != test.wantUser {
+				t.Errorf("AuthenticateRequest() = %q, %v, want %q", user, ok, test.wantUser)
+			}
+		})
+	}
+}
+
+func TestSessionCookie(t *testing.T) {
+	idp := newIdentityProvider(t)
+	idp.claims = map[string]interface{}{"email": "alice@example.org"}
+	provider := newTestProvider(idp, nil, nil)
+	cookie := sessionCookie(login(t, provider, idp, "/"))
+	if cookie == nil {
+		t.Fatal("callback set no session cookie")
+	}
+	authenticate := func(value string) bool {
+		request := httptest.NewRequest(http.MethodGet, authserver.AuthPath, nil)
+		request.AddCookie(&http.Cookie{Name: cookie.Name, Value: value})
+		_, ok := provider.AuthenticateRequest(request)
+		return ok
+	}
+
+	_, signature, _ := strings.Cut(cookie.Value, ".")
+	tampered := base64.RawURLEncoding.EncodeToString([]byte(`{"u":"mallory@example.org","e":4102444800}`)) + "." + signature
+	if authenticate(tampered) {
+		t.Error("AuthenticateRequest() accepted a tampered cookie")
+	}
+	// a session of one authenticator must not pass another allowing fewer users
+	if _, ok := newTestProvider(idp, []string{"admins"}, nil).AuthenticateRequest(func() *http.Request {
+		request := httptest.NewRequest(http.MethodGet, authserver.AuthPath, nil)
+		request.AddCookie(cookie)
+		return request
+	}()); ok {
+		t.Error("AuthenticateRequest() accepted a cookie signed for other allowed groups")
+	}
+
+	provider.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if authenticate(cookie.Value) {
+		t.Error("AuthenticateRequest() accepted an expired cookie")
+	}
+}
+
+func TestStartWithAuthorizationHeader(t *testing.T) {
+	provider := newTestProvider(newIdentityProvider(t), nil, nil)
+	request := httptest.NewRequest(http.MethodGet, StartPath, nil)
+	request.SetBasicAuth("alice", "wrong")
+	recorder := httptest.NewRecorder()
+	provider.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusUnauthorized || recorder.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("start answered %d, want a Basic challenge for clients sending credentials", recorder.Code)
+	}
+}